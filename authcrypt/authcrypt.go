@@ -0,0 +1,227 @@
+// Package authcrypt encrypts Steam game authentication codes at rest.
+//
+// Values are envelope encrypted: every value is sealed with AES-256-GCM under
+// its own random data key, and the data key is sealed under a key encryption
+// key (KEK) from the environment or a key file. Encrypted values look like
+//
+//	enc:v2:<key id>:<wrapped data key>:<ciphertext>
+//
+// so the KEK that wrapped a value can be found again after a key rotation.
+// The ciphertext is bound to additional data naming its owner, so a value
+// copied to another owner fails to decrypt. enc:v1 values were sealed
+// without it and still decrypt until they are rotated. Values without a
+// prefix are legacy plaintext and are returned unchanged by Decrypt.
+package authcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	prefix        = "enc:v2:"
+	unboundPrefix = "enc:v1:"
+	keySize       = 32
+)
+
+var (
+	// ErrNoKey is returned when neither AUTH_CODE_KEY nor AUTH_CODE_KEY_FILE is set
+	ErrNoKey = errors.New("AUTH_CODE_KEY or AUTH_CODE_KEY_FILE must be set")
+	// ErrUnknownKey is returned when a value was encrypted under a key that is not in the keyring
+	ErrUnknownKey = errors.New("value was encrypted with an unknown key")
+	// ErrMalformed is returned when an encrypted value cannot be parsed
+	ErrMalformed = errors.New("malformed encrypted value")
+)
+
+// Keyring holds the primary KEK used for encryption and any previous KEKs still accepted for decryption
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring that encrypts with primary and decrypts with primary or any previous key
+func NewKeyring(primary []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	for n, key := range append([][]byte{primary}, previous...) {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		id := keyID(key)
+		if n == 0 {
+			k.primary = id
+		}
+		k.keys[id] = aead
+	}
+	return k, nil
+}
+
+// LoadKeyring reads base64 encoded 32 byte keys from the environment.
+// AUTH_CODE_KEY holds the primary key and AUTH_CODE_PREVIOUS_KEYS a comma separated
+// list of retired keys. Alternatively AUTH_CODE_KEY_FILE names a file with one key
+// per line, the first being the primary key.
+func LoadKeyring() (*Keyring, error) {
+	var encoded []string
+	if path := os.Getenv("AUTH_CODE_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read auth code key file: %w", err)
+		}
+		encoded = strings.Split(string(data), "\n")
+	} else if key := os.Getenv("AUTH_CODE_KEY"); key != "" {
+		encoded = append([]string{key}, strings.Split(os.Getenv("AUTH_CODE_PREVIOUS_KEYS"), ",")...)
+	} else {
+		return nil, ErrNoKey
+	}
+
+	var keys [][]byte
+	for _, value := range encoded {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("auth code key is not valid base64: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoKey
+	}
+
+	return NewKeyring(keys[0], keys[1:]...)
+}
+
+// Encrypt seals plaintext for owner under a fresh data key wrapped with the primary key
+func (k *Keyring) Encrypt(plaintext, owner string) (string, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(dataAEAD, []byte(plaintext), []byte(owner))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := seal(k.keys[k.primary], dataKey, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + strings.Join([]string{
+		k.primary,
+		base64.RawStdEncoding.EncodeToString(wrapped),
+		base64.RawStdEncoding.EncodeToString(sealed),
+	}, ":"), nil
+}
+
+// Decrypt opens a value produced by Encrypt for owner. Legacy plaintext values are returned as is.
+func (k *Keyring) Decrypt(value, owner string) (string, error) {
+	var additionalData []byte
+	switch {
+	case strings.HasPrefix(value, prefix):
+		value = strings.TrimPrefix(value, prefix)
+		additionalData = []byte(owner)
+	case strings.HasPrefix(value, unboundPrefix):
+		value = strings.TrimPrefix(value, unboundPrefix)
+	default:
+		return value, nil
+	}
+
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return "", ErrMalformed
+	}
+	kek, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("%w %s", ErrUnknownKey, parts[0])
+	}
+
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrMalformed
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	dataKey, err := open(kek, wrapped, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataAEAD, sealed, additionalData)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+
+	return string(plaintext), nil
+}
+
+// NeedsRotation reports whether a value is plaintext, not bound to its owner or was not
+// encrypted under the primary key
+func (k *Keyring) NeedsRotation(value string) bool {
+	return !strings.HasPrefix(value, prefix+k.primary+":")
+}
+
+// IsEncrypted reports whether a value was produced by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix) || strings.HasPrefix(value, unboundPrefix)
+}
+
+// keyID identifies a KEK without revealing it
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("auth code key must be %d bytes, got %d", keySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return aead, nil
+}
+
+// seal encrypts data bound to additionalData and prepends the random nonce
+func seal(aead cipher.AEAD, data, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, data, additionalData), nil
+}
+
+// open splits off the nonce and decrypts data produced by seal
+func open(aead cipher.AEAD, data, additionalData []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
@@ -0,0 +1,219 @@
+package authcrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	testAuthCode = "AAAA-BBBBB-CCCC"
+	testOwner    = "6f1c6a2e-5d6b-4a8e-9d3c-2b7e8f0a1c4d"
+)
+
+// testKey returns a 32 byte key filled with b
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, keySize)
+}
+
+func newTestKeyring(t *testing.T, primary []byte, previous ...[]byte) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(primary, previous...)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	return k
+}
+
+// tamper flips a bit of the base64 part of an encrypted value at index, offset bytes into the decoded data
+func tamper(t *testing.T, value string, index, offset int) string {
+	t.Helper()
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	data, err := base64.RawStdEncoding.DecodeString(parts[index])
+	if err != nil {
+		t.Fatalf("failed to decode part %d: %v", index, err)
+	}
+	data[offset] ^= 1
+	parts[index] = base64.RawStdEncoding.EncodeToString(data)
+	return prefix + strings.Join(parts, ":")
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	k := newTestKeyring(t, testKey(1))
+
+	encrypted, err := k.Encrypt(testAuthCode, testOwner)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if !IsEncrypted(encrypted) || strings.Contains(encrypted, testAuthCode) {
+		t.Fatalf("Encrypt() = %q, want an encrypted value", encrypted)
+	}
+
+	again, err := k.Encrypt(testAuthCode, testOwner)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if again == encrypted {
+		t.Error("Encrypt() returned the same value twice")
+	}
+
+	decrypted, err := k.Decrypt(encrypted, testOwner)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if decrypted != testAuthCode {
+		t.Errorf("Decrypt() = %q, want %q", decrypted, testAuthCode)
+	}
+	if k.NeedsRotation(encrypted) {
+		t.Error("NeedsRotation() = true for a value encrypted under the primary key")
+	}
+}
+
+func TestDecryptForAnotherOwner(t *testing.T) {
+	k := newTestKeyring(t, testKey(1))
+
+	encrypted, err := k.Encrypt(testAuthCode, testOwner)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if _, err := k.Decrypt(encrypted, "another-user"); err == nil {
+		t.Error("Decrypt() succeeded for a value copied to another owner")
+	}
+}
+
+func TestDecryptAfterRotation(t *testing.T) {
+	old := newTestKeyring(t, testKey(1))
+	encrypted, err := old.Encrypt(testAuthCode, testOwner)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	rotated := newTestKeyring(t, testKey(2), testKey(1))
+	if !rotated.NeedsRotation(encrypted) {
+		t.Error("NeedsRotation() = false for a value encrypted under a previous key")
+	}
+	decrypted, err := rotated.Decrypt(encrypted, testOwner)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if decrypted != testAuthCode {
+		t.Errorf("Decrypt() = %q, want %q", decrypted, testAuthCode)
+	}
+
+	reencrypted, err := rotated.Encrypt(decrypted, testOwner)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if rotated.NeedsRotation(reencrypted) {
+		t.Error("NeedsRotation() = true after re-encrypting under the primary key")
+	}
+
+	// Once the previous key is retired the old value can't be read anymore
+	retired := newTestKeyring(t, testKey(2))
+	if _, err := retired.Decrypt(encrypted, testOwner); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt() error = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestDecryptUnknownKey(t *testing.T) {
+	encrypted, err := newTestKeyring(t, testKey(1)).Encrypt(testAuthCode, testOwner)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	_, err = newTestKeyring(t, testKey(3)).Decrypt(encrypted, testOwner)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt() error = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestDecryptLegacyPlaintext(t *testing.T) {
+	k := newTestKeyring(t, testKey(1))
+
+	if IsEncrypted(testAuthCode) {
+		t.Error("IsEncrypted() = true for plaintext")
+	}
+	decrypted, err := k.Decrypt(testAuthCode, testOwner)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if decrypted != testAuthCode {
+		t.Errorf("Decrypt() = %q, want %q", decrypted, testAuthCode)
+	}
+	if !k.NeedsRotation(testAuthCode) {
+		t.Error("NeedsRotation() = false for plaintext")
+	}
+}
+
+func TestDecryptUnboundValue(t *testing.T) {
+	k := newTestKeyring(t, testKey(1))
+
+	// Values encrypted before they were bound to their owner
+	dataKey := testKey(9)
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		t.Fatalf("newAEAD() error = %v", err)
+	}
+	sealed, err := seal(dataAEAD, []byte(testAuthCode), nil)
+	if err != nil {
+		t.Fatalf("seal() error = %v", err)
+	}
+	wrapped, err := seal(k.keys[k.primary], dataKey, nil)
+	if err != nil {
+		t.Fatalf("seal() error = %v", err)
+	}
+	unbound := unboundPrefix + k.primary + ":" + base64.RawStdEncoding.EncodeToString(wrapped) + ":" + base64.RawStdEncoding.EncodeToString(sealed)
+
+	if !IsEncrypted(unbound) {
+		t.Error("IsEncrypted() = false for an unbound value")
+	}
+	decrypted, err := k.Decrypt(unbound, testOwner)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if decrypted != testAuthCode {
+		t.Errorf("Decrypt() = %q, want %q", decrypted, testAuthCode)
+	}
+	if !k.NeedsRotation(unbound) {
+		t.Error("NeedsRotation() = false for an unbound value")
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	k := newTestKeyring(t, testKey(1))
+	encrypted, err := k.Encrypt(testAuthCode, testOwner)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"ciphertext", tamper(t, encrypted, 2, len(testAuthCode))},
+		{"nonce", tamper(t, encrypted, 2, 0)},
+		{"wrapped data key", tamper(t, encrypted, 1, 20)},
+		{"wrapped data key nonce", tamper(t, encrypted, 1, 0)},
+		{"truncated", encrypted[:len(prefix)+20]},
+		{"missing part", strings.Join(strings.Split(encrypted, ":")[:4], ":")},
+		{"invalid base64", encrypted + "!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if decrypted, err := k.Decrypt(tt.value, testOwner); err == nil {
+				t.Errorf("Decrypt() = %q, want an error", decrypted)
+			}
+		})
+	}
+}
+
+func TestNewKeyringRejectsShortKeys(t *testing.T) {
+	if _, err := NewKeyring(make([]byte, 16)); err == nil {
+		t.Error("NewKeyring() accepted a 16 byte key")
+	}
+	if _, err := NewKeyring(testKey(1), make([]byte, 31)); err == nil {
+		t.Error("NewKeyring() accepted a 31 byte previous key")
+	}
+}
@@ -0,0 +1,33 @@
+// Command fakedemo serves the fake demo service over HTTP, so the bot can run the whole
+// demo pipeline locally by pointing DEMO_PARSE_BASE_URL at it:
+//
+//	go run ./cmd/fakedemo -addr localhost:8081 -fixtures ./fixtures -steam-ids 76561197960287930
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"cs-match-summary-bot/demoservice"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8081", "Address to listen on")
+	fixtures := flag.String("fixtures", "", "Directory with <share_code>.json fixtures overriding the built-in ones")
+	delay := flag.Duration("delay", 2*time.Second, "How long to wait before calling back")
+	steamIDs := flag.String("steam-ids", "", "Comma-separated SteamID64s replacing the fixture players' IDs in order")
+	allowRemote := flag.Bool("allow-remote-callbacks", false, "Call back webhook URLs on other hosts than localhost")
+	flag.Parse()
+
+	fake := demoservice.NewFake(*fixtures)
+	fake.Delay = *delay
+	fake.SteamIDs = demoservice.SplitSteamIDs(*steamIDs)
+	fake.AllowRemoteCallbacks = *allowRemote
+
+	log.Printf("Fake demo service listening on %s", *addr)
+	if err := http.ListenAndServe(*addr, fake); err != nil {
+		log.Fatal("Failed to start fake demo service: ", err)
+	}
+}
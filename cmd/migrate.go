@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 
+	"cs-match-summary-bot/authcrypt"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
@@ -45,10 +46,146 @@ CREATE TABLE IF NOT EXISTS games (
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
 
+CREATE TABLE IF NOT EXISTS match_meta (
+    game_uuid UUID PRIMARY KEY REFERENCES games(uuid) ON DELETE CASCADE,
+    map VARCHAR(64) NOT NULL,
+    team_a_name VARCHAR(255) NOT NULL DEFAULT '',
+    team_a_score INTEGER NOT NULL,
+    team_a_starting_side VARCHAR(2) NOT NULL,
+    team_b_name VARCHAR(255) NOT NULL DEFAULT '',
+    team_b_score INTEGER NOT NULL,
+    team_b_starting_side VARCHAR(2) NOT NULL,
+    rounds_played INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS match_players (
+    game_uuid UUID NOT NULL REFERENCES games(uuid) ON DELETE CASCADE,
+    steam_id VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    team VARCHAR(16) NOT NULL,
+    result VARCHAR(4) NOT NULL,
+    kills INTEGER NOT NULL,
+    deaths INTEGER NOT NULL,
+    assists INTEGER NOT NULL,
+    adr DOUBLE PRECISION,
+    hs_pct DOUBLE PRECISION,
+    kast DOUBLE PRECISION,
+    rating DOUBLE PRECISION,
+    mvps INTEGER,
+    utility_damage INTEGER,
+    entry_kills INTEGER,
+    clutches INTEGER,
+    PRIMARY KEY (game_uuid, steam_id)
+);
+
+CREATE TABLE IF NOT EXISTS match_rounds (
+    game_uuid UUID NOT NULL REFERENCES games(uuid) ON DELETE CASCADE,
+    round_number INTEGER NOT NULL,
+    winner_team VARCHAR(16) NOT NULL,
+    winner_side VARCHAR(2) NOT NULL,
+    reason VARCHAR(32) NOT NULL,
+    PRIMARY KEY (game_uuid, round_number)
+);
+
+-- Steam persona names and avatars, refreshed once older than the cache TTL
+CREATE TABLE IF NOT EXISTS steam_profiles (
+    steam_id VARCHAR(255) PRIMARY KEY,
+    persona_name VARCHAR(255) NOT NULL DEFAULT '',
+    avatar_url TEXT NOT NULL DEFAULT '',
+    profile_url TEXT NOT NULL DEFAULT '',
+    fetched_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Audit trail of /remove; no foreign keys so entries outlive the removed user
+CREATE TABLE IF NOT EXISTS user_removals (
+    uuid UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    guild_id VARCHAR(255) NOT NULL,
+    user_uuid UUID NOT NULL,
+    steam_id VARCHAR(255) NOT NULL,
+    actor_discord_id VARCHAR(32) NOT NULL,
+    actor_role VARCHAR(16) NOT NULL,
+    deleted_globally BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Steam polling state per user; users without a row are polled every cycle
+CREATE TABLE IF NOT EXISTS user_poll_states (
+    user_uuid UUID PRIMARY KEY REFERENCES users(uuid) ON DELETE CASCADE,
+    status VARCHAR(32) NOT NULL DEFAULT 'active',
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    last_success_at TIMESTAMP WITH TIME ZONE,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_poll_at TIMESTAMP WITH TIME ZONE,
+    notified_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- One job per share code; the unique constraint makes requesting a demo idempotent
+-- across restarts and bot replicas
+CREATE TABLE IF NOT EXISTS match_jobs (
+    uuid UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    share_code VARCHAR(255) NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'discovered',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT match_jobs_share_code_key UNIQUE (share_code)
+);
+
+-- Guilds a match summary was posted to, so retries only send it to the guilds that missed it
+CREATE TABLE IF NOT EXISTS match_notifications (
+    game_uuid UUID NOT NULL REFERENCES games(uuid) ON DELETE CASCADE,
+    guild_id VARCHAR(255) NOT NULL,
+    sent_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (game_uuid, guild_id)
+);
+
 -- Create indexes for better performance
 CREATE INDEX IF NOT EXISTS idx_guilds_guild_id ON guilds(guild_id);
 CREATE INDEX IF NOT EXISTS idx_users_steam_id ON users(steam_id);
 CREATE INDEX IF NOT EXISTS idx_games_share_code ON games(share_code);
+CREATE INDEX IF NOT EXISTS idx_match_players_steam_id ON match_players(steam_id);
+CREATE INDEX IF NOT EXISTS idx_match_meta_map ON match_meta(map);
+CREATE INDEX IF NOT EXISTS idx_user_removals_guild_id ON user_removals(guild_id, created_at);
+
+-- Decoded share code fields (0 when the share code could not be decoded)
+ALTER TABLE games ADD COLUMN IF NOT EXISTS match_id BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS outcome_id BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS token INTEGER NOT NULL DEFAULT 0;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_games_match_id ON games(match_id) WHERE match_id <> 0;
+
+-- Parsed match stats as received from the demo service
+ALTER TABLE games ADD COLUMN IF NOT EXISTS stats JSONB;
+
+-- Auth codes are stored envelope encrypted, so leave room for the ciphertext
+ALTER TABLE users ALTER COLUMN auth_code TYPE TEXT;
+
+-- Discord member a Steam user is linked to ('' when unlinked); one member can link several Steam accounts
+ALTER TABLE users ADD COLUMN IF NOT EXISTS discord_user_id VARCHAR(32) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_users_discord_user_id ON users(discord_user_id) WHERE discord_user_id <> '';
+
+-- Match job state machine: per-stage timestamps, attempts at the current stage,
+-- the last error and when the reconciler acts on the job next
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS last_error TEXT NOT NULL DEFAULT '';
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS download_requested_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS demo_ready_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS parse_requested_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS parsed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS notified_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS failed_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_match_jobs_due ON match_jobs(next_attempt_at) WHERE status NOT IN ('notified', 'failed');
+
+-- Registered players whose polling found a match job, so jobs can be shown per guild
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS steam_ids JSONB NOT NULL DEFAULT '[]';
+
+-- Nonce of the signed callback URL the demo service was last handed, cleared once used
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS callback_nonce VARCHAR(64) NOT NULL DEFAULT '';
+
+-- End of the lease taken by whoever runs the job's current step, so it runs once at a time
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS leased_until TIMESTAMP WITH TIME ZONE;
 
 -- Create triggers to automatically update updated_at timestamps
 CREATE OR REPLACE FUNCTION update_updated_at_column()
@@ -62,6 +199,8 @@ $$ language 'plpgsql';
 CREATE OR REPLACE TRIGGER update_guilds_updated_at BEFORE UPDATE ON guilds FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 CREATE OR REPLACE TRIGGER update_users_updated_at BEFORE UPDATE ON users FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 CREATE OR REPLACE TRIGGER update_games_updated_at BEFORE UPDATE ON games FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE OR REPLACE TRIGGER update_match_meta_updated_at BEFORE UPDATE ON match_meta FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE OR REPLACE TRIGGER update_match_jobs_updated_at BEFORE UPDATE ON match_jobs FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 `
 
 func initDB() error {
@@ -69,22 +208,22 @@ func initDB() error {
 	if host == "" {
 		host = "localhost"
 	}
-	
+
 	port := os.Getenv("DB_PORT")
 	if port == "" {
 		port = "5432"
 	}
-	
+
 	user := os.Getenv("DB_USER")
 	if user == "" {
 		user = "postgres"
 	}
-	
+
 	password := os.Getenv("DB_PASSWORD")
 	if password == "" {
 		password = "postgres"
 	}
-	
+
 	dbname := os.Getenv("DB_NAME")
 	if dbname == "" {
 		dbname = "cs"
@@ -124,6 +263,14 @@ func initializeTables() error {
 
 func dropTables() error {
 	dropSQL := `
+		DROP TABLE IF EXISTS match_notifications CASCADE;
+		DROP TABLE IF EXISTS match_jobs CASCADE;
+		DROP TABLE IF EXISTS user_poll_states CASCADE;
+		DROP TABLE IF EXISTS steam_profiles CASCADE;
+		DROP TABLE IF EXISTS user_removals CASCADE;
+		DROP TABLE IF EXISTS match_rounds CASCADE;
+		DROP TABLE IF EXISTS match_players CASCADE;
+		DROP TABLE IF EXISTS match_meta CASCADE;
 		DROP TABLE IF EXISTS games CASCADE;
 		DROP TABLE IF EXISTS users CASCADE;
 		DROP TABLE IF EXISTS guilds CASCADE;
@@ -138,9 +285,63 @@ func dropTables() error {
 	return nil
 }
 
+// rotateAuthCodes re-encrypts every auth code that is plaintext, not bound to its user or
+// not encrypted under the primary key, in one transaction so a failure leaves no mixed state
+func rotateAuthCodes(keys *authcrypt.Keyring) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT uuid, steam_id, auth_code FROM users FOR UPDATE`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get auth codes: %w", err)
+	}
+
+	type authCode struct {
+		uuid, steamID, value string
+	}
+	var stale []authCode
+	for rows.Next() {
+		var code authCode
+		if err := rows.Scan(&code.uuid, &code.steamID, &code.value); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan auth code: %w", err)
+		}
+		if keys.NeedsRotation(code.value) {
+			stale = append(stale, code)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating over auth codes: %w", err)
+	}
+
+	for _, code := range stale {
+		plaintext, err := keys.Decrypt(code.value, code.uuid)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt auth code of %s: %w", code.steamID, err)
+		}
+		encrypted, err := keys.Encrypt(plaintext, code.uuid)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encrypt auth code of %s: %w", code.steamID, err)
+		}
+		if _, err := tx.Exec(`UPDATE users SET auth_code = $2 WHERE uuid = $1`, code.uuid, encrypted); err != nil {
+			return 0, fmt.Errorf("failed to store auth code of %s: %w", code.steamID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit rotation: %w", err)
+	}
+	return len(stale), nil
+}
+
 func main() {
 	var drop = flag.Bool("drop", false, "Drop all tables before creating them")
 	var reset = flag.Bool("reset", false, "Drop and recreate all tables (same as -drop)")
+	var rotate = flag.Bool("rotate-auth-codes", false, "Re-encrypt all auth codes with the primary key, including legacy plaintext ones")
 	flag.Parse()
 
 	if err := godotenv.Load("../.env"); err != nil {
@@ -165,4 +366,17 @@ func main() {
 		log.Fatal("Failed to create tables: ", err)
 	}
 	fmt.Println("Migration completed successfully!")
-}
\ No newline at end of file
+
+	if *rotate {
+		keys, err := authcrypt.LoadKeyring()
+		if err != nil {
+			log.Fatal("Failed to load auth code keys: ", err)
+		}
+		fmt.Println("Rotating auth codes...")
+		rotated, err := rotateAuthCodes(keys)
+		if err != nil {
+			log.Fatal("Failed to rotate auth codes: ", err)
+		}
+		fmt.Printf("Re-encrypted %d auth codes\n", rotated)
+	}
+}
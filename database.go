@@ -1,10 +1,16 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
+	"cs-match-summary-bot/sharecode"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 // InitializeTables creates all necessary tables in the database
@@ -14,6 +20,53 @@ func initializeTables() error {
 		return fmt.Errorf("failed to create tables: %w", err)
 	}
 	log.Println("Database tables initialized successfully")
+
+	if err := backfillGameMatchIDs(); err != nil {
+		return fmt.Errorf("failed to backfill game match IDs: %w", err)
+	}
+	if err := encryptLegacyAuthCodes(); err != nil {
+		return fmt.Errorf("failed to encrypt legacy auth codes: %w", err)
+	}
+	return nil
+}
+
+// backfillGameMatchIDs decodes the share codes of games stored before match IDs were tracked
+func backfillGameMatchIDs() error {
+	rows, err := db.Query(`SELECT uuid, share_code FROM games WHERE match_id = 0`)
+	if err != nil {
+		return fmt.Errorf("failed to get games without match ID: %w", err)
+	}
+	defer rows.Close()
+
+	decoded := make(map[uuid.UUID]*sharecode.ShareCode)
+	for rows.Next() {
+		var gameUUID uuid.UUID
+		var shareCode string
+		if err := rows.Scan(&gameUUID, &shareCode); err != nil {
+			return fmt.Errorf("failed to scan game: %w", err)
+		}
+		if sc, err := sharecode.Decode(shareCode); err == nil {
+			decoded[gameUUID] = sc
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error iterating over games: %w", err)
+	}
+
+	for gameUUID, sc := range decoded {
+		_, err := db.Exec(`
+			UPDATE games
+			SET match_id = $2, outcome_id = $3, token = $4
+			WHERE uuid = $1 AND NOT EXISTS (SELECT 1 FROM games WHERE match_id = $2)`,
+			gameUUID, sc.MatchID, sc.OutcomeID, sc.Token)
+		if err != nil {
+			return fmt.Errorf("failed to backfill game %s: %w", gameUUID, err)
+		}
+	}
+
+	if len(decoded) > 0 {
+		log.Printf("Backfilled match IDs for %d games", len(decoded))
+	}
 	return nil
 }
 
@@ -108,24 +161,63 @@ func addGameToGuild(guildID string, gameUUID uuid.UUID) error {
 	return nil
 }
 
+// GetGuildsForUser retrieves all guilds a user is registered in
+func getGuildsForUser(userUUID uuid.UUID) ([]*Guild, error) {
+	query := `
+		SELECT uuid, guild_id, channel_id, user_ids, game_ids, created_at, updated_at
+		FROM guilds WHERE user_ids @> jsonb_build_array($1::text)
+		ORDER BY created_at`
+
+	rows, err := db.Query(query, userUUID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guilds for user: %w", err)
+	}
+	defer rows.Close()
+
+	var guilds []*Guild
+	for rows.Next() {
+		guild := &Guild{}
+		err := rows.Scan(
+			&guild.UUID, &guild.GuildID, &guild.ChannelID, &guild.UserIDs, &guild.GameIDs,
+			&guild.CreatedAt, &guild.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan guild: %w", err)
+		}
+		guilds = append(guilds, guild)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over guilds: %w", err)
+	}
+
+	return guilds, nil
+}
+
 // User database operations
 
-// CreateUser inserts a new user into the database
-func createUser(steamID, authCode, lastShareCode string) (*User, error) {
+// CreateUser inserts a new user into the database, linked to discordUserID when it is not empty
+func createUser(steamID, authCode, lastShareCode, discordUserID string) (*User, error) {
 	user := &User{
 		UUID:          uuid.New(),
 		SteamID:       steamID,
 		AuthCode:      authCode,
 		LastShareCode: lastShareCode,
 		GameIDs:       StringSlice{},
+		DiscordUserID: discordUserID,
+	}
+
+	encrypted, err := authCodeKeys.Encrypt(authCode, user.UUID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt auth code: %w", err)
 	}
 
 	query := `
-		INSERT INTO users (uuid, steam_id, auth_code, last_share_code, game_ids)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO users (uuid, steam_id, auth_code, last_share_code, game_ids, discord_user_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING created_at, updated_at`
 
-	err := db.QueryRow(query, user.UUID, user.SteamID, user.AuthCode, user.LastShareCode, user.GameIDs).
+	err = db.QueryRow(query, user.UUID, user.SteamID, encrypted, user.LastShareCode, user.GameIDs, user.DiscordUserID).
 		Scan(&user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create user: %w", err)
@@ -138,17 +230,21 @@ func createUser(steamID, authCode, lastShareCode string) (*User, error) {
 func getUserBySteamID(steamID string) (*User, error) {
 	user := &User{}
 	query := `
-		SELECT uuid, steam_id, auth_code, last_share_code, game_ids, created_at, updated_at
+		SELECT uuid, steam_id, auth_code, last_share_code, game_ids, discord_user_id, created_at, updated_at
 		FROM users WHERE steam_id = $1`
 
 	err := db.QueryRow(query, steamID).Scan(
 		&user.UUID, &user.SteamID, &user.AuthCode, &user.LastShareCode, &user.GameIDs,
-		&user.CreatedAt, &user.UpdatedAt,
+		&user.DiscordUserID, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
+	if err := decryptAuthCode(user); err != nil {
+		return nil, err
+	}
+
 	return user, nil
 }
 
@@ -156,35 +252,135 @@ func getUserBySteamID(steamID string) (*User, error) {
 func getUserByUUID(userUUID uuid.UUID) (*User, error) {
 	user := &User{}
 	query := `
-		SELECT uuid, steam_id, auth_code, last_share_code, game_ids, created_at, updated_at
+		SELECT uuid, steam_id, auth_code, last_share_code, game_ids, discord_user_id, created_at, updated_at
 		FROM users WHERE uuid = $1`
 
 	err := db.QueryRow(query, userUUID).Scan(
 		&user.UUID, &user.SteamID, &user.AuthCode, &user.LastShareCode, &user.GameIDs,
-		&user.CreatedAt, &user.UpdatedAt,
+		&user.DiscordUserID, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
+	if err := decryptAuthCode(user); err != nil {
+		return nil, err
+	}
+
 	return user, nil
 }
 
 // UpdateUser updates an existing user
 func updateUser(user *User) error {
+	encrypted, err := authCodeKeys.Encrypt(user.AuthCode, user.UUID.String())
+	if err != nil {
+		return fmt.Errorf("failed to encrypt auth code: %w", err)
+	}
+
 	query := `
 		UPDATE users 
-		SET auth_code = $2, last_share_code = $3, game_ids = $4
+		SET auth_code = $2, last_share_code = $3, game_ids = $4, discord_user_id = $5
 		WHERE uuid = $1`
 
-	_, err := db.Exec(query, user.UUID, user.AuthCode, user.LastShareCode, user.GameIDs)
+	_, err = db.Exec(query, user.UUID, encrypted, user.LastShareCode, user.GameIDs, user.DiscordUserID)
 	if err != nil {
 		return fmt.Errorf("failed to update user: %w", err)
 	}
 
+	// A new auth code may fix a suspended user, so polling starts over
+	err = resetUserPollState(user.UUID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// decryptAuthCode replaces the stored auth code of a scanned user with its plaintext
+func decryptAuthCode(user *User) error {
+	authCode, err := authCodeKeys.Decrypt(user.AuthCode, user.UUID.String())
+	if err != nil {
+		return fmt.Errorf("failed to decrypt auth code of %s: %w", user.SteamID, err)
+	}
+	user.AuthCode = authCode
+	return nil
+}
+
+// encryptLegacyAuthCodes encrypts auth codes stored before encryption at rest was added
+func encryptLegacyAuthCodes() error {
+	rows, err := db.Query(`SELECT uuid, auth_code FROM users WHERE auth_code NOT LIKE 'enc:%'`)
+	if err != nil {
+		return fmt.Errorf("failed to get plaintext auth codes: %w", err)
+	}
+	defer rows.Close()
+
+	plaintext := make(map[uuid.UUID]string)
+	for rows.Next() {
+		var userUUID uuid.UUID
+		var authCode string
+		if err := rows.Scan(&userUUID, &authCode); err != nil {
+			return fmt.Errorf("failed to scan auth code: %w", err)
+		}
+		plaintext[userUUID] = authCode
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating over auth codes: %w", err)
+	}
+
+	for userUUID, authCode := range plaintext {
+		encrypted, err := authCodeKeys.Encrypt(authCode, userUUID.String())
+		if err != nil {
+			return fmt.Errorf("failed to encrypt auth code: %w", err)
+		}
+		// Only replace the value that was read, in case it changed in the meantime
+		_, err = db.Exec(`UPDATE users SET auth_code = $2 WHERE uuid = $1 AND auth_code = $3`,
+			userUUID, encrypted, authCode)
+		if err != nil {
+			return fmt.Errorf("failed to store encrypted auth code: %w", err)
+		}
+	}
+
+	if len(plaintext) > 0 {
+		log.Printf("Encrypted %d legacy plaintext auth codes", len(plaintext))
+	}
 	return nil
 }
 
+// GetUsersByDiscordID retrieves every Steam user linked to a Discord member
+func getUsersByDiscordID(discordUserID string) ([]*User, error) {
+	query := `
+		SELECT uuid, steam_id, auth_code, last_share_code, game_ids, discord_user_id, created_at, updated_at
+		FROM users WHERE discord_user_id = $1 ORDER BY created_at`
+
+	rows, err := db.Query(query, discordUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users for discord member: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user := &User{}
+		err := rows.Scan(
+			&user.UUID, &user.SteamID, &user.AuthCode, &user.LastShareCode, &user.GameIDs,
+			&user.DiscordUserID, &user.CreatedAt, &user.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		if err := decryptAuthCode(user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over users: %w", err)
+	}
+
+	return users, nil
+}
+
 // AddGameToUser adds a game UUID to a user's game list
 func addGameToUser(steamID string, gameUUID uuid.UUID) error {
 	query := `
@@ -203,7 +399,8 @@ func addGameToUser(steamID string, gameUUID uuid.UUID) error {
 
 // Game database operations
 
-// CreateGame inserts a new game into the database
+// CreateGame inserts a new game into the database.
+// The match ID, outcome ID and token are decoded from the share code when it is well-formed.
 func createGame(shareCode, demoName string, steamIDs []string) (*Game, error) {
 	game := &Game{
 		UUID:      uuid.New(),
@@ -212,12 +409,18 @@ func createGame(shareCode, demoName string, steamIDs []string) (*Game, error) {
 		SteamIDs:  StringSlice(steamIDs),
 	}
 
+	if sc, err := sharecode.Decode(shareCode); err == nil {
+		game.MatchID = sc.MatchID
+		game.OutcomeID = sc.OutcomeID
+		game.Token = sc.Token
+	}
+
 	query := `
-		INSERT INTO games (uuid, share_code, demo_name, steam_ids)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO games (uuid, share_code, match_id, outcome_id, token, demo_name, steam_ids)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING created_at, updated_at`
 
-	err := db.QueryRow(query, game.UUID, game.ShareCode, game.DemoName, game.SteamIDs).
+	err := db.QueryRow(query, game.UUID, game.ShareCode, game.MatchID, game.OutcomeID, game.Token, game.DemoName, game.SteamIDs).
 		Scan(&game.CreatedAt, &game.UpdatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create game: %w", err)
@@ -230,11 +433,11 @@ func createGame(shareCode, demoName string, steamIDs []string) (*Game, error) {
 func getGameByShareCode(shareCode string) (*Game, error) {
 	game := &Game{}
 	query := `
-		SELECT uuid, share_code, demo_name, steam_ids, created_at, updated_at
+		SELECT uuid, share_code, match_id, outcome_id, token, demo_name, steam_ids, created_at, updated_at
 		FROM games WHERE share_code = $1`
 
 	err := db.QueryRow(query, shareCode).Scan(
-		&game.UUID, &game.ShareCode, &game.DemoName, &game.SteamIDs,
+		&game.UUID, &game.ShareCode, &game.MatchID, &game.OutcomeID, &game.Token, &game.DemoName, &game.SteamIDs,
 		&game.CreatedAt, &game.UpdatedAt,
 	)
 	if err != nil {
@@ -244,15 +447,45 @@ func getGameByShareCode(shareCode string) (*Game, error) {
 	return game, nil
 }
 
+// GetGameByMatchID retrieves a game by the match ID decoded from its share code
+func getGameByMatchID(matchID uint64) (*Game, error) {
+	game := &Game{}
+	query := `
+		SELECT uuid, share_code, match_id, outcome_id, token, demo_name, steam_ids, created_at, updated_at
+		FROM games WHERE match_id = $1 AND match_id <> 0`
+
+	err := db.QueryRow(query, matchID).Scan(
+		&game.UUID, &game.ShareCode, &game.MatchID, &game.OutcomeID, &game.Token, &game.DemoName, &game.SteamIDs,
+		&game.CreatedAt, &game.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get game: %w", err)
+	}
+
+	return game, nil
+}
+
+// FindGameByShareCode looks a game up by the match ID decoded from the share code,
+// falling back to the raw share code for codes that cannot be decoded
+func findGameByShareCode(shareCode string) (*Game, error) {
+	if sc, err := sharecode.Decode(shareCode); err == nil {
+		game, err := getGameByMatchID(sc.MatchID)
+		if err == nil || !errors.Is(err, sql.ErrNoRows) {
+			return game, err
+		}
+	}
+	return getGameByShareCode(shareCode)
+}
+
 // GetGameByUUID retrieves a game by its UUID
 func getGameByUUID(gameUUID uuid.UUID) (*Game, error) {
 	game := &Game{}
 	query := `
-		SELECT uuid, share_code, demo_name, steam_ids, created_at, updated_at
+		SELECT uuid, share_code, match_id, outcome_id, token, demo_name, steam_ids, created_at, updated_at
 		FROM games WHERE uuid = $1`
 
 	err := db.QueryRow(query, gameUUID).Scan(
-		&game.UUID, &game.ShareCode, &game.DemoName, &game.SteamIDs,
+		&game.UUID, &game.ShareCode, &game.MatchID, &game.OutcomeID, &game.Token, &game.DemoName, &game.SteamIDs,
 		&game.CreatedAt, &game.UpdatedAt,
 	)
 	if err != nil {
@@ -277,11 +510,31 @@ func updateGame(game *Game) error {
 	return nil
 }
 
+// GetGameStats retrieves the parsed stats of a game, nil if the demo has not been parsed yet
+func getGameStats(gameUUID uuid.UUID) (*MatchStats, error) {
+	var raw []byte
+	err := db.QueryRow(`SELECT stats FROM games WHERE uuid = $1`, gameUUID).Scan(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get game stats: %w", err)
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	stats := &MatchStats{}
+	if err := stats.Scan(raw); err != nil {
+		return nil, fmt.Errorf("failed to decode game stats: %w", err)
+	}
+
+	return stats, nil
+}
+
 // GetGamesBySteamID retrieves all games that include a specific Steam ID
 func getGamesBySteamID(steamID string) ([]*Game, error) {
 	query := `
-		SELECT uuid, share_code, demo_name, steam_ids, created_at, updated_at
-		FROM games WHERE steam_ids @> $1::jsonb`
+		SELECT uuid, share_code, match_id, outcome_id, token, demo_name, steam_ids, created_at, updated_at
+		FROM games WHERE steam_ids @> $1::jsonb
+		ORDER BY match_id DESC, created_at DESC`
 
 	steamIDJSON := fmt.Sprintf(`["%s"]`, steamID)
 	rows, err := db.Query(query, steamIDJSON)
@@ -294,7 +547,7 @@ func getGamesBySteamID(steamID string) ([]*Game, error) {
 	for rows.Next() {
 		game := &Game{}
 		err := rows.Scan(
-			&game.UUID, &game.ShareCode, &game.DemoName, &game.SteamIDs,
+			&game.UUID, &game.ShareCode, &game.MatchID, &game.OutcomeID, &game.Token, &game.DemoName, &game.SteamIDs,
 			&game.CreatedAt, &game.UpdatedAt,
 		)
 		if err != nil {
@@ -313,7 +566,7 @@ func getGamesBySteamID(steamID string) ([]*Game, error) {
 // GetAllUsers retrieves all users for polling
 func getAllUsers() ([]*User, error) {
 	query := `
-		SELECT uuid, steam_id, auth_code, last_share_code, game_ids, created_at, updated_at
+		SELECT uuid, steam_id, auth_code, last_share_code, game_ids, discord_user_id, created_at, updated_at
 		FROM users ORDER BY created_at`
 
 	rows, err := db.Query(query)
@@ -327,11 +580,14 @@ func getAllUsers() ([]*User, error) {
 		user := &User{}
 		err := rows.Scan(
 			&user.UUID, &user.SteamID, &user.AuthCode, &user.LastShareCode, &user.GameIDs,
-			&user.CreatedAt, &user.UpdatedAt,
+			&user.DiscordUserID, &user.CreatedAt, &user.UpdatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan user: %w", err)
 		}
+		if err := decryptAuthCode(user); err != nil {
+			return nil, err
+		}
 		users = append(users, user)
 	}
 
@@ -342,31 +598,59 @@ func getAllUsers() ([]*User, error) {
 	return users, nil
 }
 
-// DeleteUser removes a user from the database
-func deleteUser(steamID string) error {
-	// First get the user to remove from guilds
-	user, err := getUserBySteamID(steamID)
+// RemoveUserFromGuild removes a user from one guild and records the removal in the audit
+// trail. The user row itself is only deleted once no guild references it anymore.
+// It reports whether the user was deleted globally.
+func removeUserFromGuild(guildID string, user *User, actorDiscordID, actorRole string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the user so concurrent removals agree on the remaining guild references
+	_, err = tx.Exec(`SELECT 1 FROM users WHERE uuid = $1 FOR UPDATE`, user.UUID)
 	if err != nil {
-		return fmt.Errorf("failed to get user: %w", err)
+		return false, fmt.Errorf("failed to lock user: %w", err)
 	}
 
-	// Remove user from all guilds
-	_, err = db.Exec(`
+	_, err = tx.Exec(`
 		UPDATE guilds 
-		SET user_ids = user_ids - $1::text
-		WHERE user_ids @> jsonb_build_array($1::text)`,
-		user.UUID.String())
+		SET user_ids = user_ids - $2::text
+		WHERE guild_id = $1`,
+		guildID, user.UUID.String())
 	if err != nil {
-		return fmt.Errorf("failed to remove user from guilds: %w", err)
+		return false, fmt.Errorf("failed to remove user from guild: %w", err)
 	}
 
-	// Delete the user
-	_, err = db.Exec(`DELETE FROM users WHERE steam_id = $1`, steamID)
+	var referenced bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM guilds WHERE user_ids @> jsonb_build_array($1::text))`,
+		user.UUID.String()).Scan(&referenced)
 	if err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
+		return false, fmt.Errorf("failed to check guild references: %w", err)
 	}
 
-	return nil
+	if !referenced {
+		_, err = tx.Exec(`DELETE FROM users WHERE uuid = $1`, user.UUID)
+		if err != nil {
+			return false, fmt.Errorf("failed to delete user: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_removals (guild_id, user_uuid, steam_id, actor_discord_id, actor_role, deleted_globally)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		guildID, user.UUID, user.SteamID, actorDiscordID, actorRole, !referenced)
+	if err != nil {
+		return false, fmt.Errorf("failed to record user removal: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit user removal: %w", err)
+	}
+
+	return !referenced, nil
 }
 
 // UpdateUserLastShareCode updates only the last share code for a user
@@ -387,10 +671,11 @@ func updateUserLastShareCode(steamID, shareCode string) error {
 // GetGamesForGuild retrieves all games associated with a guild
 func getGamesForGuild(guildID string) ([]*Game, error) {
 	query := `
-		SELECT g.uuid, g.share_code, g.demo_name, g.steam_ids, g.created_at, g.updated_at
+		SELECT g.uuid, g.share_code, g.match_id, g.outcome_id, g.token, g.demo_name, g.steam_ids, g.created_at, g.updated_at
 		FROM games g
 		JOIN guilds guild ON guild.game_ids @> jsonb_build_array(g.uuid::text)
-		WHERE guild.guild_id = $1`
+		WHERE guild.guild_id = $1
+		ORDER BY g.match_id DESC, g.created_at DESC`
 
 	rows, err := db.Query(query, guildID)
 	if err != nil {
@@ -402,7 +687,7 @@ func getGamesForGuild(guildID string) ([]*Game, error) {
 	for rows.Next() {
 		game := &Game{}
 		err := rows.Scan(
-			&game.UUID, &game.ShareCode, &game.DemoName, &game.SteamIDs,
+			&game.UUID, &game.ShareCode, &game.MatchID, &game.OutcomeID, &game.Token, &game.DemoName, &game.SteamIDs,
 			&game.CreatedAt, &game.UpdatedAt,
 		)
 		if err != nil {
@@ -416,4 +701,936 @@ func getGamesForGuild(guildID string) ([]*Game, error) {
 	}
 
 	return games, nil
-}
\ No newline at end of file
+}
+
+// matchFilterClause builds the WHERE clause shared by the paginated guild match queries.
+// $1 is always the guild ID; filter arguments are appended after it.
+func matchFilterClause(guildID string, filter MatchFilter) (string, []interface{}) {
+	conditions := []string{"guild.guild_id = $1"}
+	args := []interface{}{guildID}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.SteamID != "" {
+		conditions = append(conditions, fmt.Sprintf("g.steam_ids @> jsonb_build_array(%s::text)", arg(filter.SteamID)))
+	}
+	if filter.DiscordUserID != "" {
+		conditions = append(conditions, fmt.Sprintf(`EXISTS (
+			SELECT 1 FROM users du
+			WHERE du.discord_user_id = %s AND g.steam_ids @> jsonb_build_array(du.steam_id::text))`,
+			arg(filter.DiscordUserID)))
+	}
+	if filter.Map != "" {
+		conditions = append(conditions, fmt.Sprintf("mm.map = %s", arg(filter.Map)))
+	}
+	if filter.Result != "" {
+		if filter.DiscordUserID != "" {
+			// Result from the point of view of any account linked to the filtered member
+			conditions = append(conditions, fmt.Sprintf(`EXISTS (
+				SELECT 1 FROM match_players mp
+				JOIN users du ON du.steam_id = mp.steam_id
+				WHERE mp.game_uuid = g.uuid AND du.discord_user_id = %s AND mp.result = %s)`,
+				arg(filter.DiscordUserID), arg(filter.Result)))
+		} else if filter.SteamID != "" {
+			// Result from the filtered player's point of view
+			conditions = append(conditions, fmt.Sprintf(`EXISTS (
+				SELECT 1 FROM match_players mp
+				WHERE mp.game_uuid = g.uuid AND mp.steam_id = %s AND mp.result = %s)`,
+				arg(filter.SteamID), arg(filter.Result)))
+		} else {
+			// Result from the point of view of any player registered in the guild
+			conditions = append(conditions, fmt.Sprintf(`EXISTS (
+				SELECT 1 FROM match_players mp
+				JOIN users u ON u.steam_id = mp.steam_id
+				WHERE mp.game_uuid = g.uuid AND mp.result = %s
+					AND guild.user_ids @> jsonb_build_array(u.uuid::text))`,
+				arg(filter.Result)))
+		}
+	}
+	// The stats don't say when a match was played, so the dates are those it was recorded at
+	if !filter.From.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("g.created_at >= %s", arg(filter.From)))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("g.created_at < %s", arg(filter.To)))
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// GetGamesForGuildPage retrieves one page of a guild's games matching the filter,
+// newest first, along with the total number of matching games
+func getGamesForGuildPage(guildID string, filter MatchFilter, limit, offset int) ([]*GameListing, int, error) {
+	where, args := matchFilterClause(guildID, filter)
+	from := `
+		FROM games g
+		JOIN guilds guild ON guild.game_ids @> jsonb_build_array(g.uuid::text)
+		LEFT JOIN match_meta mm ON mm.game_uuid = g.uuid
+		WHERE ` + where
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) `+from, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count games for guild: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT g.uuid, g.share_code, g.match_id, g.outcome_id, g.token, g.demo_name, g.steam_ids, g.created_at, g.updated_at,
+			COALESCE(mm.map, ''), COALESCE(mm.team_a_score, 0), COALESCE(mm.team_b_score, 0), mm.game_uuid IS NOT NULL
+		%s
+		ORDER BY g.match_id DESC, g.created_at DESC
+		LIMIT $%d OFFSET $%d`, from, len(args)+1, len(args)+2)
+
+	rows, err := db.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get games for guild: %w", err)
+	}
+	defer rows.Close()
+
+	var listings []*GameListing
+	for rows.Next() {
+		listing := &GameListing{}
+		err := rows.Scan(
+			&listing.UUID, &listing.ShareCode, &listing.MatchID, &listing.OutcomeID, &listing.Token, &listing.DemoName, &listing.SteamIDs,
+			&listing.CreatedAt, &listing.UpdatedAt,
+			&listing.Map, &listing.TeamAScore, &listing.TeamBScore, &listing.Parsed,
+		)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan game: %w", err)
+		}
+		listings = append(listings, listing)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating over games: %w", err)
+	}
+
+	return listings, total, nil
+}
+
+// Match stats database operations
+
+// SaveMatchStats stores the parsed stats of a game and its normalized
+// meta, player and round rows in a single transaction. Re-parsing a game
+// replaces the previously stored rows.
+func saveMatchStats(gameUUID uuid.UUID, stats *MatchStats) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE games SET stats = $2 WHERE uuid = $1`, gameUUID, stats)
+	if err != nil {
+		return fmt.Errorf("failed to update game stats: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO match_meta (
+			game_uuid, map,
+			team_a_name, team_a_score, team_a_starting_side,
+			team_b_name, team_b_score, team_b_starting_side,
+			rounds_played)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (game_uuid) DO UPDATE SET
+			map = EXCLUDED.map,
+			team_a_name = EXCLUDED.team_a_name,
+			team_a_score = EXCLUDED.team_a_score,
+			team_a_starting_side = EXCLUDED.team_a_starting_side,
+			team_b_name = EXCLUDED.team_b_name,
+			team_b_score = EXCLUDED.team_b_score,
+			team_b_starting_side = EXCLUDED.team_b_starting_side,
+			rounds_played = EXCLUDED.rounds_played`,
+		gameUUID, stats.Map,
+		stats.TeamA.Name, stats.TeamA.Score, stats.TeamA.StartingSide,
+		stats.TeamB.Name, stats.TeamB.Score, stats.TeamB.StartingSide,
+		stats.TeamA.Score+stats.TeamB.Score)
+	if err != nil {
+		return fmt.Errorf("failed to save match meta: %w", err)
+	}
+
+	if _, err = tx.Exec(`DELETE FROM match_players WHERE game_uuid = $1`, gameUUID); err != nil {
+		return fmt.Errorf("failed to clear match players: %w", err)
+	}
+	for _, player := range stats.Players {
+		_, err = tx.Exec(`
+			INSERT INTO match_players (
+				game_uuid, steam_id, name, team, result,
+				kills, deaths, assists, adr, hs_pct, kast, rating,
+				mvps, utility_damage, entry_kills, clutches)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`,
+			gameUUID, player.SteamID, player.Name, player.Team, stats.Result(player.Team),
+			player.Kills, player.Deaths, player.Assists, player.ADR, player.HeadshotPct, player.KAST, player.Rating,
+			player.MVPs, player.UtilityDamage, player.EntryKills, player.Clutches)
+		if err != nil {
+			return fmt.Errorf("failed to save match player %s: %w", player.SteamID, err)
+		}
+	}
+
+	if _, err = tx.Exec(`DELETE FROM match_rounds WHERE game_uuid = $1`, gameUUID); err != nil {
+		return fmt.Errorf("failed to clear match rounds: %w", err)
+	}
+	for _, round := range stats.Rounds {
+		_, err = tx.Exec(`
+			INSERT INTO match_rounds (game_uuid, round_number, winner_team, winner_side, reason)
+			VALUES ($1, $2, $3, $4, $5)`,
+			gameUUID, round.Number, round.WinnerTeam, round.WinnerSide, round.Reason)
+		if err != nil {
+			return fmt.Errorf("failed to save match round %d: %w", round.Number, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit match stats: %w", err)
+	}
+
+	return nil
+}
+
+// GetMatchMeta retrieves the match-level result of a parsed game
+func getMatchMeta(gameUUID uuid.UUID) (*MatchMeta, error) {
+	meta := &MatchMeta{}
+	query := `
+		SELECT game_uuid, map,
+			team_a_name, team_a_score, team_a_starting_side,
+			team_b_name, team_b_score, team_b_starting_side,
+			rounds_played, created_at, updated_at
+		FROM match_meta WHERE game_uuid = $1`
+
+	err := db.QueryRow(query, gameUUID).Scan(
+		&meta.GameUUID, &meta.Map,
+		&meta.TeamA.Name, &meta.TeamA.Score, &meta.TeamA.StartingSide,
+		&meta.TeamB.Name, &meta.TeamB.Score, &meta.TeamB.StartingSide,
+		&meta.RoundsPlayed, &meta.CreatedAt, &meta.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get match meta: %w", err)
+	}
+
+	return meta, nil
+}
+
+// GetMatchPlayers retrieves the scoreboard rows of a parsed game
+func getMatchPlayers(gameUUID uuid.UUID) ([]*MatchPlayer, error) {
+	query := `
+		SELECT game_uuid, steam_id, name, team, result,
+			kills, deaths, assists, adr, hs_pct, kast, rating,
+			mvps, utility_damage, entry_kills, clutches
+		FROM match_players WHERE game_uuid = $1
+		ORDER BY team, kills DESC, deaths`
+
+	rows, err := db.Query(query, gameUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get match players: %w", err)
+	}
+	defer rows.Close()
+
+	var players []*MatchPlayer
+	for rows.Next() {
+		player := &MatchPlayer{}
+		err := rows.Scan(
+			&player.GameUUID, &player.SteamID, &player.Name, &player.Team, &player.Result,
+			&player.Kills, &player.Deaths, &player.Assists, &player.ADR, &player.HeadshotPct, &player.KAST, &player.Rating,
+			&player.MVPs, &player.UtilityDamage, &player.EntryKills, &player.Clutches,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan match player: %w", err)
+		}
+		players = append(players, player)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over match players: %w", err)
+	}
+
+	return players, nil
+}
+
+// GetMatchRounds retrieves the round results of a parsed game in order
+func getMatchRounds(gameUUID uuid.UUID) ([]*MatchRound, error) {
+	query := `
+		SELECT game_uuid, round_number, winner_team, winner_side, reason
+		FROM match_rounds WHERE game_uuid = $1
+		ORDER BY round_number`
+
+	rows, err := db.Query(query, gameUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get match rounds: %w", err)
+	}
+	defer rows.Close()
+
+	var rounds []*MatchRound
+	for rows.Next() {
+		round := &MatchRound{}
+		err := rows.Scan(&round.GameUUID, &round.Number, &round.WinnerTeam, &round.WinnerSide, &round.Reason)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan match round: %w", err)
+		}
+		rounds = append(rounds, round)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over match rounds: %w", err)
+	}
+
+	return rounds, nil
+}
+
+// Player aggregate database operations
+
+// playerAggregateSQL aggregates a player's most recent parsed matches; $2 limits the
+// number of matches (NULL for all). ADR is weighted by rounds played and HS% by kills.
+const playerAggregateSQL = `
+	WITH played AS (
+		SELECT mp.result, mp.kills, mp.deaths, mp.assists, mp.adr, mp.hs_pct, mp.rating, mm.rounds_played
+		FROM match_players mp
+		JOIN games g ON g.uuid = mp.game_uuid
+		JOIN match_meta mm ON mm.game_uuid = mp.game_uuid
+		WHERE mp.steam_id = $1
+		ORDER BY g.match_id DESC, g.created_at DESC
+		LIMIT $2
+	)
+	SELECT
+		COUNT(*),
+		COUNT(*) FILTER (WHERE result = 'win'),
+		COUNT(*) FILTER (WHERE result = 'loss'),
+		COUNT(*) FILTER (WHERE result = 'tie'),
+		COALESCE(SUM(kills), 0),
+		COALESCE(SUM(deaths), 0),
+		COALESCE(SUM(assists), 0),
+		SUM(adr * rounds_played) FILTER (WHERE adr IS NOT NULL)
+			/ NULLIF(SUM(rounds_played) FILTER (WHERE adr IS NOT NULL), 0),
+		SUM(hs_pct * kills) FILTER (WHERE hs_pct IS NOT NULL)
+			/ NULLIF(SUM(kills) FILTER (WHERE hs_pct IS NOT NULL), 0),
+		AVG(rating)
+	FROM played`
+
+// getPlayerAggregate aggregates a player's last limit parsed matches, or all of them when limit is 0
+func getPlayerAggregate(steamID string, limit int) (*PlayerAggregate, error) {
+	var limitArg interface{}
+	if limit > 0 {
+		limitArg = limit
+	}
+
+	agg := &PlayerAggregate{}
+	err := db.QueryRow(playerAggregateSQL, steamID, limitArg).Scan(
+		&agg.Matches, &agg.Wins, &agg.Losses, &agg.Ties,
+		&agg.Kills, &agg.Deaths, &agg.Assists,
+		&agg.ADR, &agg.HeadshotPct, &agg.Rating,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate player stats: %w", err)
+	}
+
+	return agg, nil
+}
+
+// GetPlayerProfile builds lifetime and last-n aggregates, recent results and best map for a player
+func getPlayerProfile(steamID string, recent int) (*PlayerProfile, error) {
+	profile := &PlayerProfile{SteamID: steamID}
+
+	lifetime, err := getPlayerAggregate(steamID, 0)
+	if err != nil {
+		return nil, err
+	}
+	profile.Lifetime = *lifetime
+
+	recentAgg, err := getPlayerAggregate(steamID, recent)
+	if err != nil {
+		return nil, err
+	}
+	profile.Recent = *recentAgg
+
+	rows, err := db.Query(`
+		SELECT mp.result, mp.name
+		FROM match_players mp
+		JOIN games g ON g.uuid = mp.game_uuid
+		WHERE mp.steam_id = $1
+		ORDER BY g.match_id DESC, g.created_at DESC
+		LIMIT $2`, steamID, recent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recent results: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result, name string
+		if err := rows.Scan(&result, &name); err != nil {
+			return nil, fmt.Errorf("failed to scan recent result: %w", err)
+		}
+		if profile.Name == "" {
+			profile.Name = name
+		}
+		profile.RecentResults = append(profile.RecentResults, result)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over recent results: %w", err)
+	}
+
+	// Best map: highest win rate over at least 3 matches, otherwise the most played map
+	err = db.QueryRow(`
+		SELECT mm.map, COUNT(*), COUNT(*) FILTER (WHERE mp.result = 'win')
+		FROM match_players mp
+		JOIN match_meta mm ON mm.game_uuid = mp.game_uuid
+		WHERE mp.steam_id = $1
+		GROUP BY mm.map
+		ORDER BY
+			COUNT(*) >= 3 DESC,
+			COUNT(*) FILTER (WHERE mp.result = 'win')::float / COUNT(*) DESC,
+			COUNT(*) DESC
+		LIMIT 1`, steamID).Scan(&profile.BestMap, &profile.BestMapMatches, &profile.BestMapWins)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get best map: %w", err)
+	}
+
+	return profile, nil
+}
+
+// leaderboardOrder maps a leaderboard metric to its ORDER BY expression
+var leaderboardOrder = map[string]string{
+	"rating":   "AVG(mp.rating)",
+	"adr":      "SUM(mp.adr * mm.rounds_played) FILTER (WHERE mp.adr IS NOT NULL) / NULLIF(SUM(mm.rounds_played) FILTER (WHERE mp.adr IS NOT NULL), 0)",
+	"kd":       "SUM(mp.kills)::float / GREATEST(SUM(mp.deaths), 1)",
+	"win_rate": "COUNT(*) FILTER (WHERE mp.result = 'win')::float / COUNT(*)",
+	"matches":  "COUNT(*)",
+}
+
+// GetGuildLeaderboard ranks the guild's registered players by metric over the matches
+// recorded since the given time (zero for all time), skipping players below minMatches.
+// The stats don't say when a match was played, so recording time stands in for it.
+func getGuildLeaderboard(guildID, metric string, since time.Time, minMatches, limit int) ([]*LeaderboardEntry, error) {
+	order, ok := leaderboardOrder[metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown leaderboard metric %q", metric)
+	}
+
+	var sinceArg interface{}
+	if !since.IsZero() {
+		sinceArg = since
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			mp.steam_id,
+			(ARRAY_AGG(mp.name ORDER BY g.match_id DESC, g.created_at DESC))[1],
+			COUNT(*),
+			COUNT(*) FILTER (WHERE mp.result = 'win'),
+			COUNT(*) FILTER (WHERE mp.result = 'loss'),
+			COUNT(*) FILTER (WHERE mp.result = 'tie'),
+			SUM(mp.kills), SUM(mp.deaths), SUM(mp.assists),
+			SUM(mp.adr * mm.rounds_played) FILTER (WHERE mp.adr IS NOT NULL)
+				/ NULLIF(SUM(mm.rounds_played) FILTER (WHERE mp.adr IS NOT NULL), 0),
+			SUM(mp.hs_pct * mp.kills) FILTER (WHERE mp.hs_pct IS NOT NULL)
+				/ NULLIF(SUM(mp.kills) FILTER (WHERE mp.hs_pct IS NOT NULL), 0),
+			AVG(mp.rating)
+		FROM match_players mp
+		JOIN games g ON g.uuid = mp.game_uuid
+		JOIN match_meta mm ON mm.game_uuid = mp.game_uuid
+		JOIN users u ON u.steam_id = mp.steam_id
+		JOIN guilds guild ON guild.user_ids @> jsonb_build_array(u.uuid::text)
+		WHERE guild.guild_id = $1 AND ($2::timestamptz IS NULL OR g.created_at >= $2::timestamptz)
+		GROUP BY mp.steam_id
+		HAVING COUNT(*) >= $3
+		ORDER BY %s DESC NULLS LAST, COUNT(*) DESC
+		LIMIT $4`, order)
+
+	rows, err := db.Query(query, guildID, sinceArg, minMatches, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guild leaderboard: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*LeaderboardEntry
+	for rows.Next() {
+		entry := &LeaderboardEntry{}
+		err := rows.Scan(
+			&entry.SteamID, &entry.Name,
+			&entry.Matches, &entry.Wins, &entry.Losses, &entry.Ties,
+			&entry.Kills, &entry.Deaths, &entry.Assists,
+			&entry.ADR, &entry.HeadshotPct, &entry.Rating,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan leaderboard entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over leaderboard: %w", err)
+	}
+
+	return entries, nil
+}
+
+// GetGuildMatchCounts counts the guild's tracked and parsed games and the matches
+// its registered players have played across all stored match data
+func getGuildMatchCounts(guildID string) (games, parsed, playerMatches int, err error) {
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM games g
+				WHERE guild.game_ids @> jsonb_build_array(g.uuid::text)),
+			(SELECT COUNT(*) FROM games g
+				JOIN match_meta mm ON mm.game_uuid = g.uuid
+				WHERE guild.game_ids @> jsonb_build_array(g.uuid::text)),
+			(SELECT COUNT(*) FROM match_players mp
+				JOIN users u ON u.steam_id = mp.steam_id
+				WHERE guild.user_ids @> jsonb_build_array(u.uuid::text))
+		FROM guilds guild WHERE guild.guild_id = $1`
+
+	err = db.QueryRow(query, guildID).Scan(&games, &parsed, &playerMatches)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to count guild matches: %w", err)
+	}
+
+	return games, parsed, playerMatches, nil
+}
+
+// GetSteamProfiles retrieves the cached Steam profiles of the given accounts, whatever their age
+func getSteamProfiles(steamIDs []string) (map[string]*SteamProfile, error) {
+	query := `
+		SELECT steam_id, persona_name, avatar_url, profile_url, fetched_at
+		FROM steam_profiles WHERE steam_id = ANY($1)`
+
+	rows, err := db.Query(query, pq.Array(steamIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get steam profiles: %w", err)
+	}
+	defer rows.Close()
+
+	profiles := make(map[string]*SteamProfile)
+	for rows.Next() {
+		profile := &SteamProfile{}
+		err := rows.Scan(&profile.SteamID, &profile.PersonaName, &profile.AvatarURL, &profile.ProfileURL, &profile.FetchedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan steam profile: %w", err)
+		}
+		profiles[profile.SteamID] = profile
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over steam profiles: %w", err)
+	}
+
+	return profiles, nil
+}
+
+// UpsertSteamProfile stores a freshly fetched Steam profile
+func upsertSteamProfile(profile *SteamProfile) error {
+	query := `
+		INSERT INTO steam_profiles (steam_id, persona_name, avatar_url, profile_url, fetched_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (steam_id) DO UPDATE SET
+			persona_name = EXCLUDED.persona_name,
+			avatar_url = EXCLUDED.avatar_url,
+			profile_url = EXCLUDED.profile_url,
+			fetched_at = EXCLUDED.fetched_at`
+
+	_, err := db.Exec(query, profile.SteamID, profile.PersonaName, profile.AvatarURL, profile.ProfileURL, profile.FetchedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store steam profile: %w", err)
+	}
+
+	return nil
+}
+
+// GetUserPollStates returns the poll state of every user that has one, keyed by user UUID
+func getUserPollStates() (map[uuid.UUID]*UserPollState, error) {
+	query := `
+		SELECT user_uuid, status, consecutive_failures, last_success_at, last_error, next_poll_at, notified_at, updated_at
+		FROM user_poll_states`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user poll states: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[uuid.UUID]*UserPollState)
+	for rows.Next() {
+		state := &UserPollState{}
+		err := rows.Scan(&state.UserUUID, &state.Status, &state.ConsecutiveFailures, &state.LastSuccessAt,
+			&state.LastError, &state.NextPollAt, &state.NotifiedAt, &state.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user poll state: %w", err)
+		}
+		states[state.UserUUID] = state
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over user poll states: %w", err)
+	}
+
+	return states, nil
+}
+
+// UpsertUserPollState stores the poll state of a user
+func upsertUserPollState(state *UserPollState) error {
+	query := `
+		INSERT INTO user_poll_states (user_uuid, status, consecutive_failures, last_success_at, last_error, next_poll_at, notified_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_uuid) DO UPDATE SET
+			status = EXCLUDED.status,
+			consecutive_failures = EXCLUDED.consecutive_failures,
+			last_success_at = EXCLUDED.last_success_at,
+			last_error = EXCLUDED.last_error,
+			next_poll_at = EXCLUDED.next_poll_at,
+			notified_at = EXCLUDED.notified_at,
+			updated_at = CURRENT_TIMESTAMP`
+
+	_, err := db.Exec(query, state.UserUUID, state.Status, state.ConsecutiveFailures, state.LastSuccessAt,
+		state.LastError, state.NextPollAt, state.NotifiedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store user poll state: %w", err)
+	}
+
+	return nil
+}
+
+// ResetUserPollState forgets a user's failures so they are polled again from the next cycle
+func resetUserPollState(userUUID uuid.UUID) error {
+	_, err := db.Exec(`DELETE FROM user_poll_states WHERE user_uuid = $1`, userUUID)
+	if err != nil {
+		return fmt.Errorf("failed to reset user poll state: %w", err)
+	}
+
+	return nil
+}
+
+// matchJobColumns lists the match_jobs columns in the order scanMatchJob reads them
+const matchJobColumns = `uuid, share_code, status, steam_ids, attempts, last_error, next_attempt_at,
+	download_requested_at, demo_ready_at, parse_requested_at, parsed_at, notified_at, failed_at,
+	created_at, updated_at`
+
+// scanMatchJob reads a row selected with matchJobColumns
+func scanMatchJob(row interface{ Scan(...interface{}) error }) (*MatchJob, error) {
+	job := &MatchJob{}
+	err := row.Scan(&job.UUID, &job.ShareCode, &job.Status, &job.SteamIDs, &job.Attempts, &job.LastError, &job.NextAttemptAt,
+		&job.DownloadRequestedAt, &job.DemoReadyAt, &job.ParseRequestedAt, &job.ParsedAt, &job.NotifiedAt, &job.FailedAt,
+		&job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// ClaimMatchJob inserts a discovered job for a share code if none exists and returns
+// whether it did. The reconciler leaves the new job alone for lease so the caller can
+// request the demo itself. steamIDs are added to the players of new and existing jobs.
+func claimMatchJob(shareCode string, steamIDs []string, lease time.Duration) (bool, error) {
+	// xmax is 0 for freshly inserted rows and set for rows updated on conflict
+	query := `
+		INSERT INTO match_jobs (share_code, status, attempts, next_attempt_at, steam_ids)
+		VALUES ($1, $2, 1, CURRENT_TIMESTAMP + make_interval(secs => $3), $4)
+		ON CONFLICT (share_code) DO UPDATE SET steam_ids = (
+			SELECT COALESCE(jsonb_agg(DISTINCT id), '[]'::jsonb)
+			FROM jsonb_array_elements(match_jobs.steam_ids || EXCLUDED.steam_ids) AS id
+		)
+		RETURNING xmax = 0`
+
+	if steamIDs == nil {
+		steamIDs = []string{}
+	}
+
+	var inserted bool
+	err := db.QueryRow(query, shareCode, MatchJobDiscovered, lease.Seconds(), StringSlice(steamIDs)).Scan(&inserted)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim match job: %w", err)
+	}
+
+	return inserted, nil
+}
+
+// AdvanceMatchJob moves the job of a share code to status, creating it if needed, and
+// records when it got there. The reconciler acts on the job again after deadline, or
+// never for a zero deadline. It returns false without changing anything when the job
+// is in a status status can't be reached from, e.g. for duplicate webhooks.
+func advanceMatchJob(shareCode, status string, deadline time.Duration) (bool, error) {
+	from, ok := matchJobTransitions[status]
+	if !ok {
+		return false, fmt.Errorf("unknown match job status %q", status)
+	}
+
+	// Every status but discovered has its own timestamp column named after it
+	query := fmt.Sprintf(`
+		INSERT INTO match_jobs (share_code, status, %[1]s, next_attempt_at)
+		VALUES ($1, $2, CURRENT_TIMESTAMP,
+			CASE WHEN $3::float8 > 0 THEN CURRENT_TIMESTAMP + make_interval(secs => $3::float8) END)
+		ON CONFLICT (share_code) DO UPDATE SET
+			attempts = CASE WHEN match_jobs.status = EXCLUDED.status THEN match_jobs.attempts ELSE 0 END,
+			status = EXCLUDED.status,
+			%[1]s = EXCLUDED.%[1]s,
+			last_error = '',
+			next_attempt_at = EXCLUDED.next_attempt_at
+		WHERE match_jobs.status = ANY($4)
+		RETURNING uuid`, status+"_at")
+
+	var jobUUID uuid.UUID
+	err := db.QueryRow(query, shareCode, status, deadline.Seconds(), pq.Array(from)).Scan(&jobUUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to advance match job to %s: %w", status, err)
+	}
+
+	return true, nil
+}
+
+// RetryMatchJobLater records the failed attempt number attempts and when the reconciler
+// should try again. Attempts already counted by a claim are kept.
+func retryMatchJobLater(shareCode string, attempts int, lastError string, delay time.Duration) error {
+	query := `
+		UPDATE match_jobs
+		SET attempts = GREATEST(attempts, $2), last_error = $3,
+			next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $4)
+		WHERE share_code = $1`
+
+	_, err := db.Exec(query, shareCode, attempts, lastError, delay.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to schedule match job retry: %w", err)
+	}
+
+	return nil
+}
+
+// FailMatchJob gives up on the job of a share code
+func failMatchJob(shareCode, lastError string) error {
+	query := `
+		UPDATE match_jobs
+		SET status = $2, failed_at = CURRENT_TIMESTAMP, last_error = $3, next_attempt_at = NULL
+		WHERE share_code = $1 AND status NOT IN ($4, $2)`
+
+	_, err := db.Exec(query, shareCode, MatchJobFailed, lastError, MatchJobNotified)
+	if err != nil {
+		return fmt.Errorf("failed to mark match job failed: %w", err)
+	}
+
+	return nil
+}
+
+// ClaimDueMatchJobs returns up to limit unfinished jobs whose next attempt is due, counting
+// the attempt and pushing their next attempt back by lease. Rows locked by another
+// replica are skipped, so every due job is claimed once.
+func claimDueMatchJobs(lease time.Duration, limit int) ([]*MatchJob, error) {
+	query := `
+		UPDATE match_jobs
+		SET attempts = attempts + 1,
+			next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $1),
+			leased_until = CURRENT_TIMESTAMP + make_interval(secs => $1)
+		WHERE uuid IN (
+			SELECT uuid FROM match_jobs
+			WHERE status NOT IN ($2, $3) AND next_attempt_at <= CURRENT_TIMESTAMP
+			ORDER BY next_attempt_at
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + matchJobColumns
+
+	rows, err := db.Query(query, lease.Seconds(), MatchJobNotified, MatchJobFailed, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim due match jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*MatchJob
+	for rows.Next() {
+		job, err := scanMatchJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan match job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over match jobs: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// LeaseMatchJob leases the unfinished job of a share code for a manual retry, due or not.
+// The retry doesn't count as an attempt. Returns sql.ErrNoRows when the job is done or
+// still leased by the reconciler or another retry.
+func leaseMatchJob(shareCode string, lease time.Duration) (*MatchJob, error) {
+	query := `
+		UPDATE match_jobs
+		SET next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2),
+			leased_until = CURRENT_TIMESTAMP + make_interval(secs => $2)
+		WHERE share_code = $1 AND status <> $3
+		AND (leased_until IS NULL OR leased_until <= CURRENT_TIMESTAMP)
+		RETURNING ` + matchJobColumns
+
+	job, err := scanMatchJob(db.QueryRow(query, shareCode, lease.Seconds(), MatchJobNotified))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lease match job: %w", err)
+	}
+
+	return job, nil
+}
+
+// GetMatchJob retrieves the job of a share code
+func getMatchJob(shareCode string) (*MatchJob, error) {
+	query := `SELECT ` + matchJobColumns + ` FROM match_jobs WHERE share_code = $1`
+
+	job, err := scanMatchJob(db.QueryRow(query, shareCode))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get match job: %w", err)
+	}
+
+	return job, nil
+}
+
+// matchJobInGuildClause matches jobs found for a player registered in the guild given as $1
+const matchJobInGuildClause = `EXISTS (
+		SELECT 1 FROM users u
+		JOIN guilds g ON g.user_ids @> jsonb_build_array(u.uuid::text)
+		WHERE g.guild_id = $1 AND mj.steam_ids @> jsonb_build_array(u.steam_id)
+	)`
+
+// GetTroubledMatchJobs returns the guild's failed jobs and unfinished jobs that are overdue or
+// had a failed attempt, most recently updated first
+func getTroubledMatchJobs(guildID string, limit int) ([]*MatchJob, error) {
+	query := `
+		SELECT ` + matchJobColumns + `
+		FROM match_jobs mj
+		WHERE ` + matchJobInGuildClause + `
+		AND (
+			status = $2
+			OR (status <> $3 AND (last_error <> '' OR next_attempt_at < CURRENT_TIMESTAMP))
+		)
+		ORDER BY updated_at DESC
+		LIMIT $4`
+
+	rows, err := db.Query(query, guildID, MatchJobFailed, MatchJobNotified, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get troubled match jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*MatchJob
+	for rows.Next() {
+		job, err := scanMatchJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan match job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over match jobs: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// GetGuildMatchJob retrieves the job of a share code if it was found for a player of the guild
+func getGuildMatchJob(guildID, shareCode string) (*MatchJob, error) {
+	query := `
+		SELECT ` + matchJobColumns + `
+		FROM match_jobs mj
+		WHERE mj.share_code = $2 AND ` + matchJobInGuildClause
+
+	job, err := scanMatchJob(db.QueryRow(query, guildID, shareCode))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get match job: %w", err)
+	}
+
+	return job, nil
+}
+
+// setMatchJobCallbackNonce stores the nonce of the callback URL handed to the demo service,
+// replacing the nonce of any earlier request
+func setMatchJobCallbackNonce(shareCode, nonce string) error {
+	query := `UPDATE match_jobs SET callback_nonce = $2 WHERE share_code = $1`
+
+	result, err := db.Exec(query, shareCode, nonce)
+	if err != nil {
+		return fmt.Errorf("failed to set match job callback nonce: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to set match job callback nonce: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("failed to set match job callback nonce: no match job for %s", shareCode)
+	}
+
+	return nil
+}
+
+// claimMatchJobCallbackNonce uses up the callback URL of nonce in one statement, so of two
+// concurrent deliveries only one is handled. It reports false when nonce is not the latest
+// unused callback URL of the job.
+func claimMatchJobCallbackNonce(shareCode, nonce string) (bool, error) {
+	query := `
+		UPDATE match_jobs SET callback_nonce = ''
+		WHERE share_code = $1 AND callback_nonce = $2 AND callback_nonce <> ''`
+
+	result, err := db.Exec(query, shareCode, nonce)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim match job callback nonce: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim match job callback nonce: %w", err)
+	}
+
+	return rows > 0, nil
+}
+
+// restoreMatchJobCallbackNonce makes a claimed callback URL valid again. A job that was
+// handed a newer callback URL in the meantime keeps it.
+func restoreMatchJobCallbackNonce(shareCode, nonce string) error {
+	query := `UPDATE match_jobs SET callback_nonce = $2 WHERE share_code = $1 AND callback_nonce = ''`
+
+	_, err := db.Exec(query, shareCode, nonce)
+	if err != nil {
+		return fmt.Errorf("failed to restore match job callback nonce: %w", err)
+	}
+
+	return nil
+}
+
+// getMatchNotifications returns the IDs of the guilds the summary of a game was posted to
+func getMatchNotifications(gameUUID uuid.UUID) (map[string]bool, error) {
+	query := `SELECT guild_id FROM match_notifications WHERE game_uuid = $1`
+
+	rows, err := db.Query(query, gameUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get match notifications: %w", err)
+	}
+	defer rows.Close()
+
+	sent := make(map[string]bool)
+	for rows.Next() {
+		var guildID string
+		if err := rows.Scan(&guildID); err != nil {
+			return nil, fmt.Errorf("failed to scan match notification: %w", err)
+		}
+		sent[guildID] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over match notifications: %w", err)
+	}
+
+	return sent, nil
+}
+
+// recordMatchNotification records that the summary of a game was posted to a guild
+func recordMatchNotification(gameUUID uuid.UUID, guildID string) error {
+	query := `
+		INSERT INTO match_notifications (game_uuid, guild_id)
+		VALUES ($1, $2)
+		ON CONFLICT (game_uuid, guild_id) DO NOTHING`
+
+	_, err := db.Exec(query, gameUUID, guildID)
+	if err != nil {
+		return fmt.Errorf("failed to record match notification: %w", err)
+	}
+
+	return nil
+}
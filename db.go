@@ -6,32 +6,42 @@ import (
 	"log"
 	"os"
 
+	"cs-match-summary-bot/authcrypt"
 	_ "github.com/lib/pq"
 )
 
 var db *sql.DB
 
+// authCodeKeys encrypts users.auth_code at rest
+var authCodeKeys *authcrypt.Keyring
+
 func initDB() error {
+	keys, err := authcrypt.LoadKeyring()
+	if err != nil {
+		return fmt.Errorf("failed to load auth code key: %w", err)
+	}
+	authCodeKeys = keys
+
 	host := os.Getenv("DB_HOST")
 	if host == "" {
 		host = "localhost"
 	}
-	
+
 	port := os.Getenv("DB_PORT")
 	if port == "" {
 		port = "5432"
 	}
-	
+
 	user := os.Getenv("DB_USER")
 	if user == "" {
 		user = "postgres"
 	}
-	
+
 	password := os.Getenv("DB_PASSWORD")
 	if password == "" {
 		password = "postgres"
 	}
-	
+
 	dbname := os.Getenv("DB_NAME")
 	if dbname == "" {
 		dbname = "cs"
@@ -40,7 +50,6 @@ func initDB() error {
 	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		host, port, user, password, dbname)
 
-	var err error
 	db, err = sql.Open("postgres", connStr)
 	if err != nil {
 		return err
@@ -51,12 +60,12 @@ func initDB() error {
 	}
 
 	log.Println("Database connected successfully")
-	
+
 	// Initialize tables
 	if err := initializeTables(); err != nil {
 		return fmt.Errorf("failed to initialize tables: %w", err)
 	}
-	
+
 	return nil
 }
 
@@ -64,4 +73,4 @@ func closeDB() {
 	if db != nil {
 		db.Close()
 	}
-}
\ No newline at end of file
+}
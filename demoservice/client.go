@@ -0,0 +1,169 @@
+// Package demoservice is a client for the demo service that downloads and parses
+// match demos and reports back through the bot's /webhooks/demoReady and
+// /webhooks/demoParsed callbacks.
+package demoservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Endpoint paths, followed by the share code
+	downloadPath = "/getDemo/"
+	parsePath    = "/parseDemo/"
+
+	// DefaultTimeout is how long a single request may take
+	DefaultTimeout    = 15 * time.Second
+	defaultRetries    = 2
+	defaultRetryDelay = time.Second
+	maxResponseSize   = 1 << 20
+)
+
+// Request is the body of a download or parse request
+type Request struct {
+	WebhookURL string `json:"webhook_url"`
+}
+
+// Response is the demo service's answer to a request
+type Response struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// StatusError is returned when the demo service answers with a non-200 status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("demo service returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// RejectedError is returned when the demo service answers a request with success:false
+type RejectedError struct {
+	Message string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("demo service rejected the request: %s", e.Message)
+}
+
+// Retryable reports whether a failed request may succeed when repeated: network
+// errors, timeouts, 429 and 5xx answers are, rejections and other statuses aren't
+func Retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Client is the demo service API used by the bot. Both requests only queue work:
+// the result arrives later as a callback to webhookURL.
+type Client interface {
+	// RequestDownload asks for the demo of shareCode to be downloaded, answered by a
+	// demoReady callback
+	RequestDownload(ctx context.Context, shareCode, webhookURL string) error
+	// RequestParse asks for the downloaded demo of shareCode to be parsed, answered by
+	// a demoParsed callback
+	RequestParse(ctx context.Context, shareCode, webhookURL string) error
+}
+
+// HTTPClient calls the demo service over HTTP, retrying transient failures
+type HTTPClient struct {
+	baseURL    string
+	http       *http.Client
+	retries    int
+	retryDelay time.Duration
+}
+
+// NewHTTPClient creates a client for the demo service at baseURL. Every request may
+// take up to timeout and is retried twice with backoff when Retryable.
+func NewHTTPClient(baseURL string, timeout time.Duration) *HTTPClient {
+	return &HTTPClient{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		http:       &http.Client{Timeout: timeout},
+		retries:    defaultRetries,
+		retryDelay: defaultRetryDelay,
+	}
+}
+
+// RequestDownload implements Client
+func (c *HTTPClient) RequestDownload(ctx context.Context, shareCode, webhookURL string) error {
+	return c.post(ctx, downloadPath+url.PathEscape(shareCode), webhookURL)
+}
+
+// RequestParse implements Client
+func (c *HTTPClient) RequestParse(ctx context.Context, shareCode, webhookURL string) error {
+	return c.post(ctx, parsePath+url.PathEscape(shareCode), webhookURL)
+}
+
+// post sends a request, doubling the wait between retries
+func (c *HTTPClient) post(ctx context.Context, path, webhookURL string) error {
+	body, err := json.Marshal(Request{WebhookURL: webhookURL})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	delay := c.retryDelay
+	for attempt := 0; ; attempt++ {
+		err = c.postOnce(ctx, path, body)
+		if err == nil || attempt >= c.retries || !Retryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// postOnce sends a single request and checks the answer
+func (c *HTTPClient) postOnce(ctx context.Context, path string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("demo service request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(data))}
+	}
+
+	var serviceResp Response
+	if err := json.Unmarshal(data, &serviceResp); err != nil {
+		return fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+	if !serviceResp.Success {
+		return &RejectedError{Message: serviceResp.Message}
+	}
+
+	return nil
+}
+
+var _ Client = (*HTTPClient)(nil)
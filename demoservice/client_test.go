@@ -0,0 +1,167 @@
+package demoservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient serves the demo service API with handler and retries without waiting
+func newTestClient(t *testing.T, handler http.HandlerFunc) *HTTPClient {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := NewHTTPClient(server.URL+"/", time.Second)
+	client.retryDelay = time.Millisecond
+	return client
+}
+
+// answer replies to every request with the statuses in order, repeating the last one
+func answer(calls *atomic.Int32, statuses ...int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1)) - 1
+		status := statuses[min(n, len(statuses)-1)]
+		writeResponse(w, status, http.StatusText(status))
+	}
+}
+
+func TestHTTPClientRequests(t *testing.T) {
+	var got Request
+	var path string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.EscapedPath()
+		json.NewDecoder(r.Body).Decode(&got)
+		writeResponse(w, http.StatusOK, "queued")
+	})
+
+	if err := client.RequestDownload(context.Background(), "CSGO-a/b", "http://localhost/webhooks/demoReady"); err != nil {
+		t.Fatalf("RequestDownload: %v", err)
+	}
+	if path != "/getDemo/CSGO-a%2Fb" || got.WebhookURL != "http://localhost/webhooks/demoReady" {
+		t.Errorf("download request = %s %+v", path, got)
+	}
+
+	if err := client.RequestParse(context.Background(), "CSGO-a", "http://localhost/webhooks/demoParsed"); err != nil {
+		t.Fatalf("RequestParse: %v", err)
+	}
+	if path != "/parseDemo/CSGO-a" || got.WebhookURL != "http://localhost/webhooks/demoParsed" {
+		t.Errorf("parse request = %s %+v", path, got)
+	}
+}
+
+func TestHTTPClientRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		calls    int32
+		status   int
+	}{
+		{"recovers from 503", []int{http.StatusServiceUnavailable, http.StatusOK}, 2, 0},
+		{"recovers from 429", []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusOK}, 3, 0},
+		{"gives up after two retries", []int{http.StatusBadGateway}, 3, http.StatusBadGateway},
+		{"doesn't retry 400", []int{http.StatusBadRequest}, 1, http.StatusBadRequest},
+		{"doesn't retry 404", []int{http.StatusNotFound, http.StatusOK}, 1, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			client := newTestClient(t, answer(&calls, tt.statuses...))
+
+			err := client.RequestDownload(context.Background(), "CSGO-a", "http://localhost")
+			if calls.Load() != tt.calls {
+				t.Errorf("made %d requests, want %d", calls.Load(), tt.calls)
+			}
+
+			if tt.status == 0 {
+				if err != nil {
+					t.Errorf("error = %v, want success", err)
+				}
+				return
+			}
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status {
+				t.Errorf("error = %v, want a StatusError with %d", err, tt.status)
+			}
+		})
+	}
+}
+
+func TestHTTPClientRejected(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Response{Success: false, Message: "Unknown share code"})
+	})
+
+	err := client.RequestParse(context.Background(), "CSGO-a", "http://localhost")
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) || rejected.Message != "Unknown share code" {
+		t.Fatalf("error = %v, want a RejectedError", err)
+	}
+	if Retryable(err) || calls.Load() != 1 {
+		t.Errorf("rejection was retried %d times", calls.Load()-1)
+	}
+}
+
+func TestHTTPClientStopsOnCancel(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, answer(&calls, http.StatusServiceUnavailable))
+	client.retryDelay = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := client.RequestDownload(ctx, "CSGO-a", "http://localhost")
+	if !Retryable(err) || calls.Load() != 1 {
+		t.Errorf("error = %v after %d requests, want the 503 of the only request", err, calls.Load())
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled request took %s", elapsed)
+	}
+}
+
+func TestHTTPClientTimeoutIsRetryable(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(200 * time.Millisecond)
+		writeResponse(w, http.StatusOK, "queued")
+	})
+	client.http.Timeout = 20 * time.Millisecond
+	client.retries = 1
+
+	err := client.RequestDownload(context.Background(), "CSGO-a", "http://localhost")
+	if !Retryable(err) || calls.Load() != 2 {
+		t.Errorf("error = %v after %d requests, want a retried timeout", err, calls.Load())
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&StatusError{StatusCode: http.StatusInternalServerError}, true},
+		{&StatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{&StatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{&StatusError{StatusCode: http.StatusBadRequest}, false},
+		{&StatusError{StatusCode: http.StatusUnauthorized}, false},
+		{&RejectedError{Message: "no"}, false},
+		{errors.New("failed to parse JSON response"), false},
+	}
+
+	for _, tt := range tests {
+		if got := Retryable(tt.err); got != tt.want {
+			t.Errorf("Retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package demoservice
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed fixtures/*.json
+var embeddedFixtures embed.FS
+
+// defaultFixture is used for share codes without a fixture of their own
+const defaultFixture = "default.json"
+
+// ErrRemoteCallback is returned for webhook URLs that don't point at this machine, so a
+// local run can't call back a deployed bot by accident
+var ErrRemoteCallback = errors.New("fake demo service only calls back localhost, set WEBHOOK_BASE_URL to the bot's local address")
+
+// Fixture is what the fake demo service reports for a share code
+type Fixture struct {
+	DemoPath string `json:"demo_path"`
+	// DownloadError and ParseError make the corresponding callback report success:false
+	DownloadError string `json:"download_error,omitempty"`
+	ParseError    string `json:"parse_error,omitempty"`
+	// DownloadReason and ParseReason are sent as the reason code of those failures
+	DownloadReason string          `json:"download_reason,omitempty"`
+	ParseReason    string          `json:"parse_reason,omitempty"`
+	Stats          json.RawMessage `json:"stats"`
+}
+
+// callbackPayload is the body of the demoReady and demoParsed callbacks
+type callbackPayload struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Reason  string `json:"reason,omitempty"`
+	Data    struct {
+		ShareCode string          `json:"share_code"`
+		DemoPath  string          `json:"demo_path"`
+		Stats     json.RawMessage `json:"stats,omitempty"`
+	} `json:"data"`
+}
+
+// Fake is a demo service for local development and tests. Requests are accepted right
+// away and answered after Delay with callbacks built from fixture files: <share_code>.json
+// when one exists, default.json otherwise. It can be used in-process as a Client or
+// served over HTTP in place of the hosted service.
+type Fake struct {
+	sources []fs.FS
+	http    *http.Client
+	pending sync.WaitGroup
+	mu      sync.Mutex
+	// Delay is how long the fake takes before calling back
+	Delay time.Duration
+	// SteamIDs replace the Steam IDs of the fixture's players in order, so parsed matches
+	// include locally registered users
+	SteamIDs []string
+	// AllowRemoteCallbacks lets webhook URLs point at other hosts than localhost
+	AllowRemoteCallbacks bool
+	// calls counts the requests made per method name
+	calls map[string]int
+}
+
+// NewFake creates a fake demo service. Fixtures in dir take precedence over the
+// built-in ones; an empty dir uses only the built-in fixtures.
+func NewFake(dir string) *Fake {
+	builtin, _ := fs.Sub(embeddedFixtures, "fixtures")
+	sources := []fs.FS{builtin}
+	if dir != "" {
+		sources = append([]fs.FS{os.DirFS(dir)}, sources...)
+	}
+
+	return &Fake{
+		sources: sources,
+		http:    &http.Client{Timeout: DefaultTimeout},
+		Delay:   2 * time.Second,
+		calls:   make(map[string]int),
+	}
+}
+
+// RequestDownload implements Client
+func (f *Fake) RequestDownload(ctx context.Context, shareCode, webhookURL string) error {
+	f.count("RequestDownload")
+	if err := f.checkCallback(webhookURL); err != nil {
+		return err
+	}
+	fixture, err := f.fixture(shareCode)
+	if err != nil {
+		return err
+	}
+
+	payload := callbackPayload{Success: fixture.DownloadError == "", Message: "Demo finished downloading."}
+	if !payload.Success {
+		payload.Message = fixture.DownloadError
+		payload.Reason = fixture.DownloadReason
+	}
+	payload.Data.ShareCode = shareCode
+	payload.Data.DemoPath = fixture.DemoPath
+
+	f.callBack(webhookURL, payload)
+	return nil
+}
+
+// RequestParse implements Client
+func (f *Fake) RequestParse(ctx context.Context, shareCode, webhookURL string) error {
+	f.count("RequestParse")
+	if err := f.checkCallback(webhookURL); err != nil {
+		return err
+	}
+	fixture, err := f.fixture(shareCode)
+	if err != nil {
+		return err
+	}
+
+	payload := callbackPayload{Success: fixture.ParseError == "", Message: "Demo parsed."}
+	if payload.Success {
+		payload.Data.Stats, err = withSteamIDs(fixture.Stats, f.SteamIDs)
+		if err != nil {
+			return err
+		}
+	} else {
+		payload.Message = fixture.ParseError
+		payload.Reason = fixture.ParseReason
+	}
+	payload.Data.ShareCode = shareCode
+	payload.Data.DemoPath = fixture.DemoPath
+
+	f.callBack(webhookURL, payload)
+	return nil
+}
+
+// Wait blocks until every callback fired so far was delivered
+func (f *Fake) Wait() {
+	f.pending.Wait()
+}
+
+// ServeHTTP serves the demo service API: POST /getDemo/<share_code> and
+// POST /parseDemo/<share_code> with a Request body
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var request Request
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.WebhookURL == "" {
+		writeResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var err error
+	var message string
+	switch {
+	case strings.HasPrefix(r.URL.Path, downloadPath):
+		err = f.RequestDownload(r.Context(), strings.TrimPrefix(r.URL.Path, downloadPath), request.WebhookURL)
+		message = "Demo download queued."
+	case strings.HasPrefix(r.URL.Path, parsePath):
+		err = f.RequestParse(r.Context(), strings.TrimPrefix(r.URL.Path, parsePath), request.WebhookURL)
+		message = "Demo parsing queued."
+	default:
+		writeResponse(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	if errors.Is(err, ErrRemoteCallback) {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeResponse(w, http.StatusOK, message)
+}
+
+// writeResponse answers with a Response, successful for 200
+func writeResponse(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Response{Success: status == http.StatusOK, Message: message})
+}
+
+// fixture loads the fixture of a share code, falling back to the default one
+func (f *Fake) fixture(shareCode string) (*Fixture, error) {
+	names := []string{defaultFixture}
+	if name := shareCode + ".json"; fs.ValidPath(name) && !strings.Contains(shareCode, "/") {
+		names = append([]string{name}, names...)
+	}
+
+	for _, name := range names {
+		for _, source := range f.sources {
+			data, err := fs.ReadFile(source, name)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read fixture %s: %w", name, err)
+			}
+
+			var fixture Fixture
+			if err := json.Unmarshal(data, &fixture); err != nil {
+				return nil, fmt.Errorf("failed to parse fixture %s: %w", name, err)
+			}
+			return &fixture, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no fixture for %s", shareCode)
+}
+
+// checkCallback rejects webhook URLs on other hosts unless AllowRemoteCallbacks is set
+func (f *Fake) checkCallback(webhookURL string) error {
+	if f.AllowRemoteCallbacks {
+		return nil
+	}
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	if host := u.Hostname(); host != "localhost" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return fmt.Errorf("%w: got %s", ErrRemoteCallback, u.Host)
+		}
+	}
+	return nil
+}
+
+// withSteamIDs replaces the Steam IDs of the first players in stats with steamIDs
+func withSteamIDs(stats json.RawMessage, steamIDs []string) (json.RawMessage, error) {
+	if len(steamIDs) == 0 {
+		return stats, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(stats, &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture stats: %w", err)
+	}
+	var players []map[string]json.RawMessage
+	if err := json.Unmarshal(fields["players"], &players); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture players: %w", err)
+	}
+
+	for n := range min(len(players), len(steamIDs)) {
+		players[n]["steam_id"], _ = json.Marshal(steamIDs[n])
+	}
+
+	var err error
+	if fields["players"], err = json.Marshal(players); err != nil {
+		return nil, fmt.Errorf("failed to encode fixture players: %w", err)
+	}
+	return json.Marshal(fields)
+}
+
+// callBack posts payload to webhookURL after Delay
+func (f *Fake) callBack(webhookURL string, payload callbackPayload) {
+	f.pending.Add(1)
+	go func() {
+		defer f.pending.Done()
+		time.Sleep(f.Delay)
+
+		body, err := json.Marshal(payload)
+		if err != nil {
+			log.Printf("Fake demo service failed to marshal callback: %v", err)
+			return
+		}
+
+		resp, err := f.http.Post(webhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Fake demo service callback for %s failed: %v", payload.Data.ShareCode, err)
+			return
+		}
+		resp.Body.Close()
+		log.Printf("Fake demo service called back for %s with status %d", payload.Data.ShareCode, resp.StatusCode)
+	}()
+}
+
+// count records a call
+func (f *Fake) count(method string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[method]++
+}
+
+// CallsFor returns how often method was called, e.g. "RequestDownload"
+func (f *Fake) CallsFor(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+// SplitSteamIDs splits a comma-separated list of Steam IDs for Fake.SteamIDs, ignoring blanks
+func SplitSteamIDs(list string) []string {
+	var steamIDs []string
+	for _, steamID := range strings.Split(list, ",") {
+		if steamID = strings.TrimSpace(steamID); steamID != "" {
+			steamIDs = append(steamIDs, steamID)
+		}
+	}
+	return steamIDs
+}
+
+var _ Client = (*Fake)(nil)
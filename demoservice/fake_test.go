@@ -0,0 +1,226 @@
+package demoservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// callbackRecorder receives the fake's callbacks
+type callbackRecorder struct {
+	mu       sync.Mutex
+	payloads map[string]callbackPayload
+	server   *httptest.Server
+}
+
+func newCallbackRecorder(t *testing.T) *callbackRecorder {
+	t.Helper()
+	rec := &callbackRecorder{payloads: make(map[string]callbackPayload)}
+	rec.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload callbackPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("invalid callback: %v", err)
+		}
+		rec.mu.Lock()
+		rec.payloads[r.URL.Path] = payload
+		rec.mu.Unlock()
+	}))
+	t.Cleanup(rec.server.Close)
+	return rec
+}
+
+// payload returns the callback received on path
+func (rec *callbackRecorder) payload(t *testing.T, path string) callbackPayload {
+	t.Helper()
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	payload, ok := rec.payloads[path]
+	if !ok {
+		t.Fatalf("no callback on %s", path)
+	}
+	return payload
+}
+
+// newTestFake creates a fake that calls back right away
+func newTestFake(dir string) *Fake {
+	fake := NewFake(dir)
+	fake.Delay = 0
+	return fake
+}
+
+// fixtureStats decodes the parts of the fixture stats the tests look at
+type fixtureStats struct {
+	Map     string `json:"map"`
+	Players []struct {
+		SteamID string `json:"steam_id"`
+		Name    string `json:"name"`
+	} `json:"players"`
+}
+
+func decodeStats(t *testing.T, raw json.RawMessage) fixtureStats {
+	t.Helper()
+	var stats fixtureStats
+	if err := json.Unmarshal(raw, &stats); err != nil {
+		t.Fatalf("invalid stats: %v", err)
+	}
+	return stats
+}
+
+func TestFakeDefaultFixture(t *testing.T) {
+	rec := newCallbackRecorder(t)
+	fake := newTestFake("")
+
+	if err := fake.RequestDownload(context.Background(), "CSGO-a", rec.server.URL+"/ready"); err != nil {
+		t.Fatalf("RequestDownload: %v", err)
+	}
+	if err := fake.RequestParse(context.Background(), "CSGO-a", rec.server.URL+"/parsed"); err != nil {
+		t.Fatalf("RequestParse: %v", err)
+	}
+	fake.Wait()
+
+	ready := rec.payload(t, "/ready")
+	if !ready.Success || ready.Data.ShareCode != "CSGO-a" || ready.Data.DemoPath == "" || ready.Data.Stats != nil {
+		t.Errorf("demoReady = %+v", ready)
+	}
+
+	parsed := rec.payload(t, "/parsed")
+	stats := decodeStats(t, parsed.Data.Stats)
+	if !parsed.Success || stats.Map != "de_mirage" || len(stats.Players) != 10 || stats.Players[0].SteamID != "76561198000000001" {
+		t.Errorf("demoParsed = %+v with stats %+v", parsed, stats)
+	}
+	if fake.CallsFor("RequestDownload") != 1 || fake.CallsFor("RequestParse") != 1 {
+		t.Errorf("calls = %d downloads, %d parses", fake.CallsFor("RequestDownload"), fake.CallsFor("RequestParse"))
+	}
+}
+
+func TestFakeFixtureDirectory(t *testing.T) {
+	dir := t.TempDir()
+	fixture := `{"demo_path": "/demos/expired.dem", "download_error": "Demo has expired", "download_reason": "expired", "parse_error": "Corrupt demo"}`
+	if err := os.WriteFile(filepath.Join(dir, "CSGO-expired.json"), []byte(fixture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := newCallbackRecorder(t)
+	fake := newTestFake(dir)
+	fake.RequestDownload(context.Background(), "CSGO-expired", rec.server.URL+"/ready")
+	fake.RequestParse(context.Background(), "CSGO-expired", rec.server.URL+"/parsed")
+	fake.RequestDownload(context.Background(), "CSGO-other", rec.server.URL+"/other")
+	fake.Wait()
+
+	if ready := rec.payload(t, "/ready"); ready.Success || ready.Message != "Demo has expired" || ready.Reason != "expired" || ready.Data.DemoPath != "/demos/expired.dem" {
+		t.Errorf("demoReady = %+v, want the fixture's download error", ready)
+	}
+	if parsed := rec.payload(t, "/parsed"); parsed.Success || parsed.Message != "Corrupt demo" || parsed.Reason != "" || parsed.Data.Stats != nil {
+		t.Errorf("demoParsed = %+v, want the fixture's parse error", parsed)
+	}
+	if other := rec.payload(t, "/other"); !other.Success {
+		t.Errorf("share code without a fixture = %+v, want the built-in default", other)
+	}
+}
+
+func TestFakeInvalidFixture(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "CSGO-broken.json"), []byte(`{`), 0o644)
+
+	err := newTestFake(dir).RequestParse(context.Background(), "CSGO-broken", "http://localhost/parsed")
+	if err == nil {
+		t.Fatal("broken fixture was accepted")
+	}
+}
+
+func TestFakeSteamIDs(t *testing.T) {
+	rec := newCallbackRecorder(t)
+	fake := newTestFake("")
+	fake.SteamIDs = []string{"76561197960287930", "76561197960287931"}
+
+	fake.RequestParse(context.Background(), "CSGO-a", rec.server.URL+"/parsed")
+	fake.Wait()
+
+	stats := decodeStats(t, rec.payload(t, "/parsed").Data.Stats)
+	if len(stats.Players) != 10 {
+		t.Fatalf("got %d players, want 10", len(stats.Players))
+	}
+	if stats.Players[0].SteamID != "76561197960287930" || stats.Players[1].SteamID != "76561197960287931" {
+		t.Errorf("first players = %+v, want the configured Steam IDs", stats.Players[:2])
+	}
+	if stats.Players[2].SteamID != "76561198000000003" || stats.Players[0].Name != "s1mple" {
+		t.Errorf("players = %+v, want the rest of the fixture unchanged", stats.Players)
+	}
+}
+
+func TestSplitSteamIDs(t *testing.T) {
+	got := SplitSteamIDs(" 76561197960287930, ,76561197960287931,")
+	if len(got) != 2 || got[0] != "76561197960287930" || got[1] != "76561197960287931" {
+		t.Errorf("SplitSteamIDs() = %q", got)
+	}
+	if got := SplitSteamIDs(""); got != nil {
+		t.Errorf("SplitSteamIDs(\"\") = %q, want nil", got)
+	}
+}
+
+func TestFakeRefusesRemoteCallbacks(t *testing.T) {
+	fake := newTestFake("")
+
+	err := fake.RequestDownload(context.Background(), "CSGO-a", "https://cs-bot.example.com/webhooks/demoReady")
+	if !errors.Is(err, ErrRemoteCallback) {
+		t.Errorf("error = %v, want ErrRemoteCallback", err)
+	}
+	for _, local := range []string{"http://localhost:8080/webhooks/demoReady", "http://127.0.0.1:8080/webhooks/demoReady", "http://[::1]/webhooks/demoReady"} {
+		if err := fake.checkCallback(local); err != nil {
+			t.Errorf("checkCallback(%s) = %v", local, err)
+		}
+	}
+
+	fake.AllowRemoteCallbacks = true
+	if err := fake.checkCallback("https://cs-bot.example.com/webhooks/demoReady"); err != nil {
+		t.Errorf("remote callback with AllowRemoteCallbacks = %v", err)
+	}
+}
+
+func TestFakeServeHTTP(t *testing.T) {
+	rec := newCallbackRecorder(t)
+	fake := newTestFake("")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := NewHTTPClient(server.URL, DefaultTimeout)
+	if err := client.RequestDownload(context.Background(), "CSGO-a", rec.server.URL+"/ready"); err != nil {
+		t.Fatalf("RequestDownload: %v", err)
+	}
+	if err := client.RequestParse(context.Background(), "CSGO-a", rec.server.URL+"/parsed"); err != nil {
+		t.Fatalf("RequestParse: %v", err)
+	}
+	fake.Wait()
+	rec.payload(t, "/ready")
+	rec.payload(t, "/parsed")
+
+	// A remote webhook URL is a mistake in the bot's configuration, not worth retrying
+	err := client.RequestDownload(context.Background(), "CSGO-a", "https://cs-bot.example.com/webhooks/demoReady")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest || Retryable(err) {
+		t.Errorf("remote callback error = %v, want a 400", err)
+	}
+
+	for _, tt := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodGet, "/getDemo/CSGO-a", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/getDemo/CSGO-a", `{}`, http.StatusBadRequest},
+		{http.MethodPost, "/unknown/CSGO-a", `{"webhook_url": "http://localhost"}`, http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+		fake.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, w.Code, tt.status)
+		}
+	}
+}
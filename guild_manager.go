@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
 
+	"cs-match-summary-bot/sharecode"
 	"github.com/bwmarrin/discordgo"
 	"github.com/google/uuid"
 )
@@ -14,7 +17,7 @@ import (
 func ensureGuildExists(guildID string) (*Guild, error) {
 	guild, err := getGuildByGuildID(guildID)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, sql.ErrNoRows) {
 			// Guild doesn't exist, create it with a default channel
 			return createGuild(guildID, guildID) // Use guild ID as temporary channel ID
 		}
@@ -29,7 +32,7 @@ func updateGuildChannel(guildID, channelID string) error {
 	if err != nil {
 		return err
 	}
-	
+
 	guild.ChannelID = channelID
 	return updateGuild(guild)
 }
@@ -40,12 +43,19 @@ func getGuildStats(guildID string) (map[string]int, error) {
 	if err != nil {
 		return nil, err
 	}
-	
+
+	games, parsed, playerMatches, err := getGuildMatchCounts(guildID)
+	if err != nil {
+		return nil, err
+	}
+
 	stats := map[string]int{
-		"users": len(guild.UserIDs),
-		"games": len(guild.GameIDs),
+		"users":          len(guild.UserIDs),
+		"games":          games,
+		"parsed_games":   parsed,
+		"player_matches": playerMatches,
 	}
-	
+
 	return stats, nil
 }
 
@@ -56,16 +66,16 @@ func registerUserToGuild(guildID, steamID, authCode string) (*User, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to ensure guild exists: %w", err)
 	}
-	
+
 	// Check if user already exists
 	user, err := getUserBySteamID(steamID)
-	if err != nil && err != sql.ErrNoRows {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return nil, fmt.Errorf("failed to check existing user: %w", err)
 	}
-	
+
 	// Create user if doesn't exist
-	if err == sql.ErrNoRows {
-		user, err = createUser(steamID, authCode, "")
+	if errors.Is(err, sql.ErrNoRows) {
+		user, err = createUser(steamID, authCode, "", "")
 		if err != nil {
 			return nil, fmt.Errorf("failed to create user: %w", err)
 		}
@@ -77,13 +87,13 @@ func registerUserToGuild(guildID, steamID, authCode string) (*User, error) {
 			return nil, fmt.Errorf("failed to update user: %w", err)
 		}
 	}
-	
+
 	// Add user to guild
 	err = addUserToGuild(guildID, user.UUID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to add user to guild: %w", err)
 	}
-	
+
 	return user, nil
 }
 
@@ -94,15 +104,15 @@ func processMatchShare(guildID, shareCode, demoName string, steamIDs []string) (
 	if err != nil {
 		return nil, fmt.Errorf("failed to ensure guild exists: %w", err)
 	}
-	
+
 	// Check if game already exists
-	game, err := getGameByShareCode(shareCode)
-	if err != nil && err != sql.ErrNoRows {
+	game, err := findGameByShareCode(shareCode)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return nil, fmt.Errorf("failed to check existing game: %w", err)
 	}
-	
+
 	// Create game if doesn't exist
-	if err == sql.ErrNoRows {
+	if errors.Is(err, sql.ErrNoRows) {
 		game, err = createGame(shareCode, demoName, steamIDs)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create game: %w", err)
@@ -115,13 +125,13 @@ func processMatchShare(guildID, shareCode, demoName string, steamIDs []string) (
 			return nil, fmt.Errorf("failed to update game: %w", err)
 		}
 	}
-	
+
 	// Add game to guild
 	err = addGameToGuild(guildID, game.UUID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to add game to guild: %w", err)
 	}
-	
+
 	// Add game to all users who participated
 	for _, steamID := range steamIDs {
 		err = addGameToUser(steamID, game.UUID)
@@ -129,10 +139,54 @@ func processMatchShare(guildID, shareCode, demoName string, steamIDs []string) (
 			log.Printf("Warning: failed to add game to user %s: %v", steamID, err)
 		}
 	}
-	
+
 	return game, nil
 }
 
+// linkMatchParticipants stores the roster of a parsed match on the game and links the
+// game to every registered participant and to the guilds they are registered in.
+// It returns the guilds that should be notified about the match.
+func linkMatchParticipants(game *Game, steamIDs []string) (map[string]*Guild, error) {
+	game.SteamIDs = StringSlice(steamIDs)
+	if err := updateGame(game); err != nil {
+		return nil, fmt.Errorf("failed to update game roster: %w", err)
+	}
+
+	guilds := make(map[string]*Guild)
+	for _, steamID := range steamIDs {
+		user, err := getUserBySteamID(steamID)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Warning: failed to look up user %s: %v", steamID, err)
+			}
+			continue // User not registered, skip
+		}
+
+		if err := addGameToUser(steamID, game.UUID); err != nil {
+			log.Printf("Warning: failed to add game to user %s: %v", steamID, err)
+		}
+
+		userGuilds, err := getGuildsForUser(user.UUID)
+		if err != nil {
+			log.Printf("Warning: failed to get guilds for user %s: %v", steamID, err)
+			continue
+		}
+
+		for _, guild := range userGuilds {
+			if _, ok := guilds[guild.GuildID]; ok {
+				continue
+			}
+			if err := addGameToGuild(guild.GuildID, game.UUID); err != nil {
+				log.Printf("Warning: failed to add game to guild %s: %v", guild.GuildID, err)
+				continue
+			}
+			guilds[guild.GuildID] = guild
+		}
+	}
+
+	return guilds, nil
+}
+
 // handleAdminCommand processes admin commands from Discord
 func handleAdminCommand(s *discordgo.Session, m *discordgo.MessageCreate, command string, args []string) {
 	// Check if user has admin permissions
@@ -141,25 +195,25 @@ func handleAdminCommand(s *discordgo.Session, m *discordgo.MessageCreate, comman
 		s.ChannelMessageSend(m.ChannelID, "❌ Error checking permissions.")
 		return
 	}
-	
+
 	hasAdminPerms := false
 	for _, roleID := range member.Roles {
 		role, err := s.State.Role(m.GuildID, roleID)
 		if err != nil {
 			continue
 		}
-		if role.Permissions&discordgo.PermissionAdministrator != 0 || 
-		   role.Permissions&discordgo.PermissionManageGuild != 0 {
+		if role.Permissions&discordgo.PermissionAdministrator != 0 ||
+			role.Permissions&discordgo.PermissionManageGuild != 0 {
 			hasAdminPerms = true
 			break
 		}
 	}
-	
+
 	if !hasAdminPerms {
 		s.ChannelMessageSend(m.ChannelID, "❌ You need Administrator or Manage Server permissions to use this command.")
 		return
 	}
-	
+
 	switch command {
 	case "setchannel":
 		handleSetChannel(s, m, args)
@@ -180,7 +234,7 @@ func handleAdminCommand(s *discordgo.Session, m *discordgo.MessageCreate, comman
 
 func handleSetChannel(s *discordgo.Session, m *discordgo.MessageCreate, args []string) {
 	var channelID string
-	
+
 	if len(args) > 0 {
 		// Try to parse channel mention or ID
 		channelID = strings.Trim(args[0], "<>#")
@@ -188,13 +242,13 @@ func handleSetChannel(s *discordgo.Session, m *discordgo.MessageCreate, args []s
 		// Use current channel
 		channelID = m.ChannelID
 	}
-	
+
 	err := updateGuildChannel(m.GuildID, channelID)
 	if err != nil {
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("❌ Error updating channel: %v", err))
 		return
 	}
-	
+
 	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("✅ Bot channel updated to <#%s>", channelID))
 }
 
@@ -204,7 +258,7 @@ func handleStats(s *discordgo.Session, m *discordgo.MessageCreate) {
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("❌ Error getting stats: %v", err))
 		return
 	}
-	
+
 	embed := &discordgo.MessageEmbed{
 		Title: "📊 Guild Statistics",
 		Color: 0x00ff00,
@@ -219,9 +273,19 @@ func handleStats(s *discordgo.Session, m *discordgo.MessageCreate) {
 				Value:  fmt.Sprintf("%d", stats["games"]),
 				Inline: true,
 			},
+			{
+				Name:   "Parsed Games",
+				Value:  fmt.Sprintf("%d", stats["parsed_games"]),
+				Inline: true,
+			},
+			{
+				Name:   "Matches Played by Members",
+				Value:  fmt.Sprintf("%d", stats["player_matches"]),
+				Inline: true,
+			},
 		},
 	}
-	
+
 	s.ChannelMessageSendEmbed(m.ChannelID, embed)
 }
 
@@ -230,16 +294,20 @@ func handleRegister(s *discordgo.Session, m *discordgo.MessageCreate, args []str
 		s.ChannelMessageSend(m.ChannelID, "❌ Usage: `!cs register <steam_id> <auth_code>`")
 		return
 	}
-	
-	steamID := args[0]
+
+	steamID, err := resolveSteamID(context.Background(), args[0])
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("❌ Invalid Steam ID: %v", err))
+		return
+	}
 	authCode := args[1]
-	
+
 	user, err := registerUserToGuild(m.GuildID, steamID, authCode)
 	if err != nil {
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("❌ Error registering user: %v", err))
 		return
 	}
-	
+
 	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("✅ User registered successfully!\n**Steam ID:** %s\n**UUID:** %s", user.SteamID, user.UUID))
 }
 
@@ -248,17 +316,31 @@ func handleAddMatch(s *discordgo.Session, m *discordgo.MessageCreate, args []str
 		s.ChannelMessageSend(m.ChannelID, "❌ Usage: `!cs addmatch <share_code> <demo_name> [steam_id1] [steam_id2] ...`")
 		return
 	}
-	
+
 	shareCode := args[0]
 	demoName := args[1]
-	steamIDs := args[2:]
-	
+
+	if _, err := sharecode.Decode(shareCode); err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("❌ Invalid share code: %v", err))
+		return
+	}
+
+	var steamIDs []string
+	for _, arg := range args[2:] {
+		steamID, err := resolveSteamID(context.Background(), arg)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("❌ Invalid Steam ID `%s`: %v", arg, err))
+			return
+		}
+		steamIDs = append(steamIDs, steamID)
+	}
+
 	game, err := processMatchShare(m.GuildID, shareCode, demoName, steamIDs)
 	if err != nil {
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("❌ Error adding match: %v", err))
 		return
 	}
-	
+
 	embed := &discordgo.MessageEmbed{
 		Title: "🎮 Match Added Successfully",
 		Color: 0x00ff00,
@@ -280,7 +362,7 @@ func handleAddMatch(s *discordgo.Session, m *discordgo.MessageCreate, args []str
 			},
 		},
 	}
-	
+
 	s.ChannelMessageSendEmbed(m.ChannelID, embed)
 }
 
@@ -290,38 +372,38 @@ func handleListUsers(s *discordgo.Session, m *discordgo.MessageCreate) {
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("❌ Error getting guild: %v", err))
 		return
 	}
-	
+
 	if len(guild.UserIDs) == 0 {
 		s.ChannelMessageSend(m.ChannelID, "📝 No users registered in this guild.")
 		return
 	}
-	
+
 	var userInfo []string
 	for i, userIDStr := range guild.UserIDs {
 		if i >= 10 { // Limit to first 10 users
 			userInfo = append(userInfo, fmt.Sprintf("... and %d more", len(guild.UserIDs)-10))
 			break
 		}
-		
+
 		userUUID, err := uuid.Parse(userIDStr)
 		if err != nil {
 			continue
 		}
-		
+
 		user, err := getUserByUUID(userUUID)
 		if err != nil {
 			continue
 		}
-		
+
 		userInfo = append(userInfo, fmt.Sprintf("• Steam ID: `%s`", user.SteamID))
 	}
-	
+
 	embed := &discordgo.MessageEmbed{
 		Title:       "👥 Registered Users",
 		Description: strings.Join(userInfo, "\n"),
 		Color:       0x0099ff,
 	}
-	
+
 	s.ChannelMessageSendEmbed(m.ChannelID, embed)
 }
 
@@ -331,29 +413,29 @@ func handleListGames(s *discordgo.Session, m *discordgo.MessageCreate) {
 		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("❌ Error getting games: %v", err))
 		return
 	}
-	
+
 	if len(games) == 0 {
 		s.ChannelMessageSend(m.ChannelID, "📝 No games tracked in this guild.")
 		return
 	}
-	
+
 	var gameInfo []string
 	for i, game := range games {
 		if i >= 10 { // Limit to first 10 games
 			gameInfo = append(gameInfo, fmt.Sprintf("... and %d more", len(games)-10))
 			break
 		}
-		
-		gameInfo = append(gameInfo, fmt.Sprintf("• **%s** - %s (%d players)", 
+
+		gameInfo = append(gameInfo, fmt.Sprintf("• **%s** - %s (%d players)",
 			game.ShareCode, game.DemoName, len(game.SteamIDs)))
 	}
-	
+
 	embed := &discordgo.MessageEmbed{
 		Title:       "🎮 Tracked Games",
 		Description: strings.Join(gameInfo, "\n"),
 		Color:       0xff9900,
 	}
-	
+
 	s.ChannelMessageSendEmbed(m.ChannelID, embed)
 }
 
@@ -367,19 +449,19 @@ func handleHelpCommand(s *discordgo.Session, m *discordgo.MessageCreate) {
 				Value: "`!cs help` - Show this help message\n`!cs ping` - Test bot responsiveness",
 			},
 			{
-				Name:  "Admin Commands (Requires Admin/Manage Server)",
+				Name: "Admin Commands (Requires Admin/Manage Server)",
 				Value: "`!cs setchannel [#channel]` - Set notification channel\n" +
-					   "`!cs stats` - Show guild statistics\n" +
-					   "`!cs register <steam_id> <auth_code>` - Register a user\n" +
-					   "`!cs addmatch <share_code> <demo_name> [steam_ids...]` - Add a match\n" +
-					   "`!cs listusers` - List registered users\n" +
-					   "`!cs listgames` - List tracked games",
+					"`!cs stats` - Show guild statistics\n" +
+					"`!cs register <steam_id> <auth_code>` - Register a user\n" +
+					"`!cs addmatch <share_code> <demo_name> [steam_ids...]` - Add a match\n" +
+					"`!cs listusers` - List registered users\n" +
+					"`!cs listgames` - List tracked games",
 			},
 		},
 		Footer: &discordgo.MessageEmbedFooter{
 			Text: "CS Match Summary Bot - Track your matches with ease!",
 		},
 	}
-	
+
 	s.ChannelMessageSendEmbed(m.ChannelID, embed)
-}
\ No newline at end of file
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"cs-match-summary-bot/authcrypt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+// useTestKeyring encrypts auth codes with a throwaway key for the rest of the test
+func useTestKeyring(t *testing.T) {
+	t.Helper()
+	keys, err := authcrypt.NewKeyring(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+	previous := authCodeKeys
+	authCodeKeys = keys
+	t.Cleanup(func() { authCodeKeys = previous })
+}
+
+// expectGuild makes getGuildByGuildID find a guild with the given users once
+func expectGuild(t *testing.T, mock sqlmock.Sqlmock, guildID string, userIDs ...string) {
+	t.Helper()
+	if userIDs == nil {
+		userIDs = []string{}
+	}
+	mock.ExpectQuery(`FROM guilds WHERE guild_id = \$1`).
+		WithArgs(guildID).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "guild_id", "channel_id", "user_ids", "game_ids", "created_at", "updated_at"}).
+			AddRow(uuid.New().String(), guildID, "channel-1", jsonb(t, userIDs), []byte(`[]`), time.Now(), time.Now()))
+}
+
+func TestRegisterUserToGuildCreatesUser(t *testing.T) {
+	mock := mockDB(t)
+	useTestKeyring(t)
+
+	steamID := "76561198000000001"
+	expectGuild(t, mock, "guild-1")
+	expectNoUser(mock, steamID)
+	mock.ExpectQuery(`INSERT INTO users`).
+		WithArgs(sqlmock.AnyArg(), steamID, sqlmock.AnyArg(), "", sqlmock.AnyArg(), "").
+		WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}).AddRow(time.Now(), time.Now()))
+	mock.ExpectExec(`UPDATE guilds\s+SET user_ids`).
+		WithArgs("guild-1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	user, err := registerUserToGuild("guild-1", steamID, "AAAA-AAAAA-AAAA")
+	if err != nil {
+		t.Fatalf("registerUserToGuild: %v", err)
+	}
+	if user.SteamID != steamID || user.AuthCode != "AAAA-AAAAA-AAAA" {
+		t.Errorf("user = %+v", user)
+	}
+}
+
+func TestRegisterUserToGuildUpdatesExistingUser(t *testing.T) {
+	mock := mockDB(t)
+	useTestKeyring(t)
+
+	existing := &User{UUID: uuid.New(), SteamID: "76561198000000001", AuthCode: "AAAA-AAAAA-AAAA"}
+	expectGuild(t, mock, "guild-1")
+	expectUser(t, mock, existing)
+	mock.ExpectExec(`UPDATE users\s+SET auth_code`).
+		WithArgs(existing.UUID, sqlmock.AnyArg(), "", sqlmock.AnyArg(), "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM user_poll_states`).
+		WithArgs(existing.UUID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE guilds\s+SET user_ids`).
+		WithArgs("guild-1", `["`+existing.UUID.String()+`"]`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	user, err := registerUserToGuild("guild-1", existing.SteamID, "BBBB-BBBBB-BBBB")
+	if err != nil {
+		t.Fatalf("registerUserToGuild: %v", err)
+	}
+	if user.UUID != existing.UUID || user.AuthCode != "BBBB-BBBBB-BBBB" {
+		t.Errorf("user = %+v", user)
+	}
+}
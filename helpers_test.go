@@ -0,0 +1,307 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bwmarrin/discordgo"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// mockDB replaces the global database with a sqlmock whose queries are matched as
+// regular expressions in any order, and checks every expectation was met at the end
+func mockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+
+	mockConn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	mock.MatchExpectationsInOrder(false)
+
+	previous := db
+	db = mockConn
+	t.Cleanup(func() {
+		db = previous
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet database expectations: %v", err)
+		}
+		mockConn.Close()
+	})
+
+	return mock
+}
+
+// jsonb encodes a value the way a JSONB column is returned
+func jsonb(t *testing.T, value interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("failed to marshal %v: %v", value, err)
+	}
+	return data
+}
+
+// expectUser makes getUserBySteamID find user once
+func expectUser(t *testing.T, mock sqlmock.Sqlmock, user *User) {
+	t.Helper()
+	mock.ExpectQuery(`FROM users WHERE steam_id = \$1`).
+		WithArgs(user.SteamID).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "steam_id", "auth_code", "last_share_code", "game_ids", "discord_user_id", "created_at", "updated_at"}).
+			AddRow(user.UUID.String(), user.SteamID, user.AuthCode, user.LastShareCode, jsonb(t, user.GameIDs), user.DiscordUserID, time.Now(), time.Now()))
+}
+
+// expectNoUser makes getUserBySteamID find nobody for steamID once
+func expectNoUser(mock sqlmock.Sqlmock, steamID string) {
+	mock.ExpectQuery(`FROM users WHERE steam_id = \$1`).
+		WithArgs(steamID).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid"}))
+}
+
+// expectGuildsForUser makes getGuildsForUser return guilds for user once
+func expectGuildsForUser(t *testing.T, mock sqlmock.Sqlmock, user *User, guilds ...*Guild) {
+	t.Helper()
+	rows := sqlmock.NewRows([]string{"uuid", "guild_id", "channel_id", "user_ids", "game_ids", "created_at", "updated_at"})
+	for _, guild := range guilds {
+		rows.AddRow(guild.UUID.String(), guild.GuildID, guild.ChannelID, jsonb(t, guild.UserIDs), jsonb(t, guild.GameIDs), time.Now(), time.Now())
+	}
+	mock.ExpectQuery(`FROM guilds WHERE user_ids @> jsonb_build_array\(\$1::text\)`).
+		WithArgs(user.UUID.String()).
+		WillReturnRows(rows)
+}
+
+// expectGame makes the lookup of a game by the match ID of its share code return game once
+func expectGame(t *testing.T, mock sqlmock.Sqlmock, game *Game) {
+	t.Helper()
+	mock.ExpectQuery(`FROM games WHERE match_id = \$1`).
+		WithArgs(game.MatchID).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "share_code", "match_id", "outcome_id", "token", "demo_name", "steam_ids", "created_at", "updated_at"}).
+			AddRow(game.UUID.String(), game.ShareCode, int64(game.MatchID), int64(game.OutcomeID), int64(game.Token), game.DemoName, jsonb(t, game.SteamIDs), time.Now(), time.Now()))
+}
+
+// expectMatchJob makes getMatchJob return a job in status once
+func expectMatchJob(t *testing.T, mock sqlmock.Sqlmock, shareCode, status string, steamIDs []string) {
+	t.Helper()
+	mock.ExpectQuery(`FROM match_jobs WHERE share_code = \$1`).
+		WithArgs(shareCode).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "share_code", "status", "steam_ids", "attempts", "last_error", "next_attempt_at",
+			"download_requested_at", "demo_ready_at", "parse_requested_at", "parsed_at", "notified_at", "failed_at",
+			"created_at", "updated_at"}).
+			AddRow(uuid.New().String(), shareCode, status, jsonb(t, steamIDs), 0, "", nil,
+				nil, nil, nil, nil, nil, nil, time.Now(), time.Now()))
+}
+
+// expectAdvance makes advanceMatchJob move the job of shareCode to status once
+func expectAdvance(mock sqlmock.Sqlmock, shareCode, status string) {
+	mock.ExpectQuery(`INSERT INTO match_jobs \(share_code, status, `+status+`_at, next_attempt_at\)`).
+		WithArgs(shareCode, status, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid"}).AddRow(uuid.New().String()))
+}
+
+// expectSaveMatchStats expects the transaction of saveMatchStats for stats
+func expectSaveMatchStats(mock sqlmock.Sqlmock, stats *MatchStats) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE games SET stats = \$2 WHERE uuid = \$1`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO match_meta`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM match_players`).WillReturnResult(sqlmock.NewResult(0, 0))
+	for range stats.Players {
+		mock.ExpectExec(`INSERT INTO match_players`).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(`DELETE FROM match_rounds`).WillReturnResult(sqlmock.NewResult(0, 0))
+	for range stats.Rounds {
+		mock.ExpectExec(`INSERT INTO match_rounds`).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+}
+
+// expectNoSteamProfiles makes getSteamProfiles find nothing cached once
+func expectNoSteamProfiles(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`FROM steam_profiles WHERE steam_id = ANY\(\$1\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"steam_id", "persona_name", "avatar_url", "profile_url", "fetched_at"}))
+}
+
+// expectMatchNotifications makes getMatchNotifications find the guilds the summary of game
+// was already sent to once
+func expectMatchNotifications(mock sqlmock.Sqlmock, game *Game, guildIDs ...string) {
+	rows := sqlmock.NewRows([]string{"guild_id"})
+	for _, guildID := range guildIDs {
+		rows.AddRow(guildID)
+	}
+	mock.ExpectQuery(`FROM match_notifications WHERE game_uuid = \$1`).
+		WithArgs(game.UUID).
+		WillReturnRows(rows)
+}
+
+// expectRecordNotification expects the summary of game to be recorded as sent to guildID once
+func expectRecordNotification(mock sqlmock.Sqlmock, game *Game, guildID string) {
+	mock.ExpectExec(`INSERT INTO match_notifications`).
+		WithArgs(game.UUID, guildID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// jsonArg matches a JSONB argument holding the given value
+type jsonArg struct {
+	want string
+}
+
+// Match implements sqlmock.Argument
+func (a jsonArg) Match(value driver.Value) bool {
+	var got string
+	switch v := value.(type) {
+	case string:
+		got = v
+	case []byte:
+		got = string(v)
+	default:
+		return false
+	}
+	return got == a.want
+}
+
+// sentMessage is a message the bot sent to Discord
+type sentMessage struct {
+	ChannelID string
+	Content   string
+	Embeds    []*discordgo.MessageEmbed
+	// Files lists the names of the attached files
+	Files []string
+}
+
+// fakeDiscord records the messages sent through a Discord session instead of calling Discord
+type fakeDiscord struct {
+	mu       sync.Mutex
+	messages []sentMessage
+	// responses holds the content of every interaction response
+	responses []string
+	// failSends makes every channel message fail as if the bot lost access to the channel
+	failSends bool
+	// failChannels makes the messages to these channels fail
+	failChannels []string
+}
+
+// newFakeDiscord sets the webhook context to a session backed by a fakeDiscord
+func newFakeDiscord(t *testing.T) *fakeDiscord {
+	t.Helper()
+
+	session, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	fake := &fakeDiscord{}
+	session.Client = &http.Client{Transport: fake}
+
+	previous := webhookCtx
+	SetWebhookContext(session)
+	t.Cleanup(func() { webhookCtx = previous })
+
+	return fake
+}
+
+// RoundTrip implements http.RoundTripper
+func (f *fakeDiscord) RoundTrip(req *http.Request) (*http.Response, error) {
+	respond := func(body string) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	}
+
+	parts := strings.Split(req.URL.Path, "/")
+	if req.Method == http.MethodPost && parts[len(parts)-1] == "callback" {
+		var response struct {
+			Data struct {
+				Content string `json:"content"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&response); err != nil {
+			return nil, err
+		}
+		f.mu.Lock()
+		f.responses = append(f.responses, response.Data.Content)
+		f.mu.Unlock()
+		return respond(`{}`)
+	}
+	if req.Method != http.MethodPost || len(parts) < 2 || parts[len(parts)-1] != "messages" {
+		return respond(`{"id":"1"}`)
+	}
+	channelID := parts[len(parts)-2]
+
+	f.mu.Lock()
+	failSends := f.failSends || slices.Contains(f.failChannels, channelID)
+	f.mu.Unlock()
+	if failSends {
+		return &http.Response{
+			StatusCode: http.StatusForbidden,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"message":"Missing Access","code":50001}`)),
+			Request:    req,
+		}, nil
+	}
+
+	message := sentMessage{ChannelID: channelID}
+	var payload struct {
+		Content string                    `json:"content"`
+		Embeds  []*discordgo.MessageEmbed `json:"embeds"`
+	}
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/") {
+		reader, err := req.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if part.FormName() == "payload_json" {
+				if err := json.NewDecoder(part).Decode(&payload); err != nil {
+					return nil, err
+				}
+			} else if part.FileName() != "" {
+				message.Files = append(message.Files, part.FileName())
+			}
+		}
+	} else if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		return nil, err
+	}
+	message.Content = payload.Content
+	message.Embeds = payload.Embeds
+
+	f.mu.Lock()
+	f.messages = append(f.messages, message)
+	f.mu.Unlock()
+
+	return respond(`{"id":"1","channel_id":"` + channelID + `"}`)
+}
+
+// sent returns the messages sent so far
+func (f *fakeDiscord) sent() []sentMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]sentMessage(nil), f.messages...)
+}
+
+// responded returns the content of the interaction responses sent so far
+func (f *fakeDiscord) responded() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.responses...)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	leaderboardSize              = 10
+	leaderboardDefaultMinMatches = 3
+)
+
+// leaderboardMetrics are the stats players can be ranked by
+var leaderboardMetrics = []struct {
+	key   string
+	label string
+}{
+	{"rating", "Rating"},
+	{"adr", "ADR"},
+	{"kd", "K/D"},
+	{"win_rate", "Win Rate"},
+	{"matches", "Matches Played"},
+}
+
+// leaderboardWindows are the time ranges a leaderboard can cover, by when matches were recorded
+var leaderboardWindows = []struct {
+	key   string
+	label string
+}{
+	{"7d", "Last 7 days"},
+	{"30d", "Last 30 days"},
+	{"season", "This season"},
+	{"all", "All time"},
+}
+
+// leaderboardSince returns the start of a leaderboard window, zero for all time
+func leaderboardSince(window string, now time.Time) time.Time {
+	switch window {
+	case "7d":
+		return now.AddDate(0, 0, -7)
+	case "30d":
+		return now.AddDate(0, 0, -30)
+	case "season":
+		return seasonStart(now)
+	}
+	return time.Time{}
+}
+
+// seasonStart reads the season start from LEADERBOARD_SEASON_START (YYYY-MM-DD),
+// falling back to the start of the current year
+func seasonStart(now time.Time) time.Time {
+	if value := os.Getenv("LEADERBOARD_SEASON_START"); value != "" {
+		start, err := time.Parse(matchesDateLayout, value)
+		if err == nil {
+			return start
+		}
+		log.Printf("Invalid LEADERBOARD_SEASON_START %q, using start of year: %v", value, err)
+	}
+	return time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// handleLeaderboardSlashCommand ranks the guild's registered players over a time window
+func handleLeaderboardSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	metric := "rating"
+	window := "30d"
+	minMatches := leaderboardDefaultMinMatches
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "metric":
+			metric = option.StringValue()
+		case "window":
+			window = option.StringValue()
+		case "min_matches":
+			minMatches = int(option.IntValue())
+		}
+	}
+
+	since := leaderboardSince(window, time.Now())
+	entries, err := getGuildLeaderboard(i.GuildID, metric, since, max(minMatches, 1), leaderboardSize)
+	if err != nil {
+		log.Printf("Error getting leaderboard for guild %s: %v", i.GuildID, err)
+		respondWithError(s, i, "Failed to get leaderboard")
+		return
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{buildLeaderboardEmbed(entries, metric, window, minMatches)},
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to leaderboard command: %v", err)
+	}
+}
+
+// buildLeaderboardEmbed lists the ranked players with the ranking metric first
+func buildLeaderboardEmbed(entries []*LeaderboardEntry, metric, window string, minMatches int) *discordgo.MessageEmbed {
+	medals := []string{"🥇", "🥈", "🥉"}
+
+	var lines []string
+	for n, entry := range entries {
+		rank := fmt.Sprintf("`%d.`", n+1)
+		if n < len(medals) {
+			rank = medals[n]
+		}
+		name := entry.Name
+		if name == "" {
+			name = entry.SteamID
+		}
+		lines = append(lines, fmt.Sprintf("%s **%s** — %s • %d matches",
+			rank, truncate(name, 32), formatLeaderboardValue(&entry.PlayerAggregate, metric), entry.Matches))
+	}
+
+	description := strings.Join(lines, "\n")
+	if len(entries) == 0 {
+		description = fmt.Sprintf("📝 No registered players with at least %d parsed matches.", minMatches)
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("🏆 Leaderboard — %s", leaderboardLabel(metric)),
+		Description: description,
+		Color:       0xffcc00,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("%s • minimum %d matches", windowLabel(window), minMatches),
+		},
+	}
+}
+
+// formatLeaderboardValue renders the metric a player is ranked by
+func formatLeaderboardValue(aggregate *PlayerAggregate, metric string) string {
+	switch metric {
+	case "adr":
+		return formatOptionalFloat(aggregate.ADR, "%.1f ADR")
+	case "kd":
+		return fmt.Sprintf("%.2f K/D", aggregate.KD())
+	case "win_rate":
+		return fmt.Sprintf("%.0f%% won", aggregate.WinRate())
+	case "matches":
+		return fmt.Sprintf("%d-%d-%d", aggregate.Wins, aggregate.Losses, aggregate.Ties)
+	}
+	return formatOptionalFloat(aggregate.Rating, "%.2f rating")
+}
+
+func leaderboardLabel(metric string) string {
+	for _, m := range leaderboardMetrics {
+		if m.key == metric {
+			return m.label
+		}
+	}
+	return metric
+}
+
+func windowLabel(window string) string {
+	for _, w := range leaderboardWindows {
+		if w.key == window {
+			return w.label
+		}
+	}
+	return window
+}
+
+// leaderboardChoices builds slash command choices from key/label pairs
+func leaderboardChoices(options []struct{ key, label string }) []*discordgo.ApplicationCommandOptionChoice {
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(options))
+	for _, option := range options {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: option.label, Value: option.key})
+	}
+	return choices
+}
@@ -2,17 +2,18 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
-	"time"
 
+	"cs-match-summary-bot/steamapi"
+	"cs-match-summary-bot/webhooks"
 	"github.com/bwmarrin/discordgo"
 	"github.com/joho/godotenv"
-	"cs-match-summary-bot/webhooks"
 )
 
 // Global Steam poller instance
@@ -36,6 +37,21 @@ func main() {
 		log.Fatal("DISCORD_BOT_TOKEN environment variable is required")
 	}
 
+	steamAPIKey := os.Getenv("STEAM_API_KEY")
+	if steamAPIKey == "" {
+		log.Fatal("STEAM_API_KEY environment variable is required")
+	}
+
+	// Callback URLs handed to the demo service are signed with this secret
+	webhookSecret := os.Getenv("WEBHOOK_SECRET")
+	if webhookSecret == "" {
+		log.Fatal("WEBHOOK_SECRET environment variable is required")
+	}
+	callbackSigner := webhooks.NewSigner([]byte(webhookSecret))
+
+	pollerConfig := loadPollerConfig()
+	steamClient = steamapi.NewRateLimited(steamapi.NewHTTPClient(steamAPIKey), pollerConfig.RatePerSecond, pollerConfig.Burst)
+
 	// Create a new Discord session
 	dg, err := discordgo.New("Bot " + token)
 	if err != nil {
@@ -48,6 +64,7 @@ func main() {
 	dg.AddHandler(guildDelete)
 	dg.AddHandler(ready)
 	dg.AddHandler(handleSlashCommand)
+	dg.AddHandler(handleComponentInteraction)
 
 	// Set intents
 	dg.Identify.Intents = discordgo.IntentsGuildMessages | discordgo.IntentMessageContent | discordgo.IntentsGuilds
@@ -76,19 +93,21 @@ func main() {
 
 	// Set up webhook context with Discord session
 	SetWebhookContext(dg)
-	
+
 	// Initialize Steam poller
-	steamPoller = NewSteamPoller()
-	
+	steamPoller = NewSteamPoller(steamClient, newDemoService(), pollerConfig, callbackSigner)
+
 	// Configure webhook handlers
 	handlers := &webhooks.HandlerFunctions{
-		DemoReady:  HandleDemoReady,
-		DemoParsed: HandleDemoParsed,
-		MatchQuery: HandleMatchQuery,
-		UserQuery:  HandleUserQuery,
-		GuildQuery: HandleGuildQuery,
+		DemoReady:     HandleDemoReady,
+		DemoParsed:    HandleDemoParsed,
+		MatchQuery:    HandleMatchQuery,
+		UserQuery:     HandleUserQuery,
+		GuildQuery:    HandleGuildQuery,
+		PollerMetrics: HandlePollerMetrics,
+		Signer:        callbackSigner,
 	}
-	
+
 	// Start webhook server
 	go func() {
 		if err := webhooks.StartServer(webhookHost, webhookPort, handlers); err != nil {
@@ -99,17 +118,9 @@ func main() {
 	// Start Steam API poller
 	go steamPoller.Start()
 
-	// Start cleanup routine for processed codes
-	go func() {
-		ticker := time.NewTicker(1 * time.Hour)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				steamPoller.CleanupProcessedCodes()
-			}
-		}
-	}()
+	// Retry match jobs that failed or got stuck in the demo pipeline
+	reconciler := NewMatchJobReconciler(steamPoller)
+	go reconciler.Start()
 
 	fmt.Println("Bot is now running. Press CTRL-C to exit.")
 	fmt.Printf("Webhook server listening on %s:%s\n", webhookHost, webhookPort)
@@ -120,7 +131,8 @@ func main() {
 
 	// Stop Steam poller
 	steamPoller.Stop()
-	
+	reconciler.Stop()
+
 	// Cleanly close down the Discord session
 	dg.Close()
 }
@@ -138,10 +150,10 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 		if len(parts) < 2 {
 			return
 		}
-		
+
 		command := parts[1]
 		args := parts[2:]
-		
+
 		switch command {
 		case "help":
 			handleHelpCommand(s, m)
@@ -171,7 +183,7 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 // This function will be called when the bot joins a new guild
 func guildCreate(s *discordgo.Session, g *discordgo.GuildCreate) {
 	log.Printf("Bot joined guild: %s (%s)", g.Name, g.ID)
-	
+
 	// Find the first available text channel as default channel
 	var defaultChannelID string
 	for _, channel := range g.Channels {
@@ -184,7 +196,7 @@ func guildCreate(s *discordgo.Session, g *discordgo.GuildCreate) {
 			}
 		}
 	}
-	
+
 	// If no suitable channel found, use the guild's system channel or the first channel
 	if defaultChannelID == "" {
 		if g.SystemChannelID != "" {
@@ -196,22 +208,22 @@ func guildCreate(s *discordgo.Session, g *discordgo.GuildCreate) {
 			defaultChannelID = g.ID // Fallback to guild ID
 		}
 	}
-	
+
 	// Create guild entry in database
 	guild, err := createGuild(g.ID, defaultChannelID)
 	if err != nil {
 		log.Printf("Error creating guild in database: %v", err)
 		return
 	}
-	
+
 	log.Printf("Successfully added guild to database: %s (UUID: %s)", guild.GuildID, guild.UUID)
-	
+
 	// Send welcome message if we have a valid channel
 	if defaultChannelID != g.ID {
 		welcomeMessage := "🎮 **CS Match Summary Bot** has joined your server!\n\n" +
 			"I can help you track CS match summaries and demo files. " +
 			"Use this channel for match notifications, or update the channel with your preferred settings later."
-		
+
 		_, err = s.ChannelMessageSend(defaultChannelID, welcomeMessage)
 		if err != nil {
 			log.Printf("Error sending welcome message: %v", err)
@@ -222,17 +234,17 @@ func guildCreate(s *discordgo.Session, g *discordgo.GuildCreate) {
 // This function will be called when the bot leaves a guild
 func guildDelete(s *discordgo.Session, g *discordgo.GuildDelete) {
 	log.Printf("Bot left guild: %s", g.ID)
-	
+
 	// Note: We might want to keep the data for potential re-joins
 	// Instead of deleting, we could add a "left_at" timestamp
 	// For now, we'll just log it and keep the data
-	
+
 	guild, err := getGuildByGuildID(g.ID)
 	if err != nil {
 		log.Printf("Error retrieving guild from database: %v", err)
 		return
 	}
-	
+
 	log.Printf("Guild data preserved in database: %s (UUID: %s)", guild.GuildID, guild.UUID)
 }
 
@@ -240,25 +252,25 @@ func guildDelete(s *discordgo.Session, g *discordgo.GuildDelete) {
 func ready(s *discordgo.Session, r *discordgo.Ready) {
 	log.Printf("Bot is ready! Logged in as: %s#%s", s.State.User.Username, s.State.User.Discriminator)
 	log.Printf("Bot is in %d guilds", len(r.Guilds))
-	
+
 	// Register all existing guilds in the database
 	for _, guild := range r.Guilds {
 		log.Printf("Checking guild: %s (%s)", guild.Name, guild.ID)
-		
+
 		// Check if guild already exists in database
 		existingGuild, err := getGuildByGuildID(guild.ID)
-		if err != nil && err != sql.ErrNoRows {
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
 			log.Printf("Error checking guild %s: %v", guild.ID, err)
 			continue
 		}
-		
+
 		// If guild doesn't exist, create it
-		if err == sql.ErrNoRows {
+		if errors.Is(err, sql.ErrNoRows) {
 			log.Printf("Guild %s not found in database, creating...", guild.ID)
-			
+
 			// Find a suitable default channel
 			var defaultChannelID string
-			
+
 			// Get full guild information to access channels
 			fullGuild, err := s.Guild(guild.ID)
 			if err != nil {
@@ -275,7 +287,7 @@ func ready(s *discordgo.Session, r *discordgo.Ready) {
 						}
 					}
 				}
-				
+
 				// Fallback options
 				if defaultChannelID == "" {
 					if fullGuild.SystemChannelID != "" {
@@ -285,19 +297,19 @@ func ready(s *discordgo.Session, r *discordgo.Ready) {
 					}
 				}
 			}
-			
+
 			// Create guild in database
 			newGuild, err := createGuild(guild.ID, defaultChannelID)
 			if err != nil {
 				log.Printf("Error creating guild %s in database: %v", guild.ID, err)
 				continue
 			}
-			
+
 			log.Printf("Successfully registered existing guild: %s (UUID: %s)", newGuild.GuildID, newGuild.UUID)
 		} else {
 			log.Printf("Guild %s already exists in database (UUID: %s)", existingGuild.GuildID, existingGuild.UUID)
 		}
 	}
-	
+
 	log.Printf("Finished registering existing guilds")
 }
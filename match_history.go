@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	matchesPageSize     = 10
+	matchesCustomID     = "matches"
+	matchesDateLayout   = "2006-01-02"
+	matchesMapNameLimit = 32
+)
+
+// handleMatchesSlashCommand shows the first page of the guild's match history
+func handleMatchesSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var filter MatchFilter
+	var err error
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "player":
+			filter.SteamID, err = resolveSteamID(context.Background(), option.StringValue())
+			if err != nil {
+				respondWithError(s, i, fmt.Sprintf("Invalid player Steam ID: %v", err))
+				return
+			}
+		case "member":
+			filter.DiscordUserID = option.UserValue(nil).ID
+		case "map":
+			filter.Map = normalizeMapName(option.StringValue())
+		case "result":
+			filter.Result = option.StringValue()
+		case "from":
+			filter.From, err = time.Parse(matchesDateLayout, strings.TrimSpace(option.StringValue()))
+			if err != nil {
+				respondWithError(s, i, "Invalid `from` date, use YYYY-MM-DD")
+				return
+			}
+		case "to":
+			filter.To, err = time.Parse(matchesDateLayout, strings.TrimSpace(option.StringValue()))
+			if err != nil {
+				respondWithError(s, i, "Invalid `to` date, use YYYY-MM-DD")
+				return
+			}
+			// Include the whole end day
+			filter.To = filter.To.AddDate(0, 0, 1)
+		}
+	}
+
+	if filter.SteamID != "" && filter.DiscordUserID != "" {
+		respondWithError(s, i, "Use either `player` or `member`, not both")
+		return
+	}
+
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		respondWithError(s, i, "`from` must not be after `to`")
+		return
+	}
+
+	response, err := buildMatchesPage(i.GuildID, filter, 0)
+	if err != nil {
+		log.Printf("Error building match history: %v", err)
+		respondWithError(s, i, "Failed to get match history")
+		return
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: response,
+	})
+	if err != nil {
+		log.Printf("Error responding to matches command: %v", err)
+	}
+}
+
+// handleMatchesPageButton switches an existing match history message to another page
+func handleMatchesPageButton(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	page, filter, err := parseMatchesCustomID(i.MessageComponentData().CustomID)
+	if err != nil {
+		log.Printf("Invalid matches button %q: %v", i.MessageComponentData().CustomID, err)
+		respondWithError(s, i, "This button is no longer valid")
+		return
+	}
+
+	response, err := buildMatchesPage(i.GuildID, filter, page)
+	if err != nil {
+		log.Printf("Error building match history: %v", err)
+		respondWithError(s, i, "Failed to get match history")
+		return
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: response,
+	})
+	if err != nil {
+		log.Printf("Error updating matches page: %v", err)
+	}
+}
+
+// buildMatchesPage renders one page of match history with Previous/Next buttons
+func buildMatchesPage(guildID string, filter MatchFilter, page int) (*discordgo.InteractionResponseData, error) {
+	if page < 0 {
+		page = 0
+	}
+
+	listings, total, err := getGamesForGuildPage(guildID, filter, matchesPageSize, page*matchesPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := (total + matchesPageSize - 1) / matchesPageSize
+	if pages == 0 {
+		pages = 1
+	}
+
+	var lines []string
+	for n, listing := range listings {
+		line := fmt.Sprintf("`%d.` ", page*matchesPageSize+n+1)
+		if listing.Parsed {
+			line += fmt.Sprintf("**%s** %d - %d", displayMapName(listing.Map), listing.TeamAScore, listing.TeamBScore)
+		} else {
+			line += "*not parsed yet*"
+		}
+		line += fmt.Sprintf(" • <t:%d:d> • `%s`", listing.CreatedAt.Unix(), listing.ShareCode)
+		lines = append(lines, line)
+	}
+
+	description := strings.Join(lines, "\n")
+	if len(listings) == 0 {
+		description = "📝 No matches found."
+	}
+	if filter.DiscordUserID != "" {
+		// Mentions only render in the description, not the footer
+		description = fmt.Sprintf("Matches of <@%s>\n\n%s", filter.DiscordUserID, description)
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "🎮 Match History",
+		Description: description,
+		Color:       0xff9900,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Page %d/%d • %d matches%s", page+1, pages, total, describeMatchFilter(filter)),
+		},
+	}
+
+	return &discordgo.InteractionResponseData{
+		Embeds: []*discordgo.MessageEmbed{embed},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Previous",
+						Style:    discordgo.SecondaryButton,
+						CustomID: matchesCustomIDFor(page-1, filter),
+						Disabled: page == 0,
+					},
+					discordgo.Button{
+						Label:    "Next",
+						Style:    discordgo.SecondaryButton,
+						CustomID: matchesCustomIDFor(page+1, filter),
+						Disabled: page+1 >= pages,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// matchesCustomIDFor encodes the page and filter into a button custom ID:
+// matches|<page>|<steam_id>|<discord_user_id>|<map>|<result>|<from>|<to>
+func matchesCustomIDFor(page int, filter MatchFilter) string {
+	formatDate := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(matchesDateLayout)
+	}
+
+	return strings.Join([]string{
+		matchesCustomID,
+		strconv.Itoa(max(page, 0)),
+		filter.SteamID,
+		filter.DiscordUserID,
+		filter.Map,
+		filter.Result,
+		formatDate(filter.From),
+		formatDate(filter.To),
+	}, "|")
+}
+
+// parseMatchesCustomID decodes a custom ID produced by matchesCustomIDFor
+func parseMatchesCustomID(customID string) (int, MatchFilter, error) {
+	var filter MatchFilter
+
+	parts := strings.Split(customID, "|")
+	if len(parts) != 8 || parts[0] != matchesCustomID {
+		return 0, filter, fmt.Errorf("unexpected custom ID format")
+	}
+
+	page, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, filter, fmt.Errorf("invalid page: %w", err)
+	}
+
+	filter.SteamID = parts[2]
+	filter.DiscordUserID = parts[3]
+	filter.Map = parts[4]
+	filter.Result = parts[5]
+	for _, date := range []struct {
+		value string
+		dst   *time.Time
+	}{{parts[6], &filter.From}, {parts[7], &filter.To}} {
+		if date.value == "" {
+			continue
+		}
+		*date.dst, err = time.Parse(matchesDateLayout, date.value)
+		if err != nil {
+			return 0, filter, fmt.Errorf("invalid date: %w", err)
+		}
+	}
+
+	return page, filter, nil
+}
+
+// describeMatchFilter summarizes the active filters for the page footer
+func describeMatchFilter(filter MatchFilter) string {
+	var parts []string
+	if filter.SteamID != "" {
+		parts = append(parts, "player "+filter.SteamID)
+	}
+	if filter.Map != "" {
+		parts = append(parts, displayMapName(filter.Map))
+	}
+	if filter.Result != "" {
+		parts = append(parts, filter.Result)
+	}
+	if !filter.From.IsZero() {
+		parts = append(parts, "from "+filter.From.Format(matchesDateLayout))
+	}
+	if !filter.To.IsZero() {
+		parts = append(parts, "to "+filter.To.AddDate(0, 0, -1).Format(matchesDateLayout))
+	}
+
+	if len(parts) == 0 {
+		return ""
+	}
+	return " • " + strings.Join(parts, ", ")
+}
+
+// normalizeMapName turns user input like "Mirage" into the demo map name "de_mirage"
+func normalizeMapName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name != "" && !strings.Contains(name, "_") {
+		name = "de_" + name
+	}
+	return truncate(strings.ReplaceAll(name, "|", ""), matchesMapNameLimit)
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"cs-match-summary-bot/webhooks"
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// matchJobLease is how long a job being worked on is left alone by other attempts
+	matchJobLease = 2 * time.Minute
+	// matchJobStepTimeout bounds one step of a job, the demo service's own retries included,
+	// so a hanging call gives up well within the lease
+	matchJobStepTimeout = time.Minute
+	// matchJobMaxAttempts is how often a stage is tried before the job fails
+	matchJobMaxAttempts = 5
+	matchJobRetryBase   = time.Minute
+	matchJobRetryMax    = time.Hour
+	reconcileInterval   = time.Minute
+	reconcileBatchSize  = 20
+	// callbackTTL is how long a signed callback URL handed to the demo service stays valid
+	callbackTTL = 24 * time.Hour
+)
+
+// matchJobTransitions lists the statuses each status can be reached from. A status can
+// be re-entered when the reconciler retries it, and failed jobs are revived by late
+// callbacks.
+var matchJobTransitions = map[string][]string{
+	MatchJobDownloadRequested: {MatchJobDiscovered, MatchJobDownloadRequested, MatchJobFailed},
+	MatchJobDemoReady:         {MatchJobDiscovered, MatchJobDownloadRequested, MatchJobFailed},
+	MatchJobParseRequested:    {MatchJobDemoReady, MatchJobParseRequested, MatchJobFailed},
+	MatchJobParsed:            {MatchJobDiscovered, MatchJobDownloadRequested, MatchJobDemoReady, MatchJobParseRequested, MatchJobFailed},
+	MatchJobNotified:          {MatchJobParsed, MatchJobFailed},
+}
+
+// matchJobDeadlines is how long a job may stay in a status before the reconciler steps in:
+// the demo service gets half an hour per request, the bot's own steps a few minutes
+var matchJobDeadlines = map[string]time.Duration{
+	MatchJobDownloadRequested: 30 * time.Minute,
+	MatchJobDemoReady:         matchJobLease,
+	MatchJobParseRequested:    30 * time.Minute,
+	MatchJobParsed:            5 * time.Minute,
+}
+
+// advanceMatchJobTo moves a job to status with that status's deadline
+func advanceMatchJobTo(shareCode, status string) (bool, error) {
+	return advanceMatchJob(shareCode, status, matchJobDeadlines[status])
+}
+
+// matchJobRetryDelay doubles the wait after every attempt, up to an hour
+func matchJobRetryDelay(attempts int) time.Duration {
+	delay := matchJobRetryBase
+	for n := 1; n < attempts && delay < matchJobRetryMax; n++ {
+		delay *= 2
+	}
+	return min(delay, matchJobRetryMax)
+}
+
+// recordMatchJobError schedules a retry after a failed attempt, or fails the job once
+// it ran out of attempts
+func recordMatchJobError(shareCode string, attempts int, cause error) {
+	var err error
+	if attempts >= matchJobMaxAttempts {
+		log.Printf("Match job %s failed after %d attempts: %v", shareCode, attempts, cause)
+		err = failMatchJob(shareCode, cause.Error())
+	} else {
+		delay := matchJobRetryDelay(attempts)
+		log.Printf("Match job %s attempt %d failed, retrying in %s: %v", shareCode, attempts, delay, cause)
+		err = retryMatchJobLater(shareCode, attempts, cause.Error(), delay)
+	}
+	if err != nil {
+		log.Printf("Error recording match job error for %s: %v", shareCode, err)
+	}
+}
+
+// newCallbackNonce returns a random nonce for a signed callback URL
+func newCallbackNonce() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate callback nonce: %w", err)
+	}
+	return hex.EncodeToString(nonce), nil
+}
+
+// Reasons shown when the demo service can't deliver a match
+const (
+	demoFailureExpired  = "expired"
+	demoFailureDownload = "download error"
+	demoFailureParse    = "parse error"
+)
+
+// Reasons the demo service gives in the reason field of a failed callback
+const (
+	// demoReasonExpired means the demo is gone for good
+	demoReasonExpired = "expired"
+	// demoReasonTransient means the request may succeed when repeated
+	demoReasonTransient = "transient"
+	// demoReasonDownloadFailed and demoReasonParseFailed mean repeating the request won't help
+	demoReasonDownloadFailed = "download_failed"
+	demoReasonParseFailed    = "parse_failed"
+)
+
+// demoTransientHints mark failure messages worth retrying, demoExpiredHints demos that are gone for good
+var (
+	demoTransientHints = []string{"timeout", "timed out", "temporar", "rate limit", "too many requests", "try again", "busy", "overloaded", "service unavailable", "connection"}
+	demoExpiredHints   = []string{"expired", "not found", "no longer available", "404", "gone", "deleted"}
+)
+
+// classifyDemoFailure turns a failed demo service callback into a reason and whether
+// retrying can help. The structured reason code decides when the service sent a known
+// one; otherwise the message is matched against known hints. Unknown download errors are
+// retried, unknown parse errors are not since parsing the same demo again fails the same way.
+func classifyDemoFailure(stage, code, message string) (string, bool) {
+	reason := demoFailureDownload
+	if stage == webhooks.StageDemoParsed {
+		reason = demoFailureParse
+	}
+
+	switch code {
+	case demoReasonExpired:
+		return demoFailureExpired, false
+	case demoReasonTransient:
+		return reason, true
+	case demoReasonDownloadFailed:
+		return demoFailureDownload, false
+	case demoReasonParseFailed:
+		return demoFailureParse, false
+	}
+
+	message = strings.ToLower(message)
+	for _, hint := range demoTransientHints {
+		if strings.Contains(message, hint) {
+			return reason, true
+		}
+	}
+	for _, hint := range demoExpiredHints {
+		if strings.Contains(message, hint) {
+			return demoFailureExpired, false
+		}
+	}
+	return reason, stage != webhooks.StageDemoParsed
+}
+
+// matchJobGuilds returns the guilds of the registered players whose polling found a job
+func matchJobGuilds(job *MatchJob) map[string]*Guild {
+	guilds := make(map[string]*Guild)
+	for _, steamID := range job.SteamIDs {
+		user, err := getUserBySteamID(steamID)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Warning: failed to look up user %s: %v", steamID, err)
+			}
+			continue
+		}
+
+		userGuilds, err := getGuildsForUser(user.UUID)
+		if err != nil {
+			log.Printf("Warning: failed to get guilds for user %s: %v", steamID, err)
+			continue
+		}
+		for _, guild := range userGuilds {
+			guilds[guild.GuildID] = guild
+		}
+	}
+	return guilds
+}
+
+// notifyDemoUnavailable tells the guilds of a job that its match won't get a summary
+func notifyDemoUnavailable(job *MatchJob, reason string) error {
+	if webhookCtx == nil || webhookCtx.DiscordSession == nil {
+		return fmt.Errorf("discord session not available")
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Description: fmt.Sprintf("📼 Demo unavailable (%s) for `%s`, no summary will be posted.", reason, job.ShareCode),
+		Color:       0x808080,
+	}
+
+	for _, guild := range matchJobGuilds(job) {
+		if guild.ChannelID == "" {
+			continue
+		}
+		_, err := webhookCtx.DiscordSession.ChannelMessageSendEmbed(guild.ChannelID, embed)
+		if err != nil {
+			log.Printf("Error notifying guild %s about unavailable demo: %v", guild.GuildID, err)
+		}
+	}
+	return nil
+}
+
+// MatchJobReconciler periodically retries match jobs that failed or got stuck in a stage
+type MatchJobReconciler struct {
+	poller    *SteamPoller
+	stopChan  chan bool
+	isRunning bool
+	mutex     sync.Mutex
+}
+
+// NewMatchJobReconciler creates a reconciler that requests demos through poller
+func NewMatchJobReconciler(poller *SteamPoller) *MatchJobReconciler {
+	return &MatchJobReconciler{
+		poller:   poller,
+		stopChan: make(chan bool),
+	}
+}
+
+// Start reconciles due jobs every minute until Stop is called
+func (r *MatchJobReconciler) Start() {
+	r.mutex.Lock()
+	if r.isRunning {
+		r.mutex.Unlock()
+		return
+	}
+	r.isRunning = true
+	r.mutex.Unlock()
+
+	log.Println("Starting match job reconciler...")
+
+	// Cancelled on Stop, also while a batch is being reconciled, so in-flight demo service
+	// calls don't hold up shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-r.stopChan
+		cancel()
+	}()
+
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Match job reconciler stopped")
+			return
+		case <-ticker.C:
+			r.reconcile(ctx)
+		}
+	}
+}
+
+// Stop stops the reconciler
+func (r *MatchJobReconciler) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.isRunning {
+		return
+	}
+
+	r.isRunning = false
+	close(r.stopChan)
+}
+
+// reconcile claims due jobs in batches and runs the next step of each, giving every step
+// matchJobStepTimeout. Once ctx is cancelled the remaining jobs are left until their
+// lease runs out.
+func (r *MatchJobReconciler) reconcile(ctx context.Context) {
+	for {
+		jobs, err := claimDueMatchJobs(matchJobLease, reconcileBatchSize)
+		if err != nil {
+			log.Printf("Error claiming due match jobs: %v", err)
+			return
+		}
+
+		for _, job := range jobs {
+			if ctx.Err() != nil {
+				return
+			}
+			if job.Attempts > matchJobMaxAttempts {
+				recordMatchJobError(job.ShareCode, job.Attempts, fmt.Errorf("gave up in %s: %s", job.Status, job.LastError))
+				continue
+			}
+
+			log.Printf("Retrying match job %s in %s (attempt %d)", job.ShareCode, job.Status, job.Attempts)
+			stepCtx, cancel := context.WithTimeout(ctx, matchJobStepTimeout)
+			_, err := runMatchJob(stepCtx, r.poller, job)
+			cancel()
+			if err != nil {
+				recordMatchJobError(job.ShareCode, job.Attempts, err)
+			}
+		}
+
+		if len(jobs) < reconcileBatchSize {
+			return
+		}
+	}
+}
+
+// runMatchJob repeats the step that should have moved a job out of its stage and returns
+// the status the job moved to
+func runMatchJob(ctx context.Context, poller *SteamPoller, job *MatchJob) (string, error) {
+	var next string
+	switch matchJobStage(job) {
+	case MatchJobDiscovered, MatchJobDownloadRequested:
+		if err := poller.requestDemoDownload(ctx, job.ShareCode); err != nil {
+			return job.Status, fmt.Errorf("failed to request demo download: %w", err)
+		}
+		next = MatchJobDownloadRequested
+	case MatchJobDemoReady, MatchJobParseRequested:
+		if err := poller.requestDemoParsing(ctx, job.ShareCode); err != nil {
+			return job.Status, fmt.Errorf("failed to request demo parsing: %w", err)
+		}
+		next = MatchJobParseRequested
+	case MatchJobParsed:
+		if err := notifyParsedMatch(job.ShareCode); err != nil {
+			return job.Status, err
+		}
+		next = MatchJobNotified
+	default:
+		return job.Status, nil
+	}
+
+	if _, err := advanceMatchJobTo(job.ShareCode, next); err != nil {
+		return job.Status, err
+	}
+	return next, nil
+}
+
+// matchJobStage returns the stage whose step a retry repeats. Failed jobs resume at the
+// stage they failed in.
+func matchJobStage(job *MatchJob) string {
+	if job.Status != MatchJobFailed {
+		return job.Status
+	}
+	switch {
+	case job.ParsedAt != nil:
+		return MatchJobParsed
+	case job.DemoReadyAt != nil:
+		return MatchJobDemoReady
+	default:
+		return MatchJobDiscovered
+	}
+}
+
+// notifyParsedMatch sends the summaries of a match whose stats were stored earlier
+func notifyParsedMatch(shareCode string) error {
+	game, err := findGameByShareCode(shareCode)
+	if err != nil {
+		return fmt.Errorf("failed to get game: %w", err)
+	}
+
+	stats, err := getGameStats(game.UUID)
+	if err != nil {
+		return err
+	}
+	if stats == nil {
+		return errors.New("game has no stored stats")
+	}
+
+	guilds, err := linkMatchParticipants(game, stats.SteamIDs())
+	if err != nil {
+		return fmt.Errorf("failed to link match participants: %w", err)
+	}
+
+	return sendMatchSummaryToGuilds(guilds, game, stats)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"cs-match-summary-bot/steamapi"
+	"cs-match-summary-bot/webhooks"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestClassifyDemoFailure(t *testing.T) {
+	tests := []struct {
+		name          string
+		stage         string
+		code          string
+		message       string
+		wantReason    string
+		wantTransient bool
+	}{
+		// Reason codes decide regardless of the message
+		{"expired download", webhooks.StageDemoReady, demoReasonExpired, "Something went wrong", demoFailureExpired, false},
+		{"expired parse", webhooks.StageDemoParsed, demoReasonExpired, "", demoFailureExpired, false},
+		{"transient download", webhooks.StageDemoReady, demoReasonTransient, "Demo has expired", demoFailureDownload, true},
+		{"transient parse", webhooks.StageDemoParsed, demoReasonTransient, "Corrupt demo", demoFailureParse, true},
+		{"failed download", webhooks.StageDemoReady, demoReasonDownloadFailed, "Connection reset", demoFailureDownload, false},
+		{"failed parse", webhooks.StageDemoParsed, demoReasonParseFailed, "Parser busy", demoFailureParse, false},
+
+		// Without a known code the message is matched
+		{"expired download message", webhooks.StageDemoReady, "", "Demo has expired", demoFailureExpired, false},
+		{"missing demo message", webhooks.StageDemoParsed, "", "Demo file not found", demoFailureExpired, false},
+		{"parse error message", webhooks.StageDemoParsed, "", "Corrupt demo header", demoFailureParse, false},
+		{"transient download message", webhooks.StageDemoReady, "", "Valve CDN timed out", demoFailureDownload, true},
+		{"transient parse message", webhooks.StageDemoParsed, "", "Too many requests, try again later", demoFailureParse, true},
+		{"unknown download message", webhooks.StageDemoReady, "", "Something went wrong", demoFailureDownload, true},
+		{"unknown parse message", webhooks.StageDemoParsed, "", "Something went wrong", demoFailureParse, false},
+		{"unknown code", webhooks.StageDemoParsed, "quota", "Service unavailable", demoFailureParse, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, transient := classifyDemoFailure(tt.stage, tt.code, tt.message)
+			if reason != tt.wantReason || transient != tt.wantTransient {
+				t.Errorf("classifyDemoFailure(%q, %q, %q) = %q, %t, want %q, %t",
+					tt.stage, tt.code, tt.message, reason, transient, tt.wantReason, tt.wantTransient)
+			}
+		})
+	}
+}
+
+func TestSummaryRetryOnlyReachesGuildsThatMissedIt(t *testing.T) {
+	mock := mockDB(t)
+	discord := newFakeDiscord(t)
+
+	game := &Game{UUID: uuid.New(), ShareCode: testShareCode, MatchID: testMatchID, SteamIDs: StringSlice{}}
+	stats := testStats("76561198000000003", "76561198000000004")
+	delivered := &Guild{UUID: uuid.New(), GuildID: "guild-1", ChannelID: "channel-1"}
+	missed := &Guild{UUID: uuid.New(), GuildID: "guild-2", ChannelID: "channel-2"}
+	guilds := map[string]*Guild{delivered.GuildID: delivered, missed.GuildID: missed}
+
+	// The first attempt reaches one guild and fails for the other, so the job stays parsed
+	discord.failChannels = []string{missed.ChannelID}
+	expectMatchNotifications(mock, game)
+	expectNoSteamProfiles(mock)
+	expectNoSteamProfiles(mock)
+	expectRecordNotification(mock, game, delivered.GuildID)
+
+	err := sendMatchSummaryToGuilds(guilds, game, stats)
+	if err == nil || !strings.Contains(err.Error(), missed.GuildID) {
+		t.Fatalf("sendMatchSummaryToGuilds() error = %v, want the failed guild", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("first attempt: %v", err)
+	}
+
+	// The retry only sends to the guild that missed the summary
+	discord.failChannels = nil
+	expectMatchNotifications(mock, game, delivered.GuildID)
+	expectNoSteamProfiles(mock)
+	expectRecordNotification(mock, game, missed.GuildID)
+
+	if err := sendMatchSummaryToGuilds(guilds, game, stats); err != nil {
+		t.Fatalf("sendMatchSummaryToGuilds() retry error = %v", err)
+	}
+
+	messages := discord.sent()
+	if len(messages) != 2 || messages[0].ChannelID != delivered.ChannelID || messages[1].ChannelID != missed.ChannelID {
+		t.Errorf("sent %+v, want one summary per guild", messages)
+	}
+}
+
+// signallingDemos is a hangingDemos that reports every download request it receives
+type signallingDemos struct {
+	hangingDemos
+	requested chan string
+}
+
+// RequestDownload implements demoservice.Client
+func (d signallingDemos) RequestDownload(ctx context.Context, shareCode, webhookURL string) error {
+	d.requested <- shareCode
+	return d.hangingDemos.RequestDownload(ctx, shareCode, webhookURL)
+}
+
+func TestReconcileStopsWhenCancelled(t *testing.T) {
+	mock := mockDB(t)
+	t.Setenv("WEBHOOK_BASE_URL", "http://bot.test")
+
+	// Two discovered jobs are due, the reconciler is stopped while requesting the first demo
+	rows := sqlmock.NewRows([]string{"uuid", "share_code", "status", "steam_ids", "attempts", "last_error", "next_attempt_at",
+		"download_requested_at", "demo_ready_at", "parse_requested_at", "parsed_at", "notified_at", "failed_at",
+		"created_at", "updated_at"})
+	for _, shareCode := range nextShareCodes[:2] {
+		rows.AddRow(uuid.New().String(), shareCode, MatchJobDiscovered, []byte(`[]`), 1, "", time.Now(),
+			nil, nil, nil, nil, nil, nil, time.Now(), time.Now())
+	}
+	mock.ExpectQuery(`UPDATE match_jobs\s+SET attempts = attempts \+ 1`).WillReturnRows(rows)
+	mock.ExpectExec(`UPDATE match_jobs SET callback_nonce = \$2`).
+		WithArgs(nextShareCodes[0], sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE match_jobs\s+SET attempts = GREATEST\(attempts, \$2\)`).
+		WithArgs(nextShareCodes[0], 1, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	demos := signallingDemos{requested: make(chan string, 2)}
+	r := NewMatchJobReconciler(NewSteamPoller(steamapi.NewFake(), demos, testPollerConfig(1), testSigner))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.reconcile(ctx)
+	}()
+
+	if shareCode := receive(t, demos.requested); shareCode != nextShareCodes[0] {
+		t.Fatalf("requested %s first, want %s", shareCode, nextShareCodes[0])
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reconcile() kept running after it was cancelled")
+	}
+	if len(demos.requested) != 0 {
+		t.Errorf("reconcile() requested %s after it was cancelled", <-demos.requested)
+	}
+}
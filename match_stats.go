@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cs-match-summary-bot/steamid"
+)
+
+// Team keys used by players and rounds to reference a team
+const (
+	TeamA = "team_a"
+	TeamB = "team_b"
+)
+
+// Sides a team can play on
+const (
+	SideCT = "CT"
+	SideT  = "T"
+)
+
+// Round win reasons reported by the demo parser
+const (
+	RoundWinElimination = "elimination"
+	RoundWinBomb        = "bomb"
+	RoundWinDefuse      = "defuse"
+	RoundWinTime        = "time"
+	RoundWinSurrender   = "surrender"
+)
+
+// MatchStats is the parsed result of a demo as sent by the demo service
+type MatchStats struct {
+	Map     string        `json:"map"`
+	TeamA   TeamStats     `json:"team_a"`
+	TeamB   TeamStats     `json:"team_b"`
+	Players []PlayerStats `json:"players"`
+	Rounds  []RoundResult `json:"rounds"`
+}
+
+// TeamStats holds the final result of one team
+type TeamStats struct {
+	Name         string `json:"name"`
+	Score        int    `json:"score"`
+	StartingSide string `json:"starting_side"`
+}
+
+// PlayerStats holds the scoreboard row of a single player.
+// Optional stats are nil when the demo parser could not compute them.
+type PlayerStats struct {
+	SteamID       string   `json:"steam_id"`
+	Name          string   `json:"name"`
+	Team          string   `json:"team"`
+	Kills         int      `json:"kills"`
+	Deaths        int      `json:"deaths"`
+	Assists       int      `json:"assists"`
+	ADR           *float64 `json:"adr,omitempty"`
+	HeadshotPct   *float64 `json:"hs_pct,omitempty"`
+	KAST          *float64 `json:"kast,omitempty"`
+	Rating        *float64 `json:"rating,omitempty"`
+	MVPs          *int     `json:"mvps,omitempty"`
+	UtilityDamage *int     `json:"utility_damage,omitempty"`
+	EntryKills    *int     `json:"entry_kills,omitempty"`
+	Clutches      *int     `json:"clutches,omitempty"`
+}
+
+// RoundResult describes who won a round and how
+type RoundResult struct {
+	Number     int    `json:"number"`
+	WinnerTeam string `json:"winner_team"`
+	WinnerSide string `json:"winner_side"`
+	Reason     string `json:"reason"`
+}
+
+// ValidationError collects every problem found in a stats payload
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid match stats: " + strings.Join(e.Problems, "; ")
+}
+
+func (e *ValidationError) addf(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// Team returns the team stored under the given team key
+func (ms *MatchStats) Team(key string) *TeamStats {
+	switch key {
+	case TeamA:
+		return &ms.TeamA
+	case TeamB:
+		return &ms.TeamB
+	}
+	return nil
+}
+
+// Match results from a team's point of view
+const (
+	ResultWin  = "win"
+	ResultLoss = "loss"
+	ResultTie  = "tie"
+)
+
+// Result returns whether the team under the given key won, lost or tied the match
+func (ms *MatchStats) Result(key string) string {
+	own, other := ms.TeamA.Score, ms.TeamB.Score
+	if key == TeamB {
+		own, other = other, own
+	}
+
+	switch {
+	case own > other:
+		return ResultWin
+	case own < other:
+		return ResultLoss
+	}
+	return ResultTie
+}
+
+// SteamIDs returns the Steam IDs of every player in the match
+func (ms *MatchStats) SteamIDs() []string {
+	steamIDs := make([]string, 0, len(ms.Players))
+	for _, player := range ms.Players {
+		steamIDs = append(steamIDs, player.SteamID)
+	}
+	return steamIDs
+}
+
+// Validate checks that the stats are complete and internally consistent
+func (ms *MatchStats) Validate() error {
+	verr := &ValidationError{}
+
+	if strings.TrimSpace(ms.Map) == "" {
+		verr.addf("map: required")
+	}
+
+	for _, key := range []string{TeamA, TeamB} {
+		team := ms.Team(key)
+		if team.Score < 0 {
+			verr.addf("%s.score: must not be negative", key)
+		}
+		if !validSide(team.StartingSide) {
+			verr.addf("%s.starting_side: must be %q or %q", key, SideCT, SideT)
+		}
+	}
+	if validSide(ms.TeamA.StartingSide) && ms.TeamA.StartingSide == ms.TeamB.StartingSide {
+		verr.addf("team_b.starting_side: must differ from team_a.starting_side")
+	}
+
+	if len(ms.Players) == 0 {
+		verr.addf("players: at least one player is required")
+	}
+	seen := make(map[string]bool)
+	for i, player := range ms.Players {
+		field := fmt.Sprintf("players[%d]", i)
+		if !validSteamID64(player.SteamID) {
+			verr.addf("%s.steam_id: must be a SteamID64", field)
+		} else if seen[player.SteamID] {
+			verr.addf("%s.steam_id: duplicate player %s", field, player.SteamID)
+		}
+		seen[player.SteamID] = true
+		if ms.Team(player.Team) == nil {
+			verr.addf("%s.team: must be %q or %q", field, TeamA, TeamB)
+		}
+		if player.Kills < 0 || player.Deaths < 0 || player.Assists < 0 {
+			verr.addf("%s: kills, deaths and assists must not be negative", field)
+		}
+		if player.ADR != nil && *player.ADR < 0 {
+			verr.addf("%s.adr: must not be negative", field)
+		}
+		if player.Rating != nil && *player.Rating < 0 {
+			verr.addf("%s.rating: must not be negative", field)
+		}
+		if player.HeadshotPct != nil && (*player.HeadshotPct < 0 || *player.HeadshotPct > 100) {
+			verr.addf("%s.hs_pct: must be between 0 and 100", field)
+		}
+		if player.KAST != nil && (*player.KAST < 0 || *player.KAST > 100) {
+			verr.addf("%s.kast: must be between 0 and 100", field)
+		}
+		counters := []struct {
+			name  string
+			value *int
+		}{
+			{"mvps", player.MVPs},
+			{"utility_damage", player.UtilityDamage},
+			{"entry_kills", player.EntryKills},
+			{"clutches", player.Clutches},
+		}
+		for _, counter := range counters {
+			if counter.value != nil && *counter.value < 0 {
+				verr.addf("%s.%s: must not be negative", field, counter.name)
+			}
+		}
+	}
+
+	wins := map[string]int{}
+	for i, round := range ms.Rounds {
+		field := fmt.Sprintf("rounds[%d]", i)
+		if round.Number != i+1 {
+			verr.addf("%s.number: expected %d, got %d", field, i+1, round.Number)
+		}
+		if ms.Team(round.WinnerTeam) == nil {
+			verr.addf("%s.winner_team: must be %q or %q", field, TeamA, TeamB)
+		} else {
+			wins[round.WinnerTeam]++
+		}
+		if !validSide(round.WinnerSide) {
+			verr.addf("%s.winner_side: must be %q or %q", field, SideCT, SideT)
+		}
+		if !validRoundWinReason(round.Reason) {
+			verr.addf("%s.reason: unknown win reason %q", field, round.Reason)
+		}
+	}
+	if len(ms.Rounds) > 0 && (wins[TeamA] != ms.TeamA.Score || wins[TeamB] != ms.TeamB.Score) {
+		verr.addf("rounds: won rounds %d-%d do not match final score %d-%d",
+			wins[TeamA], wins[TeamB], ms.TeamA.Score, ms.TeamB.Score)
+	}
+
+	if len(verr.Problems) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// decodeMatchStats strictly decodes a stats object, rejecting unknown fields
+func decodeMatchStats(raw json.RawMessage) (*MatchStats, error) {
+	if len(bytes.TrimSpace(raw)) == 0 || bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return nil, &ValidationError{Problems: []string{"stats: required"}}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+
+	stats := &MatchStats{}
+	if err := decoder.Decode(stats); err != nil {
+		return nil, &ValidationError{Problems: []string{fmt.Sprintf("stats: %v", err)}}
+	}
+	if err := stats.Validate(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Value implements driver.Valuer so stats can be stored as JSONB
+func (ms *MatchStats) Value() (driver.Value, error) {
+	if ms == nil {
+		return nil, nil
+	}
+	return json.Marshal(ms)
+}
+
+// Scan implements sql.Scanner so stats can be read from JSONB
+func (ms *MatchStats) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	data, ok := value.([]byte)
+	if !ok {
+		return errors.New("cannot scan non-[]byte value into MatchStats")
+	}
+
+	return json.Unmarshal(data, ms)
+}
+
+func validSide(side string) bool {
+	return side == SideCT || side == SideT
+}
+
+func validRoundWinReason(reason string) bool {
+	switch reason {
+	case RoundWinElimination, RoundWinBomb, RoundWinDefuse, RoundWinTime, RoundWinSurrender:
+		return true
+	}
+	return false
+}
+
+// validSteamID64 reports whether id is the canonical SteamID64 of an individual account
+func validSteamID64(id string) bool {
+	parsed, err := steamid.Parse(id)
+	return err == nil && parsed.String() == id
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cs-match-summary-bot/webhooks"
+)
+
+// validStatsJSON is a two round match that passes validation
+const validStatsJSON = `{
+	"map": "de_inferno",
+	"team_a": {"name": "Team A", "score": 2, "starting_side": "CT"},
+	"team_b": {"name": "Team B", "score": 0, "starting_side": "T"},
+	"players": [
+		{"steam_id": "76561198000000001", "name": "a", "team": "team_a", "kills": 5, "deaths": 1, "assists": 0, "adr": 90.5, "hs_pct": 40},
+		{"steam_id": "76561198000000002", "name": "b", "team": "team_b", "kills": 1, "deaths": 5, "assists": 1}
+	],
+	"rounds": [
+		{"number": 1, "winner_team": "team_a", "winner_side": "CT", "reason": "elimination"},
+		{"number": 2, "winner_team": "team_a", "winner_side": "CT", "reason": "defuse"}
+	]
+}`
+
+// statsWith decodes validStatsJSON, applies change and encodes the result again
+func statsWith(t *testing.T, change func(map[string]interface{})) json.RawMessage {
+	t.Helper()
+	var stats map[string]interface{}
+	if err := json.Unmarshal([]byte(validStatsJSON), &stats); err != nil {
+		t.Fatalf("failed to decode valid stats: %v", err)
+	}
+	change(stats)
+	return jsonb(t, stats)
+}
+
+// player returns the nth player of a stats object decoded by statsWith
+func player(stats map[string]interface{}, n int) map[string]interface{} {
+	return stats["players"].([]interface{})[n].(map[string]interface{})
+}
+
+func TestDecodeMatchStats(t *testing.T) {
+	stats, err := decodeMatchStats(json.RawMessage(validStatsJSON))
+	if err != nil {
+		t.Fatalf("decodeMatchStats() error = %v", err)
+	}
+	if stats.Map != "de_inferno" || len(stats.Players) != 2 || len(stats.Rounds) != 2 {
+		t.Errorf("decodeMatchStats() = %+v", stats)
+	}
+	if stats.Players[0].ADR == nil || *stats.Players[0].ADR != 90.5 || stats.Players[1].ADR != nil {
+		t.Errorf("optional stats = %v, %v, want 90.5 and nil", stats.Players[0].ADR, stats.Players[1].ADR)
+	}
+}
+
+func TestDecodeMatchStatsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  json.RawMessage
+		want string
+	}{
+		{"missing", nil, "stats: required"},
+		{"null", json.RawMessage(`null`), "stats: required"},
+		{"not an object", json.RawMessage(`[]`), "stats: json: cannot unmarshal array"},
+		{"unknown field", statsWith(t, func(s map[string]interface{}) { s["winner"] = "team_a" }), `unknown field "winner"`},
+		{"unknown player field", statsWith(t, func(s map[string]interface{}) { player(s, 0)["kd"] = 5 }), `unknown field "kd"`},
+		{"missing map", statsWith(t, func(s map[string]interface{}) { delete(s, "map") }), "map: required"},
+		{"missing team", statsWith(t, func(s map[string]interface{}) { delete(s, "team_b") }), `team_b.starting_side: must be "CT" or "T"`},
+		{"bad starting side", statsWith(t, func(s map[string]interface{}) {
+			s["team_a"].(map[string]interface{})["starting_side"] = "ct"
+		}), `team_a.starting_side: must be "CT" or "T"`},
+		{"same starting side", statsWith(t, func(s map[string]interface{}) {
+			s["team_b"].(map[string]interface{})["starting_side"] = "CT"
+		}), "team_b.starting_side: must differ from team_a.starting_side"},
+		{"negative score", statsWith(t, func(s map[string]interface{}) {
+			s["team_b"].(map[string]interface{})["score"] = -1
+		}), "team_b.score: must not be negative"},
+		{"no players", statsWith(t, func(s map[string]interface{}) { s["players"] = []interface{}{} }), "players: at least one player is required"},
+		{"missing player team", statsWith(t, func(s map[string]interface{}) { delete(player(s, 1), "team") }), `players[1].team: must be "team_a" or "team_b"`},
+		{"unknown player team", statsWith(t, func(s map[string]interface{}) { player(s, 1)["team"] = "team_c" }), `players[1].team: must be "team_a" or "team_b"`},
+		{"invalid steam id", statsWith(t, func(s map[string]interface{}) { player(s, 0)["steam_id"] = "STEAM_0:1:1" }), "players[0].steam_id: must be a SteamID64"},
+		{"duplicate steam id", statsWith(t, func(s map[string]interface{}) { player(s, 1)["steam_id"] = "76561198000000001" }), "players[1].steam_id: duplicate player 76561198000000001"},
+		{"negative kills", statsWith(t, func(s map[string]interface{}) { player(s, 0)["kills"] = -1 }), "players[0]: kills, deaths and assists must not be negative"},
+		{"negative adr", statsWith(t, func(s map[string]interface{}) { player(s, 0)["adr"] = -3 }), "players[0].adr: must not be negative"},
+		{"headshots above 100", statsWith(t, func(s map[string]interface{}) { player(s, 0)["hs_pct"] = 101 }), "players[0].hs_pct: must be between 0 and 100"},
+		{"negative mvps", statsWith(t, func(s map[string]interface{}) { player(s, 1)["mvps"] = -1 }), "players[1].mvps: must not be negative"},
+		{"round numbering", statsWith(t, func(s map[string]interface{}) {
+			s["rounds"].([]interface{})[1].(map[string]interface{})["number"] = 3
+		}), "rounds[1].number: expected 2, got 3"},
+		{"bad round side", statsWith(t, func(s map[string]interface{}) {
+			s["rounds"].([]interface{})[0].(map[string]interface{})["winner_side"] = "X"
+		}), `rounds[0].winner_side: must be "CT" or "T"`},
+		{"unknown win reason", statsWith(t, func(s map[string]interface{}) {
+			s["rounds"].([]interface{})[0].(map[string]interface{})["reason"] = "forfeit"
+		}), `rounds[0].reason: unknown win reason "forfeit"`},
+		{"rounds not matching score", statsWith(t, func(s map[string]interface{}) {
+			s["rounds"] = s["rounds"].([]interface{})[:1]
+		}), "rounds: won rounds 1-0 do not match final score 2-0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeMatchStats(tt.raw)
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("decodeMatchStats() error = %v, want a ValidationError", err)
+			}
+			found := false
+			for _, problem := range verr.Problems {
+				if strings.Contains(problem, tt.want) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("problems = %q, want one containing %q", verr.Problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	stats := testStats("76561198000000001", "76561198000000001")
+	stats.Map = ""
+	stats.Players[1].Deaths = -1
+
+	var verr *ValidationError
+	if err := stats.Validate(); !errors.As(err, &verr) {
+		t.Fatalf("Validate() error = %v, want a ValidationError", err)
+	}
+	if len(verr.Problems) != 3 {
+		t.Errorf("problems = %q, want the map, the duplicate player and the negative deaths", verr.Problems)
+	}
+}
+
+func TestHandleDemoParsedRejectsInvalidStats(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats json.RawMessage
+		want  string
+	}{
+		{"unknown field", statsWith(t, func(s map[string]interface{}) { s["winner"] = "team_a" }), `unknown field "winner"`},
+		{"duplicate player", statsWith(t, func(s map[string]interface{}) { player(s, 1)["steam_id"] = "76561198000000001" }), "players[1].steam_id: duplicate player 76561198000000001"},
+		{"negative stats", statsWith(t, func(s map[string]interface{}) { player(s, 0)["assists"] = -2 }), "players[0]: kills, deaths and assists must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := mockDB(t)
+			newFakeDiscord(t)
+
+			// The rejected payload gives its callback URL back for a corrected retry
+			expectValidNonce(mock, testShareCode, testNonce)
+			expectRestoreNonce(mock, testShareCode, testNonce)
+
+			var payload DemoParsedPayload
+			payload.Success = true
+			payload.Data.ShareCode = testShareCode
+			payload.Data.Stats = tt.stats
+			w := postCallback(t, newWebhookRouter(), webhooks.StageDemoParsed, testShareCode, testNonce, payload)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400, body %s", w.Code, w.Body)
+			}
+
+			var body struct {
+				Error   string   `json:"error"`
+				Details []string `json:"details"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("failed to decode body %s: %v", w.Body, err)
+			}
+			if body.Error != "Invalid stats payload" || len(body.Details) != 1 || !strings.Contains(body.Details[0], tt.want) {
+				t.Errorf("body = %+v, want the details to contain %q", body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Discord embed limits
+const (
+	embedFieldValueLimit = 1024
+	embedTitleLimit      = 256
+)
+
+const (
+	scoreboardNameWidth = 14
+	registeredMarker    = "▶"
+	missingStat         = "–"
+)
+
+// buildMatchSummaryEmbed builds the post-match summary embed for a guild.
+// Players whose Steam ID is in registered are highlighted on the scoreboards.
+func buildMatchSummaryEmbed(game *Game, stats *MatchStats, registered map[string]bool) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title: "🎯 CS Match Summary",
+		Color: 0x00ff00,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "Match analysis completed",
+		},
+	}
+
+	if stats == nil {
+		embed.Fields = []*discordgo.MessageEmbedField{
+			{
+				Name:   "Share Code",
+				Value:  fmt.Sprintf("`%s`", game.ShareCode),
+				Inline: true,
+			},
+			{
+				Name:   "Players",
+				Value:  fmt.Sprintf("%d players", len(game.SteamIDs)),
+				Inline: true,
+			},
+		}
+		return embed
+	}
+
+	embed.Title = truncate(fmt.Sprintf("🎯 %s — %d : %d", displayMapName(stats.Map), stats.TeamA.Score, stats.TeamB.Score), embedTitleLimit)
+	embed.Description = fmt.Sprintf("**%s** %d - %d **%s**",
+		teamDisplayName(stats, TeamA), stats.TeamA.Score, stats.TeamB.Score, teamDisplayName(stats, TeamB))
+	embed.Color = summaryColor(stats, registered)
+
+	for _, key := range []string{TeamA, TeamB} {
+		team := stats.Team(key)
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  truncate(fmt.Sprintf("%s (%s start) — %d", teamDisplayName(stats, key), team.StartingSide, team.Score), embedTitleLimit),
+			Value: formatScoreboard(teamPlayers(stats, key), registered),
+		})
+	}
+
+	if timeline := formatRoundTimeline(stats.Rounds, stats.TeamA.StartingSide); timeline != "" {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Round Timeline",
+			Value: fitTimeline(timeline, embedFieldValueLimit-utf8.RuneCountInString(roundTimelineLegend)-1) + "\n" + roundTimelineLegend,
+		})
+	}
+
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+		Name:   "Share Code",
+		Value:  fmt.Sprintf("`%s`", game.ShareCode),
+		Inline: true,
+	})
+	if len(registered) > 0 {
+		embed.Footer.Text = fmt.Sprintf("%s registered in this server • Match analysis completed", registeredMarker)
+	}
+
+	return embed
+}
+
+// formatScoreboard renders a team's players as a fixed-width table that fits in one embed field
+func formatScoreboard(players []PlayerStats, registered map[string]bool) string {
+	if len(players) == 0 {
+		return "No players"
+	}
+
+	header := fmt.Sprintf("  %-*s %8s %5s %4s %5s", scoreboardNameWidth, "Player", "K/D/A", "ADR", "HS%", "Rtg")
+	lines := []string{header}
+	for _, player := range players {
+		marker := " "
+		if registered[player.SteamID] {
+			marker = registeredMarker
+		}
+		name := player.Name
+		if name == "" {
+			name = player.SteamID
+		}
+		lines = append(lines, fmt.Sprintf("%s %-*s %8s %5s %4s %5s",
+			marker,
+			scoreboardNameWidth, truncate(name, scoreboardNameWidth),
+			fmt.Sprintf("%d/%d/%d", player.Kills, player.Deaths, player.Assists),
+			formatOptionalFloat(player.ADR, "%.0f"),
+			formatOptionalFloat(player.HeadshotPct, "%.0f"),
+			formatOptionalFloat(player.Rating, "%.2f"),
+		))
+	}
+
+	// Drop rows until the code block fits in a single field
+	const fence = "```\n%s\n```"
+	for omitted := 0; ; omitted++ {
+		body := strings.Join(lines, "\n")
+		if omitted > 0 {
+			body += fmt.Sprintf("\n… and %d more", omitted)
+		}
+		value := fmt.Sprintf(fence, body)
+		if utf8.RuneCountInString(value) <= embedFieldValueLimit || len(lines) <= 1 {
+			return value
+		}
+		lines = lines[:len(lines)-1]
+	}
+}
+
+// teamPlayers returns the players of a team ordered by rating when every player has one, otherwise by kills
+func teamPlayers(stats *MatchStats, key string) []PlayerStats {
+	var players []PlayerStats
+	for _, player := range stats.Players {
+		if player.Team == key {
+			players = append(players, player)
+		}
+	}
+
+	rated := true
+	for _, player := range players {
+		rated = rated && player.Rating != nil
+	}
+
+	sort.SliceStable(players, func(i, j int) bool {
+		a, b := players[i], players[j]
+		if rated && *a.Rating != *b.Rating {
+			return *a.Rating > *b.Rating
+		}
+		if a.Kills != b.Kills {
+			return a.Kills > b.Kills
+		}
+		return a.Deaths < b.Deaths
+	})
+	return players
+}
+
+// summaryColor colors the embed by how the guild's registered players did
+func summaryColor(stats *MatchStats, registered map[string]bool) int {
+	results := make(map[string]bool)
+	for _, player := range stats.Players {
+		if registered[player.SteamID] {
+			results[stats.Result(player.Team)] = true
+		}
+	}
+
+	switch {
+	case results[ResultWin] && !results[ResultLoss]:
+		return 0x00ff00
+	case results[ResultLoss] && !results[ResultWin]:
+		return 0xff0000
+	}
+	return 0x999999
+}
+
+func teamDisplayName(stats *MatchStats, key string) string {
+	if name := strings.TrimSpace(stats.Team(key).Name); name != "" {
+		return name
+	}
+	if key == TeamA {
+		return "Team A"
+	}
+	return "Team B"
+}
+
+// displayMapName turns "de_mirage" into "Mirage"
+func displayMapName(mapName string) string {
+	name := mapName
+	if i := strings.Index(name, "_"); i >= 0 && i < len(name)-1 {
+		name = name[i+1:]
+	}
+	if name == "" {
+		return mapName
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func formatOptionalFloat(value *float64, format string) string {
+	if value == nil {
+		return missingStat
+	}
+	return fmt.Sprintf(format, *value)
+}
+
+// truncate shortens s to at most limit runes, marking the cut with an ellipsis
+func truncate(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:limit-1]) + "…"
+}
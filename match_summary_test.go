@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// tenPlayerStats is a 13-3 match with five players per team and no optional stats
+func tenPlayerStats() *MatchStats {
+	stats := &MatchStats{
+		Map:   "de_mirage",
+		TeamA: TeamStats{Name: "Team A", Score: 13, StartingSide: SideCT},
+		TeamB: TeamStats{Name: "Team B", Score: 3, StartingSide: SideT},
+	}
+	for n := range 10 {
+		team := TeamA
+		if n >= 5 {
+			team = TeamB
+		}
+		stats.Players = append(stats.Players, PlayerStats{
+			SteamID: fmt.Sprintf("765611980000000%02d", n+1),
+			Name:    fmt.Sprintf("player%d", n+1),
+			Team:    team,
+			Kills:   20 - n,
+			Deaths:  10,
+			Assists: n,
+		})
+	}
+	return stats
+}
+
+// scoreboardRows returns the player rows of a formatted scoreboard without its fence and header
+func scoreboardRows(t *testing.T, value string) []string {
+	t.Helper()
+	lines := strings.Split(value, "\n")
+	if len(lines) < 3 || lines[0] != "```" || lines[len(lines)-1] != "```" {
+		t.Fatalf("scoreboard is not a code block: %q", value)
+	}
+	return lines[2 : len(lines)-1]
+}
+
+func TestFormatScoreboardMissingStats(t *testing.T) {
+	players := teamPlayers(tenPlayerStats(), TeamA)
+	rows := scoreboardRows(t, formatScoreboard(players, nil))
+
+	if len(rows) != 5 {
+		t.Fatalf("got %d rows, want 5: %q", len(rows), rows)
+	}
+	for _, row := range rows {
+		// ADR, HS% and rating are unknown
+		if strings.Count(row, missingStat) != 3 {
+			t.Errorf("row %q does not show the three missing stats as %s", row, missingStat)
+		}
+	}
+	if !strings.Contains(rows[0], "player1") || !strings.Contains(rows[0], "20/10/0") {
+		t.Errorf("first row = %q, want the top fragger", rows[0])
+	}
+}
+
+func TestFormatScoreboardHighlightsRegistered(t *testing.T) {
+	stats := tenPlayerStats()
+	rating := 1.5
+	stats.Players[2].Rating = &rating
+	registered := map[string]bool{stats.Players[2].SteamID: true}
+
+	rows := scoreboardRows(t, formatScoreboard(teamPlayers(stats, TeamA), registered))
+	for _, row := range rows {
+		highlighted := strings.HasPrefix(row, registeredMarker)
+		if highlighted != strings.Contains(row, "player3 ") {
+			t.Errorf("row %q highlighted = %t", row, highlighted)
+		}
+		if strings.Contains(row, "player3 ") && !strings.HasSuffix(row, "1.50") {
+			t.Errorf("row %q does not show the rating", row)
+		}
+	}
+}
+
+func TestFormatScoreboardShortensLongNames(t *testing.T) {
+	players := []PlayerStats{
+		{SteamID: "76561198000000001", Name: strings.Repeat("ЖuperLongName", 10), Team: TeamA},
+		{SteamID: "76561198000000002", Team: TeamA},
+	}
+	rows := scoreboardRows(t, formatScoreboard(players, nil))
+
+	if !strings.Contains(rows[0], "ЖuperLongName…") || strings.Contains(rows[0], "ЖuperLongNameЖ") {
+		t.Errorf("row %q does not shorten the name to %d runes", rows[0], scoreboardNameWidth)
+	}
+	if !strings.Contains(rows[1], "7656119800000…") {
+		t.Errorf("row %q does not fall back to the Steam ID", rows[1])
+	}
+	if utf8.RuneCountInString(rows[0]) != utf8.RuneCountInString(rows[1]) {
+		t.Errorf("rows are not aligned: %q, %q", rows[0], rows[1])
+	}
+}
+
+func TestFormatScoreboardDropsRowsToFit(t *testing.T) {
+	var players []PlayerStats
+	for n := range 40 {
+		players = append(players, PlayerStats{
+			SteamID: fmt.Sprintf("765611980000000%02d", n+1),
+			Name:    strings.Repeat("n", 40),
+			Team:    TeamA,
+			Kills:   100 - n,
+			Deaths:  100,
+			Assists: 100,
+		})
+	}
+
+	value := formatScoreboard(players, nil)
+	if count := utf8.RuneCountInString(value); count > embedFieldValueLimit {
+		t.Fatalf("scoreboard is %d runes, over the %d limit", count, embedFieldValueLimit)
+	}
+
+	rows := scoreboardRows(t, value)
+	note := rows[len(rows)-1]
+	shown := len(rows) - 1
+	if want := fmt.Sprintf("… and %d more", len(players)-shown); note != want {
+		t.Errorf("last line = %q, want %q", note, want)
+	}
+	if !strings.Contains(rows[0], "100/100/100") {
+		t.Errorf("first row = %q, want the first player kept", rows[0])
+	}
+}
+
+func TestBuildMatchSummaryEmbed(t *testing.T) {
+	stats := tenPlayerStats()
+	for n := range 16 {
+		winner, side := TeamA, SideCT
+		if n >= 13 {
+			winner, side = TeamB, SideT
+		}
+		stats.Rounds = append(stats.Rounds, RoundResult{Number: n + 1, WinnerTeam: winner, WinnerSide: side, Reason: RoundWinElimination})
+	}
+	stats.TeamA.Name = strings.Repeat("A very long team name ", 20)
+	registered := map[string]bool{stats.Players[7].SteamID: true}
+	game := &Game{ShareCode: testShareCode}
+
+	embed := buildMatchSummaryEmbed(game, stats, registered)
+
+	if embed.Title != "🎯 Mirage — 13 : 3" {
+		t.Errorf("title = %q", embed.Title)
+	}
+	if embed.Color != 0xff0000 {
+		t.Errorf("color = %#x, want red for the registered player's loss", embed.Color)
+	}
+	if !strings.HasPrefix(embed.Footer.Text, registeredMarker) {
+		t.Errorf("footer = %q, want the highlight explained", embed.Footer.Text)
+	}
+	if len(embed.Fields) != 4 {
+		t.Fatalf("got %d fields, want two scoreboards, the timeline and the share code", len(embed.Fields))
+	}
+	for _, field := range embed.Fields {
+		if utf8.RuneCountInString(field.Name) > embedTitleLimit {
+			t.Errorf("field name is %d runes: %q", utf8.RuneCountInString(field.Name), field.Name)
+		}
+		if utf8.RuneCountInString(field.Value) > embedFieldValueLimit {
+			t.Errorf("field %q is %d runes", field.Name, utf8.RuneCountInString(field.Value))
+		}
+	}
+	if !strings.Contains(embed.Fields[1].Value, registeredMarker+" player8") {
+		t.Errorf("team B scoreboard does not highlight the registered player: %s", embed.Fields[1].Value)
+	}
+	if strings.Contains(embed.Fields[0].Value, registeredMarker) {
+		t.Errorf("team A scoreboard highlights an unregistered player: %s", embed.Fields[0].Value)
+	}
+}
+
+func TestBuildMatchSummaryEmbedWithoutStats(t *testing.T) {
+	game := &Game{ShareCode: testShareCode, SteamIDs: StringSlice{"76561198000000001", "76561198000000002"}}
+
+	embed := buildMatchSummaryEmbed(game, nil, nil)
+	if len(embed.Fields) != 2 || embed.Fields[1].Value != "2 players" {
+		t.Errorf("fields = %+v, want the share code and the player count", embed.Fields)
+	}
+}
@@ -51,6 +51,7 @@ type User struct {
 	AuthCode      string      `json:"auth_code" db:"auth_code"`
 	LastShareCode string      `json:"last_share_code" db:"last_share_code"`
 	GameIDs       StringSlice `json:"game_ids" db:"game_ids"`
+	DiscordUserID string      `json:"discord_user_id" db:"discord_user_id"`
 	CreatedAt     time.Time   `json:"created_at" db:"created_at"`
 	UpdatedAt     time.Time   `json:"updated_at" db:"updated_at"`
 }
@@ -59,12 +60,169 @@ type User struct {
 type Game struct {
 	UUID      uuid.UUID   `json:"uuid" db:"uuid"`
 	ShareCode string      `json:"share_code" db:"share_code"`
+	MatchID   uint64      `json:"match_id" db:"match_id"`
+	OutcomeID uint64      `json:"outcome_id" db:"outcome_id"`
+	Token     uint16      `json:"token" db:"token"`
 	DemoName  string      `json:"demo_name" db:"demo_name"`
 	SteamIDs  StringSlice `json:"steam_ids" db:"steam_ids"`
 	CreatedAt time.Time   `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time   `json:"updated_at" db:"updated_at"`
 }
 
+// GameListing is a game together with its parsed result, used for match history listings
+type GameListing struct {
+	Game
+	Map        string `json:"map"`
+	TeamAScore int    `json:"team_a_score"`
+	TeamBScore int    `json:"team_b_score"`
+	Parsed     bool   `json:"parsed"`
+}
+
+// SteamProfile is a cached Steam Web API player summary
+type SteamProfile struct {
+	SteamID     string    `json:"steam_id" db:"steam_id"`
+	PersonaName string    `json:"persona_name" db:"persona_name"`
+	AvatarURL   string    `json:"avatar_url" db:"avatar_url"`
+	ProfileURL  string    `json:"profile_url" db:"profile_url"`
+	FetchedAt   time.Time `json:"fetched_at" db:"fetched_at"`
+}
+
+// Roles under which a user can be removed from a guild
+const (
+	RemovalRoleOwner = "owner"
+	RemovalRoleAdmin = "admin"
+)
+
+// Statuses of a match job, in pipeline order. Notified and failed jobs are final.
+const (
+	// MatchJobDiscovered jobs were found by the poller but their demo wasn't requested yet
+	MatchJobDiscovered = "discovered"
+	// MatchJobDownloadRequested jobs were accepted by the demo service for download
+	MatchJobDownloadRequested = "download_requested"
+	// MatchJobDemoReady jobs have a downloaded demo that wasn't sent for parsing yet
+	MatchJobDemoReady = "demo_ready"
+	// MatchJobParseRequested jobs were accepted by the demo service for parsing
+	MatchJobParseRequested = "parse_requested"
+	// MatchJobParsed jobs have stored stats but summaries weren't sent yet
+	MatchJobParsed = "parsed"
+	// MatchJobNotified jobs had their summaries sent
+	MatchJobNotified = "notified"
+	// MatchJobFailed jobs ran out of attempts
+	MatchJobFailed = "failed"
+)
+
+// MatchJob tracks a share code through the demo pipeline. Attempts counts the tries at
+// the current stage and NextAttemptAt is when the reconciler acts on the job next.
+type MatchJob struct {
+	UUID                uuid.UUID   `json:"uuid" db:"uuid"`
+	ShareCode           string      `json:"share_code" db:"share_code"`
+	Status              string      `json:"status" db:"status"`
+	SteamIDs            StringSlice `json:"steam_ids" db:"steam_ids"`
+	Attempts            int         `json:"attempts" db:"attempts"`
+	LastError           string      `json:"last_error" db:"last_error"`
+	NextAttemptAt       *time.Time  `json:"next_attempt_at" db:"next_attempt_at"`
+	DownloadRequestedAt *time.Time  `json:"download_requested_at" db:"download_requested_at"`
+	DemoReadyAt         *time.Time  `json:"demo_ready_at" db:"demo_ready_at"`
+	ParseRequestedAt    *time.Time  `json:"parse_requested_at" db:"parse_requested_at"`
+	ParsedAt            *time.Time  `json:"parsed_at" db:"parsed_at"`
+	NotifiedAt          *time.Time  `json:"notified_at" db:"notified_at"`
+	FailedAt            *time.Time  `json:"failed_at" db:"failed_at"`
+	CreatedAt           time.Time   `json:"created_at" db:"created_at"`
+	UpdatedAt           time.Time   `json:"updated_at" db:"updated_at"`
+}
+
+// Poll statuses of a user
+const (
+	// PollStatusActive users are polled every cycle
+	PollStatusActive = "active"
+	// PollStatusBackoff users failed and are polled again at NextPollAt
+	PollStatusBackoff = "backoff"
+	// PollStatusInvalidAuthCode users are not polled until they register a new auth code
+	PollStatusInvalidAuthCode = "invalid_auth_code"
+)
+
+// UserPollState tracks how polling Steam for a user went
+type UserPollState struct {
+	UserUUID            uuid.UUID  `json:"user_uuid" db:"user_uuid"`
+	Status              string     `json:"status" db:"status"`
+	ConsecutiveFailures int        `json:"consecutive_failures" db:"consecutive_failures"`
+	LastSuccessAt       *time.Time `json:"last_success_at" db:"last_success_at"`
+	LastError           string     `json:"last_error" db:"last_error"`
+	NextPollAt          *time.Time `json:"next_poll_at" db:"next_poll_at"`
+	NotifiedAt          *time.Time `json:"notified_at" db:"notified_at"`
+	UpdatedAt           time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// MatchFilter narrows down a guild's match history. Zero values disable a filter.
+type MatchFilter struct {
+	SteamID       string
+	DiscordUserID string
+	Map           string
+	Result        string
+	// From and To bound when a match was recorded, not when it was played: parsed stats
+	// carry no match time
+	From time.Time
+	To   time.Time
+}
+
+// PlayerAggregate holds a player's totals and averages over a set of parsed matches.
+// Averages are nil when none of the matches carried that stat.
+type PlayerAggregate struct {
+	Matches     int      `json:"matches"`
+	Wins        int      `json:"wins"`
+	Losses      int      `json:"losses"`
+	Ties        int      `json:"ties"`
+	Kills       int      `json:"kills"`
+	Deaths      int      `json:"deaths"`
+	Assists     int      `json:"assists"`
+	ADR         *float64 `json:"adr"`
+	HeadshotPct *float64 `json:"hs_pct"`
+	Rating      *float64 `json:"rating"`
+}
+
+// PlayerProfile holds lifetime and recent-form aggregates for a player
+type PlayerProfile struct {
+	SteamID        string          `json:"steam_id"`
+	Name           string          `json:"name"`
+	Lifetime       PlayerAggregate `json:"lifetime"`
+	Recent         PlayerAggregate `json:"recent"`
+	RecentResults  []string        `json:"recent_results"`
+	BestMap        string          `json:"best_map"`
+	BestMapMatches int             `json:"best_map_matches"`
+	BestMapWins    int             `json:"best_map_wins"`
+}
+
+// LeaderboardEntry is a player's aggregate over a leaderboard window
+type LeaderboardEntry struct {
+	SteamID string `json:"steam_id"`
+	Name    string `json:"name"`
+	PlayerAggregate
+}
+
+// MatchMeta holds the match-level result of a parsed game
+type MatchMeta struct {
+	GameUUID     uuid.UUID `json:"game_uuid" db:"game_uuid"`
+	Map          string    `json:"map" db:"map"`
+	TeamA        TeamStats `json:"team_a"`
+	TeamB        TeamStats `json:"team_b"`
+	RoundsPlayed int       `json:"rounds_played" db:"rounds_played"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// MatchPlayer is a player's scoreboard row in a parsed game
+type MatchPlayer struct {
+	GameUUID uuid.UUID `json:"game_uuid" db:"game_uuid"`
+	PlayerStats
+	Result string `json:"result" db:"result"`
+}
+
+// MatchRound is a single round result in a parsed game
+type MatchRound struct {
+	GameUUID uuid.UUID `json:"game_uuid" db:"game_uuid"`
+	RoundResult
+}
+
 // CreateTablesSQL contains the SQL statements to create all tables
 const CreateTablesSQL = `
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
@@ -98,10 +256,146 @@ CREATE TABLE IF NOT EXISTS games (
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
 
+CREATE TABLE IF NOT EXISTS match_meta (
+    game_uuid UUID PRIMARY KEY REFERENCES games(uuid) ON DELETE CASCADE,
+    map VARCHAR(64) NOT NULL,
+    team_a_name VARCHAR(255) NOT NULL DEFAULT '',
+    team_a_score INTEGER NOT NULL,
+    team_a_starting_side VARCHAR(2) NOT NULL,
+    team_b_name VARCHAR(255) NOT NULL DEFAULT '',
+    team_b_score INTEGER NOT NULL,
+    team_b_starting_side VARCHAR(2) NOT NULL,
+    rounds_played INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS match_players (
+    game_uuid UUID NOT NULL REFERENCES games(uuid) ON DELETE CASCADE,
+    steam_id VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    team VARCHAR(16) NOT NULL,
+    result VARCHAR(4) NOT NULL,
+    kills INTEGER NOT NULL,
+    deaths INTEGER NOT NULL,
+    assists INTEGER NOT NULL,
+    adr DOUBLE PRECISION,
+    hs_pct DOUBLE PRECISION,
+    kast DOUBLE PRECISION,
+    rating DOUBLE PRECISION,
+    mvps INTEGER,
+    utility_damage INTEGER,
+    entry_kills INTEGER,
+    clutches INTEGER,
+    PRIMARY KEY (game_uuid, steam_id)
+);
+
+CREATE TABLE IF NOT EXISTS match_rounds (
+    game_uuid UUID NOT NULL REFERENCES games(uuid) ON DELETE CASCADE,
+    round_number INTEGER NOT NULL,
+    winner_team VARCHAR(16) NOT NULL,
+    winner_side VARCHAR(2) NOT NULL,
+    reason VARCHAR(32) NOT NULL,
+    PRIMARY KEY (game_uuid, round_number)
+);
+
+-- Steam persona names and avatars, refreshed once older than the cache TTL
+CREATE TABLE IF NOT EXISTS steam_profiles (
+    steam_id VARCHAR(255) PRIMARY KEY,
+    persona_name VARCHAR(255) NOT NULL DEFAULT '',
+    avatar_url TEXT NOT NULL DEFAULT '',
+    profile_url TEXT NOT NULL DEFAULT '',
+    fetched_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Audit trail of /remove; no foreign keys so entries outlive the removed user
+CREATE TABLE IF NOT EXISTS user_removals (
+    uuid UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    guild_id VARCHAR(255) NOT NULL,
+    user_uuid UUID NOT NULL,
+    steam_id VARCHAR(255) NOT NULL,
+    actor_discord_id VARCHAR(32) NOT NULL,
+    actor_role VARCHAR(16) NOT NULL,
+    deleted_globally BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Steam polling state per user; users without a row are polled every cycle
+CREATE TABLE IF NOT EXISTS user_poll_states (
+    user_uuid UUID PRIMARY KEY REFERENCES users(uuid) ON DELETE CASCADE,
+    status VARCHAR(32) NOT NULL DEFAULT 'active',
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    last_success_at TIMESTAMP WITH TIME ZONE,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_poll_at TIMESTAMP WITH TIME ZONE,
+    notified_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- One job per share code; the unique constraint makes requesting a demo idempotent
+-- across restarts and bot replicas
+CREATE TABLE IF NOT EXISTS match_jobs (
+    uuid UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    share_code VARCHAR(255) NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'discovered',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT match_jobs_share_code_key UNIQUE (share_code)
+);
+
+-- Guilds a match summary was posted to, so retries only send it to the guilds that missed it
+CREATE TABLE IF NOT EXISTS match_notifications (
+    game_uuid UUID NOT NULL REFERENCES games(uuid) ON DELETE CASCADE,
+    guild_id VARCHAR(255) NOT NULL,
+    sent_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (game_uuid, guild_id)
+);
+
 -- Create indexes for better performance
 CREATE INDEX IF NOT EXISTS idx_guilds_guild_id ON guilds(guild_id);
 CREATE INDEX IF NOT EXISTS idx_users_steam_id ON users(steam_id);
 CREATE INDEX IF NOT EXISTS idx_games_share_code ON games(share_code);
+CREATE INDEX IF NOT EXISTS idx_match_players_steam_id ON match_players(steam_id);
+CREATE INDEX IF NOT EXISTS idx_match_meta_map ON match_meta(map);
+CREATE INDEX IF NOT EXISTS idx_user_removals_guild_id ON user_removals(guild_id, created_at);
+
+-- Decoded share code fields (0 when the share code could not be decoded)
+ALTER TABLE games ADD COLUMN IF NOT EXISTS match_id BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS outcome_id BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE games ADD COLUMN IF NOT EXISTS token INTEGER NOT NULL DEFAULT 0;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_games_match_id ON games(match_id) WHERE match_id <> 0;
+
+-- Parsed match stats as received from the demo service
+ALTER TABLE games ADD COLUMN IF NOT EXISTS stats JSONB;
+
+-- Auth codes are stored envelope encrypted, so leave room for the ciphertext
+ALTER TABLE users ALTER COLUMN auth_code TYPE TEXT;
+
+-- Discord member a Steam user is linked to ('' when unlinked); one member can link several Steam accounts
+ALTER TABLE users ADD COLUMN IF NOT EXISTS discord_user_id VARCHAR(32) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_users_discord_user_id ON users(discord_user_id) WHERE discord_user_id <> '';
+
+-- Match job state machine: per-stage timestamps, attempts at the current stage,
+-- the last error and when the reconciler acts on the job next
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS last_error TEXT NOT NULL DEFAULT '';
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS download_requested_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS demo_ready_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS parse_requested_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS parsed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS notified_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS failed_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_match_jobs_due ON match_jobs(next_attempt_at) WHERE status NOT IN ('notified', 'failed');
+
+-- Registered players whose polling found a match job, so jobs can be shown per guild
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS steam_ids JSONB NOT NULL DEFAULT '[]';
+
+-- Nonce of the signed callback URL the demo service was last handed, cleared once used
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS callback_nonce VARCHAR(64) NOT NULL DEFAULT '';
+
+-- End of the lease taken by whoever runs the job's current step, so it runs once at a time
+ALTER TABLE match_jobs ADD COLUMN IF NOT EXISTS leased_until TIMESTAMP WITH TIME ZONE;
 
 -- Create triggers to automatically update updated_at timestamps
 CREATE OR REPLACE FUNCTION update_updated_at_column()
@@ -115,4 +409,6 @@ $$ language 'plpgsql';
 CREATE OR REPLACE TRIGGER update_guilds_updated_at BEFORE UPDATE ON guilds FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 CREATE OR REPLACE TRIGGER update_users_updated_at BEFORE UPDATE ON users FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 CREATE OR REPLACE TRIGGER update_games_updated_at BEFORE UPDATE ON games FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
-`
\ No newline at end of file
+CREATE OR REPLACE TRIGGER update_match_meta_updated_at BEFORE UPDATE ON match_meta FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE OR REPLACE TRIGGER update_match_jobs_updated_at BEFORE UPDATE ON match_jobs FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+`
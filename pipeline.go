@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cs-match-summary-bot/sharecode"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Pipeline custom IDs:
+//
+//	pipeline|retry|<share_code>  button that repeats the current step of a job
+const (
+	pipelineCustomID    = "pipeline"
+	pipelineActionRetry = "retry"
+	pipelineListSize    = 10
+	pipelineErrorLimit  = 200
+)
+
+// matchJobStages lists the pipeline stages in order with the time a job reached each
+var matchJobStages = []struct {
+	status  string
+	label   string
+	reached func(job *MatchJob) *time.Time
+}{
+	{MatchJobDiscovered, "Discovered", func(job *MatchJob) *time.Time { return &job.CreatedAt }},
+	{MatchJobDownloadRequested, "Download requested", func(job *MatchJob) *time.Time { return job.DownloadRequestedAt }},
+	{MatchJobDemoReady, "Demo ready", func(job *MatchJob) *time.Time { return job.DemoReadyAt }},
+	{MatchJobParseRequested, "Parse requested", func(job *MatchJob) *time.Time { return job.ParseRequestedAt }},
+	{MatchJobParsed, "Parsed", func(job *MatchJob) *time.Time { return job.ParsedAt }},
+	{MatchJobNotified, "Notified", func(job *MatchJob) *time.Time { return job.NotifiedAt }},
+	{MatchJobFailed, "Failed", func(job *MatchJob) *time.Time { return job.FailedAt }},
+}
+
+// matchJobLabel returns the readable name of a status
+func matchJobLabel(status string) string {
+	for _, stage := range matchJobStages {
+		if stage.status == status {
+			return stage.label
+		}
+	}
+	return status
+}
+
+// handleMatchStatusSubcommand privately shows where a match of the guild is in the demo pipeline
+func handleMatchStatusSubcommand(s *discordgo.Session, i *discordgo.InteractionCreate, options []*discordgo.ApplicationCommandInteractionDataOption) {
+	var shareCode string
+	for _, option := range options {
+		if option.Name == "share_code" {
+			shareCode = strings.TrimSpace(option.StringValue())
+		}
+	}
+
+	if _, err := sharecode.Decode(shareCode); err != nil {
+		respondWithError(s, i, fmt.Sprintf("Invalid share code: %v", err))
+		return
+	}
+
+	job, err := getGuildMatchJob(i.GuildID, shareCode)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(s, i, "This match was never picked up by the pipeline for this server")
+		} else {
+			log.Printf("Error getting match job: %v", err)
+			respondWithError(s, i, "Failed to get match status")
+		}
+		return
+	}
+
+	respondEphemeral(s, i, &discordgo.InteractionResponseData{
+		Embeds: []*discordgo.MessageEmbed{buildMatchJobEmbed(job)},
+	})
+}
+
+// buildMatchJobEmbed lists every stage a job reached with how long it took to get there
+func buildMatchJobEmbed(job *MatchJob) *discordgo.MessageEmbed {
+	var lines []string
+	var previous *time.Time
+	for _, stage := range matchJobStages {
+		reached := stage.reached(job)
+		if reached == nil {
+			if stage.status != MatchJobFailed {
+				lines = append(lines, fmt.Sprintf("⬜ %s", stage.label))
+			}
+			continue
+		}
+
+		icon := "✅"
+		if stage.status == MatchJobFailed {
+			icon = "❌"
+		}
+		line := fmt.Sprintf("%s %s — <t:%d:f>", icon, stage.label, reached.Unix())
+		if previous != nil {
+			line += fmt.Sprintf(" (+%s)", max(0, reached.Sub(*previous)).Round(time.Second))
+		}
+		lines = append(lines, line)
+		previous = reached
+	}
+
+	color := 0x0099ff
+	switch {
+	case job.Status == MatchJobNotified:
+		color = 0x00ff00
+	case job.Status == MatchJobFailed:
+		color = 0xff0000
+	case job.LastError != "":
+		color = 0xffa500
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "🛰️ Match Pipeline",
+		Description: fmt.Sprintf("`%s`\n**Status:** %s", job.ShareCode, matchJobLabel(job.Status)),
+		Color:       color,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:  "Stages",
+				Value: strings.Join(lines, "\n"),
+			},
+			{
+				Name:   "Attempts",
+				Value:  fmt.Sprintf("%d", job.Attempts),
+				Inline: true,
+			},
+		},
+	}
+
+	if job.NextAttemptAt != nil {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Next check",
+			Value:  fmt.Sprintf("<t:%d:R>", job.NextAttemptAt.Unix()),
+			Inline: true,
+		})
+	}
+	if job.LastError != "" {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Last error",
+			Value: "```" + truncate(job.LastError, pipelineErrorLimit) + "```",
+		})
+	}
+
+	return embed
+}
+
+// handlePipelineSlashCommand lists the guild's failed and stuck matches with a retry button for each
+func handlePipelineSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Member == nil || i.Member.Permissions&discordgo.PermissionManageGuild == 0 {
+		respondWithError(s, i, "You need the Manage Server permission to view the pipeline")
+		return
+	}
+
+	jobs, err := getTroubledMatchJobs(i.GuildID, pipelineListSize)
+	if err != nil {
+		log.Printf("Error getting troubled match jobs: %v", err)
+		respondWithError(s, i, "Failed to get pipeline status")
+		return
+	}
+
+	if len(jobs) == 0 {
+		respondEphemeral(s, i, &discordgo.InteractionResponseData{
+			Content: "✅ No failed or stuck matches in this server.",
+		})
+		return
+	}
+
+	respondEphemeral(s, i, &discordgo.InteractionResponseData{
+		Embeds:     []*discordgo.MessageEmbed{buildPipelineEmbed(jobs)},
+		Components: pipelineButtons(jobs),
+	})
+}
+
+// buildPipelineEmbed numbers the jobs so they match their retry buttons
+func buildPipelineEmbed(jobs []*MatchJob) *discordgo.MessageEmbed {
+	var lines []string
+	for n, job := range jobs {
+		line := fmt.Sprintf("**%d.** `%s` • %s • %d attempt(s) • updated <t:%d:R>",
+			n+1, job.ShareCode, matchJobLabel(job.Status), job.Attempts, job.UpdatedAt.Unix())
+		if job.LastError != "" {
+			line += "\n    " + truncate(job.LastError, pipelineErrorLimit/2)
+		}
+		lines = append(lines, line)
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       "🛰️ Failed and Stuck Matches",
+		Description: strings.Join(lines, "\n"),
+		Color:       0xffa500,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "Retry repeats the step the match is stuck in • /match status for details",
+		},
+	}
+}
+
+// pipelineButtons adds a retry button per job, five to a row
+func pipelineButtons(jobs []*MatchJob) []discordgo.MessageComponent {
+	var rows []discordgo.MessageComponent
+	var buttons []discordgo.MessageComponent
+	for n, job := range jobs {
+		buttons = append(buttons, discordgo.Button{
+			Label:    fmt.Sprintf("Retry %d", n+1),
+			Style:    discordgo.SecondaryButton,
+			CustomID: strings.Join([]string{pipelineCustomID, pipelineActionRetry, job.ShareCode}, "|"),
+		})
+		if len(buttons) == 5 || n == len(jobs)-1 {
+			rows = append(rows, discordgo.ActionsRow{Components: buttons})
+			buttons = nil
+		}
+	}
+	return rows
+}
+
+// handlePipelineComponent repeats the current step of a job after checking the clicker may
+func handlePipelineComponent(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	parts := strings.Split(i.MessageComponentData().CustomID, "|")
+	if len(parts) != 3 || parts[1] != pipelineActionRetry {
+		respondWithError(s, i, "This button is no longer valid")
+		return
+	}
+	if i.Member == nil || i.Member.Permissions&discordgo.PermissionManageGuild == 0 {
+		respondWithError(s, i, "You need the Manage Server permission to retry matches")
+		return
+	}
+
+	job, err := getGuildMatchJob(i.GuildID, parts[2])
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(s, i, "This match does not belong to this server")
+		} else {
+			log.Printf("Error getting match job: %v", err)
+			respondWithError(s, i, "Failed to get match job")
+		}
+		return
+	}
+	if job.Status == MatchJobNotified {
+		respondWithError(s, i, "This match is already done")
+		return
+	}
+	if steamPoller == nil {
+		respondWithError(s, i, "The pipeline is not running")
+		return
+	}
+
+	// Lease the job so the reconciler or another click doesn't run the same step meanwhile
+	job, err = leaseMatchJob(job.ShareCode, matchJobLease)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(s, i, "This match is already being worked on, try again in a few minutes")
+		} else {
+			log.Printf("Error leasing match job: %v", err)
+			respondWithError(s, i, "Failed to retry match")
+		}
+		return
+	}
+
+	// The demo service may take a while to answer
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	})
+	if err != nil {
+		log.Printf("Error deferring pipeline retry: %v", err)
+		return
+	}
+
+	log.Printf("Manual retry of match job %s in %s by %s", job.ShareCode, job.Status, interactionUserID(i))
+	content := ""
+	ctx, cancel := context.WithTimeout(context.Background(), matchJobStepTimeout)
+	defer cancel()
+	status, err := runMatchJob(ctx, steamPoller, job)
+	if err != nil {
+		// Schedule the next attempt the way the reconciler would instead of waiting out the lease
+		recordMatchJobError(job.ShareCode, job.Attempts, err)
+		content = fmt.Sprintf("❌ Retrying `%s` failed: %s", job.ShareCode, truncate(err.Error(), pipelineErrorLimit))
+	} else {
+		content = fmt.Sprintf("🔁 Retried `%s`, it is now **%s**.", job.ShareCode, matchJobLabel(status))
+	}
+
+	_, err = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content})
+	if err != nil {
+		log.Printf("Error responding to pipeline retry: %v", err)
+	}
+}
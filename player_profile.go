@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// profileRecentMatches is the number of matches used for the recent form
+const profileRecentMatches = 10
+
+// profileMaxEmbeds is Discord's limit on embeds per message
+const profileMaxEmbeds = 10
+
+var resultIcons = map[string]string{
+	ResultWin:  "🟩",
+	ResultLoss: "🟥",
+	ResultTie:  "⬜",
+}
+
+// KD returns kills per death, treating zero deaths as one
+func (a *PlayerAggregate) KD() float64 {
+	return float64(a.Kills) / float64(max(a.Deaths, 1))
+}
+
+// WinRate returns the percentage of matches won
+func (a *PlayerAggregate) WinRate() float64 {
+	if a.Matches == 0 {
+		return 0
+	}
+	return float64(a.Wins) / float64(a.Matches) * 100
+}
+
+// handleProfileSlashCommand shows the profile of a SteamID64, or of every Steam account
+// linked to a Discord member
+func handleProfileSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var steamID string
+	var member *discordgo.User
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "steam_id":
+			steamID = strings.TrimSpace(option.StringValue())
+		case "member":
+			member = option.UserValue(nil)
+		}
+	}
+
+	var steamIDs []string
+	switch {
+	case member != nil && steamID != "":
+		respondWithError(s, i, "Use either `steam_id` or `member`, not both")
+		return
+	case member != nil:
+		users, err := getUsersByDiscordID(member.ID)
+		if err != nil {
+			log.Printf("Error getting linked accounts for %s: %v", member.ID, err)
+			respondWithError(s, i, "Failed to get linked Steam accounts")
+			return
+		}
+		if len(users) == 0 {
+			respondWithError(s, i, fmt.Sprintf("<@%s> has no linked Steam accounts", member.ID))
+			return
+		}
+		for _, user := range users {
+			steamIDs = append(steamIDs, user.SteamID)
+		}
+	case steamID != "":
+		normalized, err := resolveSteamID(context.Background(), steamID)
+		if err != nil {
+			respondWithError(s, i, fmt.Sprintf("Invalid Steam ID: %v", err))
+			return
+		}
+		steamIDs = []string{normalized}
+	default:
+		respondWithError(s, i, "Provide a Steam ID or a Discord member")
+		return
+	}
+
+	profiles := lookupSteamProfiles(context.Background(), steamIDs)
+
+	var embeds []*discordgo.MessageEmbed
+	for _, id := range steamIDs {
+		profile, err := getPlayerProfile(id, profileRecentMatches)
+		if err != nil {
+			log.Printf("Error getting profile for %s: %v", id, err)
+			respondWithError(s, i, "Failed to get player profile")
+			return
+		}
+		if profile.Lifetime.Matches > 0 && len(embeds) < profileMaxEmbeds {
+			embeds = append(embeds, buildProfileEmbed(profile, profiles[id]))
+		}
+	}
+
+	if len(embeds) == 0 {
+		respondWithError(s, i, fmt.Sprintf("No parsed matches found for %s", strings.Join(steamIDs, ", ")))
+		return
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: embeds,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to profile command: %v", err)
+	}
+}
+
+// buildProfileEmbed renders lifetime stats and the recent form of a player,
+// using the Steam persona name and avatar when steamProfile is known
+func buildProfileEmbed(profile *PlayerProfile, steamProfile *SteamProfile) *discordgo.MessageEmbed {
+	name := profile.Name
+	var thumbnail *discordgo.MessageEmbedThumbnail
+	if steamProfile != nil {
+		if steamProfile.PersonaName != "" {
+			name = steamProfile.PersonaName
+		}
+		if steamProfile.AvatarURL != "" {
+			thumbnail = &discordgo.MessageEmbedThumbnail{URL: steamProfile.AvatarURL}
+		}
+	}
+	if name == "" {
+		name = profile.SteamID
+	}
+
+	lifetime := &profile.Lifetime
+	recent := &profile.Recent
+
+	bestMap := missingStat
+	if profile.BestMap != "" {
+		bestMap = fmt.Sprintf("%s (%d/%d won)", displayMapName(profile.BestMap), profile.BestMapWins, profile.BestMapMatches)
+	}
+
+	var form []string
+	// Oldest first so the trend reads left to right
+	for n := len(profile.RecentResults) - 1; n >= 0; n-- {
+		form = append(form, resultIcons[profile.RecentResults[n]])
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       truncate("👤 "+name, embedTitleLimit),
+		URL:         "https://steamcommunity.com/profiles/" + profile.SteamID,
+		Description: fmt.Sprintf("`%s`", profile.SteamID),
+		Color:       0x0099ff,
+		Thumbnail:   thumbnail,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Matches", Value: fmt.Sprintf("%d (%d-%d-%d)", lifetime.Matches, lifetime.Wins, lifetime.Losses, lifetime.Ties), Inline: true},
+			{Name: "Win Rate", Value: fmt.Sprintf("%.0f%%", lifetime.WinRate()), Inline: true},
+			{Name: "K/D", Value: fmt.Sprintf("%.2f", lifetime.KD()), Inline: true},
+			{Name: "ADR", Value: formatOptionalFloat(lifetime.ADR, "%.1f"), Inline: true},
+			{Name: "HS%", Value: formatOptionalFloat(lifetime.HeadshotPct, "%.0f%%"), Inline: true},
+			{Name: "Rating", Value: formatOptionalFloat(lifetime.Rating, "%.2f"), Inline: true},
+			{Name: "Best Map", Value: bestMap, Inline: true},
+			{
+				Name: fmt.Sprintf("Last %d", recent.Matches),
+				Value: fmt.Sprintf("%s\nK/D %.2f %s • ADR %s • Win rate %.0f%%",
+					strings.Join(form, ""),
+					recent.KD(), trendArrow(recent.KD(), lifetime.KD()),
+					formatOptionalFloat(recent.ADR, "%.1f"),
+					recent.WinRate()),
+			},
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "Aggregated over all parsed matches",
+		},
+	}
+}
+
+// trendArrow compares recent form against the lifetime value
+func trendArrow(recent, lifetime float64) string {
+	switch {
+	case recent > lifetime*1.05:
+		return "▲"
+	case recent < lifetime*0.95:
+		return "▼"
+	}
+	return "▬"
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"cs-match-summary-bot/sharecode"
+	"cs-match-summary-bot/steamapi"
+	"github.com/bwmarrin/discordgo"
+)
+
+// Registration custom IDs, where member is the Discord member the account is linked to
+// and empty for whoever submits the form:
+//
+//	register|start|<steam_id>|<last_share_code>|<member>  button that opens the modal, optionally prefilled
+//	register|submit|<member>                              the modal itself
+const (
+	registerCustomID     = "register"
+	registerActionStart  = "start"
+	registerActionSubmit = "submit"
+)
+
+// Modal text input custom IDs
+const (
+	registerFieldSteamID   = "steam_id"
+	registerFieldAuthCode  = "auth_code"
+	registerFieldShareCode = "last_share_code"
+)
+
+const (
+	authCodeHelpURL = "https://help.steampowered.com/en/wizard/HelpWithGameIssue/?appid=730&issueid=128"
+	customIDLimit   = 100
+)
+
+// authCodePattern matches a Steam game authentication code, e.g. AAAA-BBBBB-CCCC
+var authCodePattern = regexp.MustCompile(`^[A-Z0-9]{4}-[A-Z0-9]{5}-[A-Z0-9]{4}$`)
+
+// handleRegisterSlashCommand replies privately with a step-by-step guide and a button
+// that opens the registration modal, so the auth code never appears in the channel.
+// Members with Manage Server can pick the member the account is linked to.
+func handleRegisterSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.GuildID == "" {
+		respondWithError(s, i, "Register from within a server so its match summaries include you")
+		return
+	}
+
+	var member string
+	content := ""
+	for _, option := range i.ApplicationCommandData().Options {
+		if option.Name == "member" {
+			member = option.UserValue(nil).ID
+		}
+	}
+	if member == interactionUserID(i) {
+		member = ""
+	}
+	if member != "" {
+		if !canManageGuild(i) {
+			respondWithError(s, i, "Only members with Manage Server can register an account for another member")
+			return
+		}
+		content = fmt.Sprintf("Registering a Steam account for <@%s>, fill in their details.", member)
+	}
+
+	respondEphemeral(s, i, &discordgo.InteractionResponseData{
+		Content:    content,
+		Embeds:     []*discordgo.MessageEmbed{registrationGuideEmbed()},
+		Components: registrationButtons("Open registration form", "", "", member),
+	})
+}
+
+// handleRegisterComponent handles the registration button and modal submissions
+func handleRegisterComponent(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var customID string
+	if i.Type == discordgo.InteractionModalSubmit {
+		customID = i.ModalSubmitData().CustomID
+	} else {
+		customID = i.MessageComponentData().CustomID
+	}
+
+	parts := strings.Split(customID, "|")
+	if len(parts) < 2 {
+		respondWithError(s, i, "This registration form is no longer valid")
+		return
+	}
+
+	switch parts[1] {
+	case registerActionStart:
+		var steamID, shareCode, member string
+		if len(parts) >= 4 {
+			steamID, shareCode = parts[2], parts[3]
+		}
+		if len(parts) == 5 {
+			member = parts[4]
+		}
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseModal,
+			Data: registrationModal(steamID, shareCode, member),
+		})
+		if err != nil {
+			log.Printf("Error opening registration modal: %v", err)
+		}
+	case registerActionSubmit:
+		var member string
+		if len(parts) == 3 {
+			member = parts[2]
+		}
+		handleRegisterModalSubmit(s, i, member)
+	}
+}
+
+// handleRegisterModalSubmit validates every field and only stores the registration when all are valid
+func handleRegisterModalSubmit(s *discordgo.Session, i *discordgo.InteractionCreate, member string) {
+	values := make(map[string]string)
+	for _, row := range i.ModalSubmitData().Components {
+		actions, ok := row.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, component := range actions.Components {
+			if input, ok := component.(*discordgo.TextInput); ok {
+				values[input.CustomID] = strings.TrimSpace(input.Value)
+			}
+		}
+	}
+
+	steamID := values[registerFieldSteamID]
+	authCode := strings.ToUpper(values[registerFieldAuthCode])
+	lastShareCode := values[registerFieldShareCode]
+
+	normalized, problems := validateRegistration(steamID, authCode, lastShareCode)
+	if len(problems) > 0 {
+		respondEphemeral(s, i, &discordgo.InteractionResponseData{
+			Content:    "❌ Nothing was saved, please fix the following:\n• " + strings.Join(problems, "\n• "),
+			Components: registrationButtons("Try again", steamID, lastShareCode, member),
+		})
+		return
+	}
+
+	completeRegistration(s, i, member, normalized, authCode, lastShareCode)
+}
+
+// validateRegistration checks each registration field and describes every problem found.
+// Every accepted Steam ID format, including custom profile URLs, is normalized to SteamID64
+// so the same account can't be registered twice.
+func validateRegistration(steamID, authCode, lastShareCode string) (string, []string) {
+	var problems []string
+	normalized, err := resolveSteamID(context.Background(), steamID)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("**Steam ID** is invalid: %v", err))
+	}
+	if !authCodePattern.MatchString(authCode) {
+		problems = append(problems, "**Authentication code** must look like `AAAA-AAAAA-AAAA`")
+	}
+	if _, err := sharecode.Decode(lastShareCode); err != nil {
+		problems = append(problems, fmt.Sprintf("**Last share code** is invalid: %v", err))
+	}
+	return normalized, problems
+}
+
+// completeRegistration stores a validated registration, links it to member, or the invoker
+// when member is empty, and adds it to the guild
+func completeRegistration(s *discordgo.Session, i *discordgo.InteractionCreate, member, steamID, authCode, lastShareCode string) {
+	invokerID := interactionUserID(i)
+	if member == "" {
+		member = invokerID
+	}
+	admin := canManageGuild(i)
+	if member != invokerID && !admin {
+		respondWithError(s, i, "Only members with Manage Server can register an account for another member")
+		return
+	}
+
+	// Ensure guild exists
+	guild, err := ensureGuildExists(i.GuildID)
+	if err != nil {
+		log.Printf("Error ensuring guild exists: %v", err)
+		respondWithError(s, i, "Failed to process guild")
+		return
+	}
+
+	// Check if user already exists
+	existingUser, err := getUserBySteamID(steamID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error checking existing user: %v", err)
+		respondWithError(s, i, "Failed to check user registration")
+		return
+	}
+
+	if err == nil {
+		// Only the linked member may update a linked account
+		if existingUser.DiscordUserID != "" && existingUser.DiscordUserID != member {
+			respondWithError(s, i, "This Steam account is linked to another Discord member")
+			return
+		}
+		// Accounts registered without a link, e.g. through !cs register, can only be claimed by
+		// an admin of a server the account is registered in
+		if existingUser.DiscordUserID == "" {
+			if !admin {
+				respondWithError(s, i, "This Steam account is already registered, ask a member with Manage Server to link it for you")
+				return
+			}
+			if !slices.Contains(guild.UserIDs, existingUser.UUID.String()) {
+				respondWithError(s, i, "This Steam account is registered in another server, it can only be linked by an admin there")
+				return
+			}
+		}
+
+		// The stored auth code is only replaced by one Steam accepts
+		if err := verifyAuthCode(steamID, authCode, lastShareCode); err != nil {
+			log.Printf("Error verifying auth code of %s: %v", steamID, err)
+			message := "❌ Steam could not be reached to check the authentication code, nothing was changed. Please try again later."
+			if errors.Is(err, steamapi.ErrInvalidAuthCode) {
+				message = "❌ Steam rejected the authentication code for this share code, nothing was changed."
+			}
+			respondEphemeral(s, i, &discordgo.InteractionResponseData{
+				Content:    message,
+				Components: registrationButtons("Try again", steamID, lastShareCode, member),
+			})
+			return
+		}
+
+		// User exists, update their info and link it to the member
+		existingUser.AuthCode = authCode
+		existingUser.LastShareCode = lastShareCode
+		existingUser.DiscordUserID = member
+		err = updateUser(existingUser)
+		if err != nil {
+			log.Printf("Error updating user: %v", err)
+			respondWithError(s, i, "Failed to update user")
+			return
+		}
+
+		// Add user to guild if not already added
+		err = addUserToGuild(i.GuildID, existingUser.UUID)
+		if err != nil {
+			log.Printf("Error adding user to guild: %v", err)
+		}
+
+		respondEphemeral(s, i, &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("✅ User updated successfully!\n**Steam ID:** %s\n**Linked to:** <@%s>\n**Last Share Code:** %s", steamID, member, lastShareCode),
+		})
+		return
+	}
+
+	// Create new user
+	user, err := createUser(steamID, authCode, lastShareCode, member)
+	if err != nil {
+		log.Printf("Error creating user: %v", err)
+		respondWithError(s, i, "Failed to create user")
+		return
+	}
+
+	// Add user to guild
+	err = addUserToGuild(i.GuildID, user.UUID)
+	if err != nil {
+		log.Printf("Error adding user to guild: %v", err)
+		respondWithError(s, i, "User created but failed to add to guild")
+		return
+	}
+
+	respondEphemeral(s, i, &discordgo.InteractionResponseData{
+		Content: fmt.Sprintf("✅ User registered successfully!\n**Steam ID:** %s\n**Linked to:** <@%s>\n**Last Share Code:** %s", user.SteamID, member, user.LastShareCode),
+	})
+}
+
+// canManageGuild reports whether the invoker of an interaction has Manage Server
+func canManageGuild(i *discordgo.InteractionCreate) bool {
+	return i.Member != nil && i.Member.Permissions&discordgo.PermissionManageGuild != 0
+}
+
+// verifyAuthCode asks Steam for the match after lastShareCode to check that authCode belongs
+// to steamID. Steam answering that the next match isn't available yet proves it as well.
+func verifyAuthCode(steamID, authCode, lastShareCode string) error {
+	if steamClient == nil {
+		return errors.New("Steam Web API client not configured")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSteamAPITimeout)
+	defer cancel()
+
+	_, err := steamClient.GetNextMatchSharingCode(ctx, steamID, authCode, lastShareCode)
+	if errors.Is(err, steamapi.ErrMatchNotReady) {
+		return nil
+	}
+	return err
+}
+
+// registrationGuideEmbed explains where to find each value the form asks for
+func registrationGuideEmbed() *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Title:       "📝 Register for match summaries",
+		Description: "Only you can see this message and your answers. You need three things:",
+		Color:       0x0099ff,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:  "1. Your Steam ID",
+				Value: "Your profile URL (`steamcommunity.com/id/…` or `/profiles/…`), SteamID64, `STEAM_0:X:Y` or `[U:1:N]`.",
+			},
+			{
+				Name:  "2. Your game authentication code",
+				Value: fmt.Sprintf("Open [Steam Help → Access to your match history](%s) and create an authentication code. It looks like `AAAA-AAAAA-AAAA`.", authCodeHelpURL),
+			},
+			{
+				Name:  "3. Your most recent share code",
+				Value: "On the same page, copy the code of your most recent match, or in CS2 open **Watch → Your Matches** and copy the share link. It looks like `CSGO-xxxxx-xxxxx-xxxxx-xxxxx-xxxxx`.",
+			},
+		},
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "Every field is checked before anything is saved",
+		},
+	}
+}
+
+// registrationButtons shows the button that opens the modal next to a link to the Steam help page
+func registrationButtons(label, steamID, lastShareCode, member string) []discordgo.MessageComponent {
+	customID := strings.Join([]string{registerCustomID, registerActionStart,
+		strings.ReplaceAll(steamID, "|", ""), strings.ReplaceAll(lastShareCode, "|", ""), member}, "|")
+	if len(customID) > customIDLimit {
+		// Drop the prefilled values, never the member
+		customID = strings.Join([]string{registerCustomID, registerActionStart, "", "", member}, "|")
+	}
+
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    label,
+					Style:    discordgo.PrimaryButton,
+					CustomID: customID,
+				},
+				discordgo.Button{
+					Label: "Get authentication code",
+					Style: discordgo.LinkButton,
+					URL:   authCodeHelpURL,
+				},
+			},
+		},
+	}
+}
+
+// registrationModal builds the registration form for member, prefilling the non-secret fields
+func registrationModal(steamID, lastShareCode, member string) *discordgo.InteractionResponseData {
+	return &discordgo.InteractionResponseData{
+		CustomID: registerCustomID + "|" + registerActionSubmit + "|" + member,
+		Title:    "Register for match summaries",
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+				discordgo.TextInput{
+					CustomID:    registerFieldSteamID,
+					Label:       "Steam ID or profile URL",
+					Style:       discordgo.TextInputShort,
+					Placeholder: "76561198000000001",
+					Value:       steamID,
+					Required:    true,
+					MaxLength:   100,
+				},
+			}},
+			discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+				discordgo.TextInput{
+					CustomID:    registerFieldAuthCode,
+					Label:       "Game authentication code",
+					Style:       discordgo.TextInputShort,
+					Placeholder: "AAAA-AAAAA-AAAA",
+					Required:    true,
+					MinLength:   15,
+					MaxLength:   15,
+				},
+			}},
+			discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+				discordgo.TextInput{
+					CustomID:    registerFieldShareCode,
+					Label:       "Most recent share code",
+					Style:       discordgo.TextInputShort,
+					Placeholder: "CSGO-xxxxx-xxxxx-xxxxx-xxxxx-xxxxx",
+					Value:       lastShareCode,
+					Required:    true,
+					MinLength:   34,
+					MaxLength:   34,
+				},
+			}},
+		},
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"cs-match-summary-bot/steamapi"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bwmarrin/discordgo"
+	"github.com/google/uuid"
+)
+
+// registrationInteraction is a modal submission by member in guild-1 with the given permissions
+func registrationInteraction(member string, permissions int64) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:      "interaction-1",
+		Token:   "token",
+		GuildID: "guild-1",
+		Member: &discordgo.Member{
+			User:        &discordgo.User{ID: member},
+			Permissions: permissions,
+		},
+	}}
+}
+
+func TestCompleteRegistrationRefusesUnlinkedAccountToMembers(t *testing.T) {
+	mock := mockDB(t)
+	discord := newFakeDiscord(t)
+	useTestKeyring(t)
+
+	// Registered through !cs register, so nobody is linked yet
+	legacy := &User{UUID: uuid.New(), SteamID: "76561198000000001", AuthCode: "AAAA-AAAAA-AAAA"}
+	expectGuild(t, mock, "guild-1")
+	expectUser(t, mock, legacy)
+
+	completeRegistration(webhookCtx.DiscordSession, registrationInteraction("222", 0), "", legacy.SteamID, "BBBB-BBBBB-BBBB", testShareCode)
+
+	responses := discord.responded()
+	if len(responses) != 1 || !strings.Contains(responses[0], "Manage Server") {
+		t.Fatalf("responses = %q, want a refusal pointing at Manage Server", responses)
+	}
+}
+
+func TestCompleteRegistrationLetsAdminsClaimUnlinkedAccount(t *testing.T) {
+	mock := mockDB(t)
+	discord := newFakeDiscord(t)
+	useTestKeyring(t)
+
+	useFakeSteam(t)
+
+	legacy := &User{UUID: uuid.New(), SteamID: "76561198000000001", AuthCode: "AAAA-AAAAA-AAAA"}
+	expectGuild(t, mock, "guild-1", legacy.UUID.String())
+	expectUser(t, mock, legacy)
+	mock.ExpectExec(`UPDATE users\s+SET auth_code`).
+		WithArgs(legacy.UUID, sqlmock.AnyArg(), testShareCode, sqlmock.AnyArg(), "333").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM user_poll_states`).
+		WithArgs(legacy.UUID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE guilds\s+SET user_ids`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	completeRegistration(webhookCtx.DiscordSession, registrationInteraction("333", discordgo.PermissionManageGuild), "", legacy.SteamID, "BBBB-BBBBB-BBBB", testShareCode)
+
+	responses := discord.responded()
+	if len(responses) != 1 || !strings.HasPrefix(responses[0], "✅") {
+		t.Fatalf("responses = %q, want a confirmation", responses)
+	}
+}
+
+func TestCompleteRegistrationRefusesClaimFromAnotherGuild(t *testing.T) {
+	mock := mockDB(t)
+	discord := newFakeDiscord(t)
+	useTestKeyring(t)
+	steam := useFakeSteam(t)
+
+	// Registered in another server only
+	legacy := &User{UUID: uuid.New(), SteamID: "76561198000000001", AuthCode: "AAAA-AAAAA-AAAA"}
+	expectGuild(t, mock, "guild-1")
+	expectUser(t, mock, legacy)
+
+	completeRegistration(webhookCtx.DiscordSession, registrationInteraction("333", discordgo.PermissionManageGuild), "", legacy.SteamID, "BBBB-BBBBB-BBBB", testShareCode)
+
+	responses := discord.responded()
+	if len(responses) != 1 || !strings.Contains(responses[0], "another server") {
+		t.Fatalf("responses = %q, want a refusal naming another server", responses)
+	}
+	if steam.Calls["GetNextMatchSharingCode"] != 0 {
+		t.Errorf("GetNextMatchSharingCode called %d times, want 0", steam.Calls["GetNextMatchSharingCode"])
+	}
+}
+
+func TestCompleteRegistrationKeepsAuthCodeSteamRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "rejected", err: steamapi.ErrInvalidAuthCode, want: "Steam rejected"},
+		{name: "unreachable", err: errors.New("connection refused"), want: "try again later"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := mockDB(t)
+			discord := newFakeDiscord(t)
+			useTestKeyring(t)
+			steam := useFakeSteam(t)
+
+			legacy := &User{UUID: uuid.New(), SteamID: "76561198000000001", AuthCode: "AAAA-AAAAA-AAAA"}
+			steam.SetUserError(legacy.SteamID, tc.err)
+			expectGuild(t, mock, "guild-1", legacy.UUID.String())
+			expectUser(t, mock, legacy)
+
+			// No UPDATE is expected, so storing the new code would fail the test
+			completeRegistration(webhookCtx.DiscordSession, registrationInteraction("333", discordgo.PermissionManageGuild), "", legacy.SteamID, "BBBB-BBBBB-BBBB", testShareCode)
+
+			responses := discord.responded()
+			if len(responses) != 1 || !strings.Contains(responses[0], tc.want) {
+				t.Fatalf("responses = %q, want one containing %q", responses, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompleteRegistrationLinksChosenMember(t *testing.T) {
+	mock := mockDB(t)
+	discord := newFakeDiscord(t)
+	useTestKeyring(t)
+
+	steamID := "76561198000000001"
+	expectGuild(t, mock, "guild-1")
+	expectNoUser(mock, steamID)
+	mock.ExpectQuery(`INSERT INTO users`).
+		WithArgs(sqlmock.AnyArg(), steamID, sqlmock.AnyArg(), testShareCode, sqlmock.AnyArg(), "444").
+		WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}).AddRow(time.Now(), time.Now()))
+	mock.ExpectExec(`UPDATE guilds\s+SET user_ids`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	completeRegistration(webhookCtx.DiscordSession, registrationInteraction("333", discordgo.PermissionManageGuild), "444", steamID, "BBBB-BBBBB-BBBB", testShareCode)
+
+	responses := discord.responded()
+	if len(responses) != 1 || !strings.Contains(responses[0], "<@444>") {
+		t.Fatalf("responses = %q, want a confirmation linking <@444>", responses)
+	}
+}
+
+func TestCompleteRegistrationRefusesChosenMemberToMembers(t *testing.T) {
+	mockDB(t)
+	discord := newFakeDiscord(t)
+
+	completeRegistration(webhookCtx.DiscordSession, registrationInteraction("222", 0), "444", "76561198000000001", "BBBB-BBBBB-BBBB", testShareCode)
+
+	responses := discord.responded()
+	if len(responses) != 1 || !strings.Contains(responses[0], "Manage Server") {
+		t.Fatalf("responses = %q, want a refusal pointing at Manage Server", responses)
+	}
+}
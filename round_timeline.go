@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Overtime is played as MR3: halves of 3 rounds, 6 rounds per overtime
+const overtimeHalfLength = 3
+
+var roundWinIcons = map[string]string{
+	RoundWinElimination: "💀",
+	RoundWinBomb:        "💣",
+	RoundWinDefuse:      "✂️",
+	RoundWinTime:        "⏱️",
+	RoundWinSurrender:   "🏳️",
+}
+
+var sideIcons = map[string]string{
+	SideCT: "🟦",
+	SideT:  "🟧",
+}
+
+// roundTimelineLegend explains the icons used in a round timeline
+const roundTimelineLegend = "🟦 CT  🟧 T  •  💀 elimination  💣 bomb  ✂️ defuse  ⏱️ time  🏳️ surrender"
+
+// timelineSegment is a labelled run of rounds, e.g. a regulation half or an overtime
+type timelineSegment struct {
+	label  string
+	rounds []RoundResult
+	// halftime is the index in rounds after which an overtime switches sides, 0 for none
+	halftime int
+}
+
+// formatRoundTimeline renders rounds as one line per half with the winning side and
+// win reason of every round. Overtimes are shown on their own line with a halftime
+// marker between their halves.
+func formatRoundTimeline(rounds []RoundResult, teamAStartingSide string) string {
+	if len(rounds) == 0 {
+		return ""
+	}
+
+	var lines []string
+	for _, segment := range splitTimeline(rounds, teamAStartingSide) {
+		icons := make([]string, 0, len(segment.rounds)+1)
+		for i, round := range segment.rounds {
+			if segment.halftime > 0 && i == segment.halftime {
+				icons = append(icons, "|")
+			}
+			icons = append(icons, roundIcon(round))
+		}
+		lines = append(lines, fmt.Sprintf("`%-8s` %s", segment.label, strings.Join(icons, " ")))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// roundIcon renders the winning side and win reason of a round
+func roundIcon(round RoundResult) string {
+	reason, ok := roundWinIcons[round.Reason]
+	if !ok {
+		reason = "❔"
+	}
+	return sideIcons[round.WinnerSide] + reason
+}
+
+// splitTimeline groups rounds into halves and overtimes. The regulation half length
+// is taken from the first round in which team A is no longer on its starting side,
+// so MR12, MR15 and wingman MR8 matches are all split correctly.
+func splitTimeline(rounds []RoundResult, teamAStartingSide string) []timelineSegment {
+	halfLength := len(rounds)
+	for i, round := range rounds {
+		if teamASide(round) != teamAStartingSide {
+			halfLength = i
+			break
+		}
+	}
+	if halfLength == 0 {
+		halfLength = len(rounds)
+	}
+
+	var segments []timelineSegment
+	regulation := 2 * halfLength
+	for start, half := 0, 1; start < len(rounds) && start < regulation; start, half = start+halfLength, half+1 {
+		end := min(start+halfLength, len(rounds))
+		label := "1st half"
+		if half == 2 {
+			label = "2nd half"
+		}
+		segments = append(segments, timelineSegment{label: label, rounds: rounds[start:end]})
+	}
+
+	for start, overtime := regulation, 1; start < len(rounds); start, overtime = start+2*overtimeHalfLength, overtime+1 {
+		end := min(start+2*overtimeHalfLength, len(rounds))
+		segment := timelineSegment{
+			label:  fmt.Sprintf("OT %d", overtime),
+			rounds: rounds[start:end],
+		}
+		if end-start > overtimeHalfLength {
+			segment.halftime = overtimeHalfLength
+		}
+		segments = append(segments, segment)
+	}
+
+	return segments
+}
+
+// teamASide returns the side team A played in a round, derived from who won it on which side
+func teamASide(round RoundResult) string {
+	if round.WinnerTeam == TeamA {
+		return round.WinnerSide
+	}
+	if round.WinnerSide == SideCT {
+		return SideT
+	}
+	return SideCT
+}
+
+// fitTimeline trims whole lines from the end of a timeline so it fits within limit runes
+func fitTimeline(timeline string, limit int) string {
+	lines := strings.Split(timeline, "\n")
+	trimmed := false
+	for len(lines) > 1 && utf8.RuneCountInString(strings.Join(lines, "\n"))+2 > limit {
+		lines = lines[:len(lines)-1]
+		trimmed = true
+	}
+	if trimmed {
+		lines = append(lines, "…")
+	}
+	return truncate(strings.Join(lines, "\n"), limit)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// timelineRounds builds rounds from winners, one "A" or "B" per round. Team A starts on
+// CT and the teams switch sides every halfLength rounds in regulation and every
+// overtime half after that.
+func timelineRounds(winners string, halfLength int) []RoundResult {
+	rounds := make([]RoundResult, 0, len(winners))
+	for i, winner := range winners {
+		var half int
+		if i < 2*halfLength {
+			half = i / halfLength
+		} else {
+			half = (i - 2*halfLength) / overtimeHalfLength
+		}
+		teamASide, teamBSide := SideCT, SideT
+		if half%2 == 1 {
+			teamASide, teamBSide = teamBSide, teamASide
+		}
+
+		round := RoundResult{Number: i + 1, WinnerTeam: TeamA, WinnerSide: teamASide, Reason: RoundWinElimination}
+		if winner == 'B' {
+			round.WinnerTeam, round.WinnerSide = TeamB, teamBSide
+		}
+		rounds = append(rounds, round)
+	}
+	return rounds
+}
+
+func TestSplitTimeline(t *testing.T) {
+	type segment struct {
+		label    string
+		rounds   int
+		halftime int
+	}
+	tests := []struct {
+		name   string
+		rounds []RoundResult
+		want   []segment
+	}{
+		{
+			name:   "MR12",
+			rounds: timelineRounds(strings.Repeat("AB", 6)+strings.Repeat("BA", 5)+"AA", 12),
+			want:   []segment{{"1st half", 12, 0}, {"2nd half", 12, 0}},
+		},
+		{
+			name:   "MR12 ended early",
+			rounds: timelineRounds(strings.Repeat("A", 12)+"A", 12),
+			want:   []segment{{"1st half", 12, 0}, {"2nd half", 1, 0}},
+		},
+		{
+			name:   "MR15",
+			rounds: timelineRounds(strings.Repeat("AB", 7)+"A"+strings.Repeat("BA", 7)+"B", 15),
+			want:   []segment{{"1st half", 15, 0}, {"2nd half", 15, 0}},
+		},
+		{
+			name:   "wingman",
+			rounds: timelineRounds("AAAAABBB"+"AAAB", 8),
+			want:   []segment{{"1st half", 8, 0}, {"2nd half", 4, 0}},
+		},
+		{
+			name:   "surrender before halftime",
+			rounds: timelineRounds("BBBBBBB", 12),
+			want:   []segment{{"1st half", 7, 0}},
+		},
+		{
+			name:   "multiple overtimes",
+			rounds: timelineRounds(strings.Repeat("AB", 12)+"ABABAB"+"BABABA"+"AAAA", 12),
+			want:   []segment{{"1st half", 12, 0}, {"2nd half", 12, 0}, {"OT 1", 6, 3}, {"OT 2", 6, 3}, {"OT 3", 4, 3}},
+		},
+		{
+			name:   "overtime decided in its first half",
+			rounds: timelineRounds(strings.Repeat("AB", 12)+"AAA", 12),
+			want:   []segment{{"1st half", 12, 0}, {"2nd half", 12, 0}, {"OT 1", 3, 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments := splitTimeline(tt.rounds, SideCT)
+
+			var got []segment
+			next := 1
+			for _, s := range segments {
+				got = append(got, segment{s.label, len(s.rounds), s.halftime})
+				for _, round := range s.rounds {
+					if round.Number != next {
+						t.Errorf("%s has round %d, want %d", s.label, round.Number, next)
+					}
+					next++
+				}
+			}
+			if next-1 != len(tt.rounds) {
+				t.Errorf("segments hold %d rounds, want %d", next-1, len(tt.rounds))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("segments = %+v, want %+v", got, tt.want)
+			}
+			for n := range got {
+				if got[n] != tt.want[n] {
+					t.Errorf("segment %d = %+v, want %+v", n, got[n], tt.want[n])
+				}
+			}
+		})
+	}
+}
+
+func TestFormatRoundTimeline(t *testing.T) {
+	rounds := timelineRounds(strings.Repeat("AB", 12)+"ABABAB", 12)
+	rounds[0].Reason = RoundWinBomb
+	rounds[1].Reason = RoundWinSurrender
+
+	lines := strings.Split(formatRoundTimeline(rounds, SideCT), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want two halves and one overtime: %q", len(lines), lines)
+	}
+	if !strings.HasPrefix(lines[0], "`1st half` 🟦💣 🟧🏳️ 🟦💀") {
+		t.Errorf("first half = %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "`2nd half` 🟧💀 🟦💀") {
+		t.Errorf("second half = %q, want team A on T", lines[1])
+	}
+	if want := "`OT 1    ` 🟦💀 🟧💀 🟦💀 | 🟦💀 🟧💀 🟦💀"; lines[2] != want {
+		t.Errorf("overtime = %q, want %q", lines[2], want)
+	}
+	if strings.Contains(lines[0]+lines[1], "|") {
+		t.Error("regulation halves have a halftime marker")
+	}
+
+	if got := formatRoundTimeline(nil, SideCT); got != "" {
+		t.Errorf("formatRoundTimeline(nil) = %q, want empty", got)
+	}
+}
+
+func TestFitTimeline(t *testing.T) {
+	// Thirty overtimes are more than a field can hold
+	rounds := timelineRounds(strings.Repeat("AB", 12)+strings.Repeat("ABABAB", 30), 12)
+	timeline := formatRoundTimeline(rounds, SideCT)
+	limit := embedFieldValueLimit - utf8.RuneCountInString(roundTimelineLegend) - 1
+	if utf8.RuneCountInString(timeline) <= limit {
+		t.Fatalf("timeline of %d runes fits already, the test needs a longer one", utf8.RuneCountInString(timeline))
+	}
+
+	fitted := fitTimeline(timeline, limit)
+	if count := utf8.RuneCountInString(fitted); count > limit {
+		t.Errorf("fitted timeline is %d runes, over the limit of %d", count, limit)
+	}
+	if !strings.HasSuffix(fitted, "\n…") {
+		t.Errorf("fitted timeline does not mark the dropped lines: %q", fitted)
+	}
+	if !strings.HasPrefix(timeline, strings.TrimSuffix(fitted, "…")) {
+		t.Error("fitted timeline does not keep whole lines from the start")
+	}
+
+	if short := formatRoundTimeline(rounds[:24], SideCT); fitTimeline(short, limit) != short {
+		t.Error("a timeline within the limit was changed")
+	}
+
+	// A single line that is too long is cut
+	if fitted := fitTimeline(strings.Repeat("x", 50), 20); utf8.RuneCountInString(fitted) != 20 {
+		t.Errorf("fitTimeline() = %q, want 20 runes", fitted)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Scoreboard image layout
+const (
+	scoreboardWidth     = 960
+	scoreboardPadding   = 24
+	scoreboardHeader    = 96
+	scoreboardTeamTitle = 40
+	scoreboardRowHeight = 32
+	scoreboardTeamGap   = 16
+)
+
+// scoreboardFileName is the attachment name used for the rendered scoreboard
+const scoreboardFileName = "scoreboard.png"
+
+var (
+	scoreboardBackground = color.RGBA{0x1b, 0x1e, 0x24, 0xff}
+	scoreboardRowEven    = color.RGBA{0x23, 0x27, 0x2e, 0xff}
+	scoreboardRowOdd     = color.RGBA{0x2a, 0x2e, 0x36, 0xff}
+	scoreboardHighlight  = color.RGBA{0x3d, 0x4a, 0x2e, 0xff}
+	scoreboardText       = color.RGBA{0xe6, 0xe6, 0xe6, 0xff}
+	scoreboardMuted      = color.RGBA{0x8a, 0x8f, 0x98, 0xff}
+	scoreboardCTColor    = color.RGBA{0x5d, 0x79, 0xae, 0xff}
+	scoreboardTColor     = color.RGBA{0xde, 0x9b, 0x35, 0xff}
+)
+
+// scoreboardColumn is a stat column drawn right-aligned at its x offset
+type scoreboardColumn struct {
+	title string
+	right int
+	value func(PlayerStats) string
+}
+
+var scoreboardColumns = []scoreboardColumn{
+	{"K", 470, func(p PlayerStats) string { return fmt.Sprintf("%d", p.Kills) }},
+	{"D", 530, func(p PlayerStats) string { return fmt.Sprintf("%d", p.Deaths) }},
+	{"A", 590, func(p PlayerStats) string { return fmt.Sprintf("%d", p.Assists) }},
+	{"ADR", 670, func(p PlayerStats) string { return formatOptionalFloat(p.ADR, "%.1f") }},
+	{"HS%", 750, func(p PlayerStats) string { return formatOptionalFloat(p.HeadshotPct, "%.0f%%") }},
+	{"KAST", 840, func(p PlayerStats) string { return formatOptionalFloat(p.KAST, "%.0f%%") }},
+	{"Rating", scoreboardWidth - scoreboardPadding - 12, func(p PlayerStats) string { return formatOptionalFloat(p.Rating, "%.2f") }},
+}
+
+type scoreboardFonts struct {
+	title   font.Face
+	heading font.Face
+	body    font.Face
+}
+
+var (
+	scoreboardRegular   *opentype.Font
+	scoreboardBold      *opentype.Font
+	scoreboardFontsErr  error
+	scoreboardFontsOnce sync.Once
+)
+
+// parseScoreboardFonts parses the embedded Go fonts once
+func parseScoreboardFonts() error {
+	scoreboardFontsOnce.Do(func() {
+		scoreboardRegular, scoreboardFontsErr = opentype.Parse(goregular.TTF)
+		if scoreboardFontsErr != nil {
+			scoreboardFontsErr = fmt.Errorf("failed to parse regular font: %w", scoreboardFontsErr)
+			return
+		}
+		scoreboardBold, scoreboardFontsErr = opentype.Parse(gobold.TTF)
+		if scoreboardFontsErr != nil {
+			scoreboardFontsErr = fmt.Errorf("failed to parse bold font: %w", scoreboardFontsErr)
+		}
+	})
+	return scoreboardFontsErr
+}
+
+// newScoreboardFonts creates the font faces for one render. Faces keep glyph buffers and
+// are not safe for concurrent use, so renders never share them.
+func newScoreboardFonts() (*scoreboardFonts, error) {
+	if err := parseScoreboardFonts(); err != nil {
+		return nil, err
+	}
+
+	fonts := &scoreboardFonts{}
+	faces := []struct {
+		dst  *font.Face
+		font *opentype.Font
+		size float64
+	}{
+		{&fonts.title, scoreboardBold, 34},
+		{&fonts.heading, scoreboardBold, 20},
+		{&fonts.body, scoreboardRegular, 18},
+	}
+	for _, f := range faces {
+		face, err := opentype.NewFace(f.font, &opentype.FaceOptions{Size: f.size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			fonts.Close()
+			return nil, fmt.Errorf("failed to create font face: %w", err)
+		}
+		*f.dst = face
+	}
+	return fonts, nil
+}
+
+// Close releases the font faces
+func (f *scoreboardFonts) Close() {
+	for _, face := range []font.Face{f.title, f.heading, f.body} {
+		if face != nil {
+			face.Close()
+		}
+	}
+}
+
+// renderScoreboardPNG draws both teams' scoreboards as a PNG image.
+// Players whose Steam ID is in registered get a highlighted row.
+func renderScoreboardPNG(stats *MatchStats, registered map[string]bool) ([]byte, error) {
+	img, err := renderScoreboard(stats, registered)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode scoreboard: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// renderScoreboard draws both teams' scoreboards onto a new image
+func renderScoreboard(stats *MatchStats, registered map[string]bool) (*image.RGBA, error) {
+	fonts, err := newScoreboardFonts()
+	if err != nil {
+		return nil, err
+	}
+	defer fonts.Close()
+
+	teams := []string{TeamA, TeamB}
+	height := scoreboardHeader + scoreboardPadding
+	for _, key := range teams {
+		height += scoreboardTeamTitle + scoreboardRowHeight*(len(teamPlayers(stats, key))+1) + scoreboardTeamGap
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, scoreboardWidth, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(scoreboardBackground), image.Point{}, draw.Src)
+
+	// Header: map name centered with both scores colored by starting side
+	title := displayMapName(stats.Map)
+	drawText(img, fonts.title, scoreboardText, title, (scoreboardWidth-textWidth(fonts.title, title))/2, 48)
+	scoreA := fmt.Sprintf("%d", stats.TeamA.Score)
+	scoreB := fmt.Sprintf("%d", stats.TeamB.Score)
+	drawText(img, fonts.title, sideColor(stats.TeamA.StartingSide), scoreA, scoreboardWidth/2-40-textWidth(fonts.title, scoreA), 84)
+	drawText(img, fonts.title, scoreboardMuted, ":", scoreboardWidth/2-textWidth(fonts.title, ":")/2, 84)
+	drawText(img, fonts.title, sideColor(stats.TeamB.StartingSide), scoreB, scoreboardWidth/2+40, 84)
+
+	y := scoreboardHeader + scoreboardPadding
+	for _, key := range teams {
+		team := stats.Team(key)
+		teamColor := sideColor(team.StartingSide)
+
+		fillRect(img, scoreboardPadding, y, scoreboardPadding+6, y+scoreboardTeamTitle-8, teamColor)
+		drawText(img, fonts.heading, teamColor,
+			fmt.Sprintf("%s — %d (%s start)", teamDisplayName(stats, key), team.Score, team.StartingSide),
+			scoreboardPadding+16, y+24)
+		y += scoreboardTeamTitle
+
+		drawText(img, fonts.heading, scoreboardMuted, "Player", scoreboardPadding+12, y+22)
+		for _, column := range scoreboardColumns {
+			drawText(img, fonts.heading, scoreboardMuted, column.title, column.right-textWidth(fonts.heading, column.title), y+22)
+		}
+		y += scoreboardRowHeight
+
+		for i, player := range teamPlayers(stats, key) {
+			background := scoreboardRowEven
+			if i%2 == 1 {
+				background = scoreboardRowOdd
+			}
+			if registered[player.SteamID] {
+				background = scoreboardHighlight
+			}
+			fillRect(img, scoreboardPadding, y, scoreboardWidth-scoreboardPadding, y+scoreboardRowHeight, background)
+
+			name := player.Name
+			if name == "" {
+				name = player.SteamID
+			}
+			drawText(img, fonts.body, scoreboardText, fitText(fonts.body, name, 370), scoreboardPadding+12, y+22)
+			for _, column := range scoreboardColumns {
+				value := column.value(player)
+				drawText(img, fonts.body, scoreboardText, value, column.right-textWidth(fonts.body, value), y+22)
+			}
+			y += scoreboardRowHeight
+		}
+		y += scoreboardTeamGap
+	}
+
+	return img, nil
+}
+
+func sideColor(side string) color.Color {
+	if side == SideT {
+		return scoreboardTColor
+	}
+	return scoreboardCTColor
+}
+
+func fillRect(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	draw.Draw(img, image.Rect(x0, y0, x1, y1), image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+// drawText draws s with its baseline at (x, y)
+func drawText(img *image.RGBA, face font.Face, c color.Color, s string, x, y int) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(s)
+}
+
+func textWidth(face font.Face, s string) int {
+	return font.MeasureString(face, s).Ceil()
+}
+
+// fitText shortens s with an ellipsis until it is at most maxWidth pixels wide
+func fitText(face font.Face, s string, maxWidth int) string {
+	if textWidth(face, s) <= maxWidth {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && textWidth(face, string(runes)+"…") > maxWidth {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
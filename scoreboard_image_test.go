@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// scoreboardStats is a finished match with optional stats, a long and a missing name
+func scoreboardStats() *MatchStats {
+	adr, rating, mvps := 94.5, 1.32, 3
+	return &MatchStats{
+		Map:   "de_mirage",
+		TeamA: TeamStats{Name: "Team A", Score: 13, StartingSide: SideCT},
+		TeamB: TeamStats{Name: "Team B", Score: 9, StartingSide: SideT},
+		Players: []PlayerStats{
+			{SteamID: "76561198000000001", Name: "registered", Team: TeamA, Kills: 24, Deaths: 15, Assists: 6, ADR: &adr, Rating: &rating, MVPs: &mvps},
+			{SteamID: "76561198000000002", Name: "a player name far too long to fit into its column", Team: TeamA, Kills: 17, Deaths: 16, Assists: 3},
+			{SteamID: "76561198000000003", Team: TeamB, Kills: 12, Deaths: 19, Assists: 8},
+			{SteamID: "76561198000000004", Name: "stranger", Team: TeamB, Kills: 20, Deaths: 18, Assists: 2},
+		},
+	}
+}
+
+func TestRenderScoreboardGolden(t *testing.T) {
+	tests := []struct {
+		name       string
+		stats      *MatchStats
+		registered map[string]bool
+	}{
+		{"scoreboard", scoreboardStats(), map[string]bool{"76561198000000001": true}},
+		{"scoreboard_unnamed_teams", &MatchStats{Map: "de_unknown", TeamA: TeamStats{StartingSide: SideT}, TeamB: TeamStats{StartingSide: SideCT}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := renderScoreboardPNG(tt.stats, tt.registered)
+			if err != nil {
+				t.Fatalf("renderScoreboardPNG: %v", err)
+			}
+
+			golden := filepath.Join("testdata", tt.name+".png")
+			if *updateGolden {
+				if err := os.WriteFile(golden, data, 0o644); err != nil {
+					t.Fatalf("failed to update %s: %v", golden, err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read %s (run with -update to create it): %v", golden, err)
+			}
+			if !samePixels(t, data, want) {
+				got := filepath.Join(t.TempDir(), tt.name+".png")
+				os.WriteFile(got, data, 0o644)
+				t.Errorf("scoreboard differs from %s, rendered %s", golden, got)
+			}
+		})
+	}
+}
+
+func TestRenderScoreboardConcurrently(t *testing.T) {
+	stats := scoreboardStats()
+	want, err := renderScoreboardPNG(stats, nil)
+	if err != nil {
+		t.Fatalf("renderScoreboardPNG: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := renderScoreboardPNG(stats, nil)
+			if err != nil {
+				t.Errorf("renderScoreboardPNG: %v", err)
+				return
+			}
+			if !bytes.Equal(got, want) {
+				t.Error("concurrent render differs from a sequential one")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// samePixels compares two PNG images pixel by pixel
+func samePixels(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	imgA, err := png.Decode(bytes.NewReader(a))
+	if err != nil {
+		t.Fatalf("failed to decode rendered image: %v", err)
+	}
+	imgB, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("failed to decode golden image: %v", err)
+	}
+
+	bounds := imgA.Bounds()
+	if bounds != imgB.Bounds() {
+		return false
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !sameColor(imgA, imgB, x, y) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func sameColor(a, b image.Image, x, y int) bool {
+	r1, g1, b1, a1 := a.At(x, y).RGBA()
+	r2, g2, b2, a2 := b.At(x, y).RGBA()
+	return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
+}
@@ -0,0 +1,126 @@
+// Package sharecode decodes and encodes CS match share codes.
+//
+// A share code such as CSGO-GADqf-jjyJ8-cSP2r-smZRo-TO2xK is a base-57
+// encoding of the match ID, the outcome (reservation) ID and the GOTV
+// port token that identify a single match.
+package sharecode
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+const (
+	prefix     = "CSGO-"
+	dictionary = "ABCDEFGHJKLMNOPQRSTUVWXYZabcdefhijkmnopqrstuvwxyz23456789"
+	codeLength = 25
+	groupSize  = 5
+	byteLength = 18
+)
+
+var (
+	// ErrInvalidFormat is returned when a share code does not have the CSGO-XXXXX-XXXXX-XXXXX-XXXXX-XXXXX shape
+	ErrInvalidFormat = errors.New("share code must look like CSGO-XXXXX-XXXXX-XXXXX-XXXXX-XXXXX")
+	// ErrInvalidCharacter is returned when a share code contains a character outside the share code alphabet
+	ErrInvalidCharacter = errors.New("share code contains an invalid character")
+	// ErrOutOfRange is returned when a share code encodes a match or outcome ID above the
+	// int64 range, which no real match uses and which can't be stored in Postgres BIGINT columns
+	ErrOutOfRange = errors.New("share code encodes an out of range match ID")
+)
+
+// ShareCode holds the fields encoded in a match share code
+type ShareCode struct {
+	MatchID   uint64 `json:"match_id"`
+	OutcomeID uint64 `json:"outcome_id"`
+	Token     uint16 `json:"token"`
+}
+
+// Decode parses a share code into its match ID, outcome ID and token
+func Decode(code string) (*ShareCode, error) {
+	code = strings.TrimSpace(code)
+	if !strings.HasPrefix(code, prefix) {
+		return nil, ErrInvalidFormat
+	}
+
+	groups := strings.Split(strings.TrimPrefix(code, prefix), "-")
+	if len(groups) != codeLength/groupSize {
+		return nil, ErrInvalidFormat
+	}
+	for _, group := range groups {
+		if len(group) != groupSize {
+			return nil, ErrInvalidFormat
+		}
+	}
+	chars := strings.Join(groups, "")
+
+	// The first character is the least significant digit
+	total := new(big.Int)
+	base := big.NewInt(int64(len(dictionary)))
+	for i := len(chars) - 1; i >= 0; i-- {
+		digit := strings.IndexByte(dictionary, chars[i])
+		if digit < 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCharacter, chars[i])
+		}
+		total.Mul(total, base)
+		total.Add(total, big.NewInt(int64(digit)))
+	}
+
+	if total.BitLen() > byteLength*8 {
+		return nil, ErrInvalidFormat
+	}
+	raw := total.FillBytes(make([]byte, byteLength))
+
+	sc := &ShareCode{
+		MatchID:   binary.LittleEndian.Uint64(raw[0:8]),
+		OutcomeID: binary.LittleEndian.Uint64(raw[8:16]),
+		Token:     binary.LittleEndian.Uint16(raw[16:18]),
+	}
+	if sc.MatchID > math.MaxInt64 || sc.OutcomeID > math.MaxInt64 {
+		return nil, ErrOutOfRange
+	}
+
+	return sc, nil
+}
+
+// Encode builds the share code string for the given match fields
+func Encode(matchID, outcomeID uint64, token uint16) string {
+	raw := make([]byte, byteLength)
+	binary.LittleEndian.PutUint64(raw[0:8], matchID)
+	binary.LittleEndian.PutUint64(raw[8:16], outcomeID)
+	binary.LittleEndian.PutUint16(raw[16:18], token)
+
+	total := new(big.Int).SetBytes(raw)
+	base := big.NewInt(int64(len(dictionary)))
+	digit := new(big.Int)
+
+	chars := make([]byte, codeLength)
+	for i := range chars {
+		total.DivMod(total, base, digit)
+		chars[i] = dictionary[digit.Int64()]
+	}
+
+	var b strings.Builder
+	b.WriteString(prefix)
+	for i := 0; i < codeLength; i += groupSize {
+		if i > 0 {
+			b.WriteByte('-')
+		}
+		b.Write(chars[i : i+groupSize])
+	}
+	return b.String()
+}
+
+// String returns the canonical share code representation
+func (sc *ShareCode) String() string {
+	return Encode(sc.MatchID, sc.OutcomeID, sc.Token)
+}
+
+// Valid reports whether code is a well-formed share code
+func Valid(code string) bool {
+	_, err := Decode(code)
+	return err == nil
+}
@@ -0,0 +1,80 @@
+package sharecode
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	sc, err := Decode("CSGO-GADqf-jjyJ8-cSP2r-smZRo-TO2xK")
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	want := ShareCode{MatchID: 3230642215713767580, OutcomeID: 3230647599455273103, Token: 55788}
+	if *sc != want {
+		t.Errorf("Decode() = %+v, want %+v", *sc, want)
+	}
+	if got := sc.String(); got != "CSGO-GADqf-jjyJ8-cSP2r-smZRo-TO2xK" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestDecodeTrimsSpace(t *testing.T) {
+	if _, err := Decode("  CSGO-GADqf-jjyJ8-cSP2r-smZRo-TO2xK\n"); err != nil {
+		t.Errorf("Decode() error = %v", err)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want error
+	}{
+		{"empty", "", ErrInvalidFormat},
+		{"missing prefix", "GADqf-jjyJ8-cSP2r-smZRo-TO2xK", ErrInvalidFormat},
+		{"lowercase prefix", "csgo-GADqf-jjyJ8-cSP2r-smZRo-TO2xK", ErrInvalidFormat},
+		{"too few groups", "CSGO-GADqf-jjyJ8-cSP2r-smZRo", ErrInvalidFormat},
+		{"too many groups", "CSGO-GADqf-jjyJ8-cSP2r-smZRo-TO2xK-AAAAA", ErrInvalidFormat},
+		{"short group", "CSGO-GADq-jjyJ8-cSP2r-smZRo-TO2xKA", ErrInvalidFormat},
+		{"no separators", "CSGO-GADqfjjyJ8cSP2rsmZRoTO2xK", ErrInvalidFormat},
+		{"ambiguous character", "CSGO-GADqf-jjyJ8-cSP2r-smZRo-TO2x1", ErrInvalidCharacter},
+		{"symbol", "CSGO-GADqf-jjyJ8-cSP2r-smZRo-TO2x!", ErrInvalidCharacter},
+		{"above 144 bits", "CSGO-99999-99999-99999-99999-99999", ErrInvalidFormat},
+		{"match ID above int64", "CSGO-XtPRN-a6YPi-yNEdr-AAAAA-AAAAA", ErrOutOfRange},
+		{"outcome ID above int64", "CSGO-GYwYx-7PuoS-Re4q5-XOEhk-OijDA", ErrOutOfRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := Decode(tt.code)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Decode(%q) = %+v, %v, want error %v", tt.code, sc, err, tt.want)
+			}
+			if Valid(tt.code) {
+				t.Errorf("Valid(%q) = true", tt.code)
+			}
+		})
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	tests := []ShareCode{
+		{MatchID: 0, OutcomeID: 0, Token: 0},
+		{MatchID: 3230642215713767580, OutcomeID: 3230647599455273103, Token: 55788},
+		{MatchID: 1<<63 - 1, OutcomeID: 1<<63 - 1, Token: 1<<16 - 1},
+	}
+
+	for _, want := range tests {
+		code := Encode(want.MatchID, want.OutcomeID, want.Token)
+		got, err := Decode(code)
+		if err != nil {
+			t.Errorf("Decode(%q) error = %v", code, err)
+			continue
+		}
+		if *got != want {
+			t.Errorf("Decode(Encode(%+v)) = %+v", want, *got)
+		}
+	}
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"strings"
 
+	"cs-match-summary-bot/sharecode"
 	"github.com/bwmarrin/discordgo"
 	"github.com/google/uuid"
 )
@@ -15,43 +19,160 @@ func registerSlashCommands(s *discordgo.Session) error {
 	commands := []*discordgo.ApplicationCommand{
 		{
 			Name:        "register",
-			Description: "Register a new user with Steam ID, auth code, and last share code",
+			Description: "Privately register your Steam account for match summaries",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionUser,
+					Name:        "member",
+					Description: "Member to link the account to instead of yourself (requires Manage Server)",
+					Required:    false,
+				},
+			},
+		},
+		{
+			Name:        "remove",
+			Description: "Remove a user from this server (own accounts, or any with Manage Server)",
 			Options: []*discordgo.ApplicationCommandOption{
 				{
 					Type:        discordgo.ApplicationCommandOptionString,
 					Name:        "steam_id",
-					Description: "Your Steam ID",
+					Description: "Steam ID or profile URL of the user to remove",
 					Required:    true,
 				},
+			},
+		},
+		{
+			Name:        "users",
+			Description: "Show list of registered users",
+		},
+		{
+			Name:        "matches",
+			Description: "Browse the match history of this server",
+			Options: []*discordgo.ApplicationCommandOption{
 				{
 					Type:        discordgo.ApplicationCommandOptionString,
-					Name:        "auth_code",
-					Description: "Your Steam authentication code",
-					Required:    true,
+					Name:        "player",
+					Description: "Only matches with this Steam ID or profile URL",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionUser,
+					Name:        "member",
+					Description: "Only matches with a Steam account linked to this member",
+					Required:    false,
 				},
 				{
 					Type:        discordgo.ApplicationCommandOptionString,
-					Name:        "last_share_code",
-					Description: "Your last known CS match share code",
-					Required:    true,
+					Name:        "map",
+					Description: "Only matches on this map (e.g. mirage)",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "result",
+					Description: "Only wins, losses or ties (for the player, or any registered member)",
+					Required:    false,
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "Win", Value: ResultWin},
+						{Name: "Loss", Value: ResultLoss},
+						{Name: "Tie", Value: ResultTie},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "from",
+					Description: "Only matches recorded on or after this date (YYYY-MM-DD, UTC)",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "to",
+					Description: "Only matches recorded on or before this date (YYYY-MM-DD, UTC)",
+					Required:    false,
 				},
 			},
 		},
 		{
-			Name:        "remove",
-			Description: "Remove a user from the system",
+			Name:        "profile",
+			Description: "Show lifetime stats and recent form of a player",
 			Options: []*discordgo.ApplicationCommandOption{
 				{
 					Type:        discordgo.ApplicationCommandOptionString,
 					Name:        "steam_id",
-					Description: "Steam ID of the user to remove",
-					Required:    true,
+					Description: "Steam ID or profile URL of the player",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionUser,
+					Name:        "member",
+					Description: "Discord member whose linked Steam accounts to show",
+					Required:    false,
 				},
 			},
 		},
 		{
-			Name:        "users",
-			Description: "Show list of registered users",
+			Name:        "leaderboard",
+			Description: "Rank this server's registered players",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "metric",
+					Description: "Stat to rank by (default: rating)",
+					Required:    false,
+					Choices:     leaderboardChoices(leaderboardMetrics),
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "window",
+					Description: "Time window, by the date matches were recorded (default: last 30 days)",
+					Required:    false,
+					Choices:     leaderboardChoices(leaderboardWindows),
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "min_matches",
+					Description: "Minimum parsed matches to be ranked (default: 3)",
+					Required:    false,
+					MinValue:    &[]float64{1}[0],
+				},
+			},
+		},
+		{
+			Name:        "match",
+			Description: "Look up a tracked match",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "timeline",
+					Description: "Show the round-by-round timeline of a match",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "share_code",
+							Description: "Share code of the match",
+							Required:    true,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "status",
+					Description: "Show where a match is in the demo pipeline",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "share_code",
+							Description: "Share code of the match",
+							Required:    true,
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:                     "pipeline",
+			Description:              "List failed and stuck matches with retry buttons (Admin only)",
+			DefaultMemberPermissions: &[]int64{discordgo.PermissionManageGuild}[0],
 		},
 		{
 			Name:                     "set_channel",
@@ -84,6 +205,9 @@ func registerSlashCommands(s *discordgo.Session) error {
 
 // HandleSlashCommand handles incoming slash command interactions
 func handleSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
 	if i.ApplicationCommandData().Name == "" {
 		return
 	}
@@ -97,105 +221,66 @@ func handleSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		handleUsersSlashCommand(s, i)
 	case "set_channel":
 		handleSetChannelSlashCommand(s, i)
+	case "match":
+		handleMatchSlashCommand(s, i)
+	case "matches":
+		handleMatchesSlashCommand(s, i)
+	case "profile":
+		handleProfileSlashCommand(s, i)
+	case "leaderboard":
+		handleLeaderboardSlashCommand(s, i)
+	case "pipeline":
+		handlePipelineSlashCommand(s, i)
 	}
 }
 
-func handleRegisterSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	options := i.ApplicationCommandData().Options
-	
-	var steamID, authCode, lastShareCode string
-	for _, option := range options {
-		switch option.Name {
-		case "steam_id":
-			steamID = option.StringValue()
-		case "auth_code":
-			authCode = option.StringValue()
-		case "last_share_code":
-			lastShareCode = option.StringValue()
-		}
-	}
-
-	// Basic validation
-	if steamID == "" || authCode == "" || lastShareCode == "" {
-		respondWithError(s, i, "All fields are required")
-		return
-	}
-
-	// Validate share code format
-	if !strings.HasPrefix(lastShareCode, "CSGO-") {
-		respondWithError(s, i, "Invalid share code format. Must start with 'CSGO-'")
-		return
-	}
-
-	// Ensure guild exists
-	_, err := ensureGuildExists(i.GuildID)
-	if err != nil {
-		log.Printf("Error ensuring guild exists: %v", err)
-		respondWithError(s, i, "Failed to process guild")
-		return
-	}
-
-	// Check if user already exists
-	existingUser, err := getUserBySteamID(steamID)
-	if err != nil && err != sql.ErrNoRows {
-		log.Printf("Error checking existing user: %v", err)
-		respondWithError(s, i, "Failed to check user registration")
-		return
-	}
-
-	if err != sql.ErrNoRows {
-		// User exists, update their info
-		existingUser.AuthCode = authCode
-		existingUser.LastShareCode = lastShareCode
-		err = updateUser(existingUser)
-		if err != nil {
-			log.Printf("Error updating user: %v", err)
-			respondWithError(s, i, "Failed to update user")
-			return
-		}
-
-		// Add user to guild if not already added
-		err = addUserToGuild(i.GuildID, existingUser.UUID)
-		if err != nil {
-			log.Printf("Error adding user to guild: %v", err)
-		}
-
-		respondWithSuccess(s, i, fmt.Sprintf("✅ User updated successfully!\n**Steam ID:** %s\n**Last Share Code:** %s", steamID, lastShareCode))
-		return
-	}
-
-	// Create new user
-	user, err := createUser(steamID, authCode, lastShareCode)
-	if err != nil {
-		log.Printf("Error creating user: %v", err)
-		respondWithError(s, i, "Failed to create user")
+// HandleComponentInteraction handles button, other message component and modal interactions.
+// Custom IDs are prefixed with the feature they belong to, e.g. "matches|...".
+func handleComponentInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var customID string
+	switch i.Type {
+	case discordgo.InteractionMessageComponent:
+		customID = i.MessageComponentData().CustomID
+	case discordgo.InteractionModalSubmit:
+		customID = i.ModalSubmitData().CustomID
+	default:
 		return
 	}
 
-	// Add user to guild
-	err = addUserToGuild(i.GuildID, user.UUID)
-	if err != nil {
-		log.Printf("Error adding user to guild: %v", err)
-		respondWithError(s, i, "User created but failed to add to guild")
-		return
+	prefix, _, _ := strings.Cut(customID, "|")
+	switch prefix {
+	case matchesCustomID:
+		handleMatchesPageButton(s, i)
+	case registerCustomID:
+		handleRegisterComponent(s, i)
+	case pipelineCustomID:
+		handlePipelineComponent(s, i)
 	}
-
-	respondWithSuccess(s, i, fmt.Sprintf("✅ User registered successfully!\n**Steam ID:** %s\n**UUID:** %s\n**Last Share Code:** %s", user.SteamID, user.UUID, user.LastShareCode))
 }
 
+// handleRemoveSlashCommand removes a user from the current guild. Members can remove
+// their own linked accounts, Manage Server admins can remove anyone from their guild.
 func handleRemoveSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	options := i.ApplicationCommandData().Options
 	if len(options) == 0 {
 		respondWithError(s, i, "Steam ID is required")
 		return
 	}
+	if i.Member == nil {
+		respondWithError(s, i, "Users can only be removed from within a server")
+		return
+	}
 
-	steamID := options[0].StringValue()
+	steamID, err := resolveSteamID(context.Background(), options[0].StringValue())
+	if err != nil {
+		respondWithError(s, i, fmt.Sprintf("Invalid Steam ID: %v", err))
+		return
+	}
 
 	// Check if user exists
-	_, err := getUserBySteamID(steamID)
+	user, err := getUserBySteamID(steamID)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, sql.ErrNoRows) {
 			respondWithError(s, i, "User not found")
 		} else {
 			log.Printf("Error checking user: %v", err)
@@ -204,15 +289,37 @@ func handleRemoveSlashCommand(s *discordgo.Session, i *discordgo.InteractionCrea
 		return
 	}
 
-	// Delete user
-	err = deleteUser(steamID)
+	guild, err := getGuildByGuildID(i.GuildID)
+	if err != nil || !slices.Contains(guild.UserIDs, user.UUID.String()) {
+		respondWithError(s, i, "User is not registered in this server")
+		return
+	}
+
+	actorID := interactionUserID(i)
+	var role string
+	switch {
+	case user.DiscordUserID != "" && user.DiscordUserID == actorID:
+		role = RemovalRoleOwner
+	case i.Member.Permissions&discordgo.PermissionManageGuild != 0:
+		role = RemovalRoleAdmin
+	default:
+		respondWithError(s, i, "You can only remove your own Steam accounts unless you have Manage Server")
+		return
+	}
+
+	deleted, err := removeUserFromGuild(i.GuildID, user, actorID, role)
 	if err != nil {
-		log.Printf("Error deleting user: %v", err)
+		log.Printf("Error removing user %s from guild %s: %v", steamID, i.GuildID, err)
 		respondWithError(s, i, "Failed to remove user")
 		return
 	}
+	log.Printf("User %s removed from guild %s by %s (%s), deleted globally: %t", steamID, i.GuildID, actorID, role, deleted)
 
-	respondWithSuccess(s, i, fmt.Sprintf("✅ User with Steam ID %s has been removed", steamID))
+	message := fmt.Sprintf("✅ User with Steam ID %s has been removed from this server", steamID)
+	if deleted {
+		message += " and deleted, as no other server tracks them"
+	}
+	respondWithSuccess(s, i, message)
 }
 
 func handleUsersSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
@@ -228,13 +335,12 @@ func handleUsersSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreat
 		return
 	}
 
-	var userInfo []string
-	userCount := 0
+	var users []*User
+	var steamIDs []string
 	maxUsers := 25 // Discord embed field limit
 
 	for _, userIDStr := range guild.UserIDs {
-		if userCount >= maxUsers {
-			userInfo = append(userInfo, fmt.Sprintf("... and %d more users", len(guild.UserIDs)-maxUsers))
+		if len(users) >= maxUsers {
 			break
 		}
 
@@ -248,8 +354,32 @@ func handleUsersSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreat
 			continue
 		}
 
-		userInfo = append(userInfo, fmt.Sprintf("• **%s** - Last: `%s`", user.SteamID, user.LastShareCode))
-		userCount++
+		users = append(users, user)
+		steamIDs = append(steamIDs, user.SteamID)
+	}
+
+	profiles := lookupSteamProfiles(context.Background(), steamIDs)
+	pollStates, err := getUserPollStates()
+	if err != nil {
+		log.Printf("Error getting user poll states: %v", err)
+	}
+
+	var userInfo []string
+	for _, user := range users {
+		line := fmt.Sprintf("• **%s** - Last: `%s`", user.SteamID, user.LastShareCode)
+		if name := personaName(profiles, user.SteamID, ""); name != "" {
+			line = fmt.Sprintf("• **%s** (`%s`) - Last: `%s`", name, user.SteamID, user.LastShareCode)
+		}
+		if user.DiscordUserID != "" {
+			line += fmt.Sprintf(" - <@%s>", user.DiscordUserID)
+		}
+		if state, ok := pollStates[user.UUID]; ok && state.Status == PollStatusInvalidAuthCode {
+			line += " - ⚠️ auth code rejected, `/register` again"
+		}
+		userInfo = append(userInfo, line)
+	}
+	if len(guild.UserIDs) > maxUsers {
+		userInfo = append(userInfo, fmt.Sprintf("... and %d more users", len(guild.UserIDs)-maxUsers))
 	}
 
 	embed := &discordgo.MessageEmbed{
@@ -276,7 +406,7 @@ func handleSetChannelSlashCommand(s *discordgo.Session, i *discordgo.Interaction
 	// Get channel from options or use current channel
 	var channelID string
 	options := i.ApplicationCommandData().Options
-	
+
 	if len(options) > 0 && options[0].ChannelValue(s) != nil {
 		channelID = options[0].ChannelValue(s).ID
 	} else {
@@ -294,6 +424,99 @@ func handleSetChannelSlashCommand(s *discordgo.Session, i *discordgo.Interaction
 	respondWithSuccess(s, i, fmt.Sprintf("✅ Bot notification channel updated to <#%s>", channelID))
 }
 
+func handleMatchSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	options := i.ApplicationCommandData().Options
+	if len(options) == 0 {
+		respondWithError(s, i, "A subcommand is required")
+		return
+	}
+
+	subcommand := options[0]
+	switch subcommand.Name {
+	case "timeline":
+		handleMatchTimelineSubcommand(s, i, subcommand.Options)
+	case "status":
+		handleMatchStatusSubcommand(s, i, subcommand.Options)
+	default:
+		respondWithError(s, i, "Unknown subcommand")
+	}
+}
+
+func handleMatchTimelineSubcommand(s *discordgo.Session, i *discordgo.InteractionCreate, options []*discordgo.ApplicationCommandInteractionDataOption) {
+	var shareCode string
+	for _, option := range options {
+		if option.Name == "share_code" {
+			shareCode = strings.TrimSpace(option.StringValue())
+		}
+	}
+
+	if _, err := sharecode.Decode(shareCode); err != nil {
+		respondWithError(s, i, fmt.Sprintf("Invalid share code: %v", err))
+		return
+	}
+
+	game, err := findGameByShareCode(shareCode)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(s, i, "Match not found")
+		} else {
+			log.Printf("Error getting game: %v", err)
+			respondWithError(s, i, "Failed to get match")
+		}
+		return
+	}
+
+	meta, err := getMatchMeta(game.UUID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(s, i, "This match has not been parsed yet")
+		} else {
+			log.Printf("Error getting match meta: %v", err)
+			respondWithError(s, i, "Failed to get match")
+		}
+		return
+	}
+
+	matchRounds, err := getMatchRounds(game.UUID)
+	if err != nil {
+		log.Printf("Error getting match rounds: %v", err)
+		respondWithError(s, i, "Failed to get match rounds")
+		return
+	}
+	if len(matchRounds) == 0 {
+		respondWithError(s, i, "No round data is available for this match")
+		return
+	}
+
+	rounds := make([]RoundResult, 0, len(matchRounds))
+	for _, round := range matchRounds {
+		rounds = append(rounds, round.RoundResult)
+	}
+
+	stats := &MatchStats{Map: meta.Map, TeamA: meta.TeamA, TeamB: meta.TeamB}
+	embed := &discordgo.MessageEmbed{
+		Title: truncate(fmt.Sprintf("🕒 %s — %d : %d", displayMapName(meta.Map), meta.TeamA.Score, meta.TeamB.Score), embedTitleLimit),
+		Description: fmt.Sprintf("**%s** (%s start) vs **%s** (%s start)\n\n%s",
+			teamDisplayName(stats, TeamA), meta.TeamA.StartingSide,
+			teamDisplayName(stats, TeamB), meta.TeamB.StartingSide,
+			formatRoundTimeline(rounds, meta.TeamA.StartingSide)),
+		Color: 0x0099ff,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: roundTimelineLegend,
+		},
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{embed},
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to match timeline command: %v", err)
+	}
+}
+
 func respondWithError(s *discordgo.Session, i *discordgo.InteractionCreate, message string) {
 	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
@@ -312,9 +535,34 @@ func respondWithSuccess(s *discordgo.Session, i *discordgo.InteractionCreate, me
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
 			Content: message,
+			// Mentions in confirmations are for display only
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
 		},
 	})
 	if err != nil {
 		log.Printf("Error responding with success: %v", err)
 	}
-}
\ No newline at end of file
+}
+
+// respondEphemeral replies with a message only the invoking user can see
+func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, data *discordgo.InteractionResponseData) {
+	data.Flags |= discordgo.MessageFlagsEphemeral
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: data,
+	})
+	if err != nil {
+		log.Printf("Error responding privately: %v", err)
+	}
+}
+
+// interactionUserID returns the Discord user who triggered an interaction, in a guild or a DM
+func interactionUserID(i *discordgo.InteractionCreate) string {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User.ID
+	}
+	if i.User != nil {
+		return i.User.ID
+	}
+	return ""
+}
@@ -1,56 +1,151 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"cs-match-summary-bot/demoservice"
+	"cs-match-summary-bot/steamapi"
+	"cs-match-summary-bot/webhooks"
+	"github.com/bwmarrin/discordgo"
+	"github.com/google/uuid"
 )
 
-// SteamAPIResponse represents the response from Steam API
-type SteamAPIResponse struct {
-	Result struct {
-		NextCode string `json:"nextcode"`
-	} `json:"result"`
-}
+// Polling defaults. Steam allows 100,000 Web API calls per key per day, so one
+// call per second with small bursts stays well inside the quota.
+const (
+	defaultPollInterval    = 10 * time.Second
+	defaultPollWorkers     = 8
+	defaultSteamAPIRate    = 1.0
+	defaultSteamAPIBurst   = 5
+	defaultSteamAPITimeout = 10 * time.Second
+	defaultMaxCodesPerUser = 10
+	// maxPollBackoff caps how long a failing user waits between polls
+	maxPollBackoff = time.Hour
+)
 
-// DemoServiceRequest represents the request to demo service
-type DemoServiceRequest struct {
-	WebhookURL string `json:"webhook_url"`
+// PollerConfig controls how often and how concurrently Steam is polled
+type PollerConfig struct {
+	Interval       time.Duration
+	Workers        int
+	RequestTimeout time.Duration
+	// MaxCodesPerUser caps how many new matches are followed per user in one cycle
+	MaxCodesPerUser int
+	// RatePerSecond and Burst size the token bucket shared by every Steam Web API call
+	RatePerSecond float64
+	Burst         int
 }
 
-// DemoServiceResponse represents the response from demo service
-type DemoServiceResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
+// PollerMetrics describes the most recent polling cycles
+type PollerMetrics struct {
+	Running          bool      `json:"running"`
+	CycleInProgress  bool      `json:"cycle_in_progress"`
+	Cycles           int64     `json:"cycles"`
+	SkippedCycles    int64     `json:"skipped_cycles"`
+	LastCycleStart   time.Time `json:"last_cycle_start"`
+	LastCycleSeconds float64   `json:"last_cycle_seconds"`
+	MaxCycleSeconds  float64   `json:"max_cycle_seconds"`
+	// LagSeconds is how much later the last cycle started than the interval called for
+	LagSeconds  float64 `json:"lag_seconds"`
+	UsersPolled int     `json:"users_polled"`
+	PollErrors  int     `json:"poll_errors"`
+	// UsersSkipped counts users that are suspended or backing off
+	UsersSkipped    int     `json:"users_skipped"`
+	NewMatches      int     `json:"new_matches"`
+	IntervalSeconds float64 `json:"interval_seconds"`
+	Workers         int     `json:"workers"`
 }
 
 // SteamPoller manages Steam API polling for all users
 type SteamPoller struct {
-	apiKey        string
-	webhookURL    string
-	parseURL      string
-	stopChan      chan bool
-	isRunning     bool
-	mutex         sync.RWMutex
-	processedCodes map[string]bool // Track processed share codes to avoid duplicates
+	steam      steamapi.Client
+	config     PollerConfig
+	webhookURL string
+	demos      demoservice.Client
+	signer     *webhooks.Signer
+	stopChan   chan bool
+	isRunning  bool
+	mutex      sync.RWMutex
+
+	// cycleRunning is set while a cycle is in flight so a slow cycle is never overlapped
+	cycleRunning atomic.Bool
+	metricsMutex sync.Mutex
+	metrics      PollerMetrics
 }
 
-// NewSteamPoller creates a new Steam API poller
-func NewSteamPoller() *SteamPoller {
-	apiKey := os.Getenv("STEAM_API_KEY")
-	if apiKey == "" {
-		log.Fatal("STEAM_API_KEY environment variable is required")
+// loadPollerConfig reads STEAM_POLL_INTERVAL, STEAM_POLL_WORKERS, STEAM_API_RATE,
+// STEAM_POLL_MAX_CODES, STEAM_API_BURST and STEAM_API_TIMEOUT, falling back to the defaults
+func loadPollerConfig() PollerConfig {
+	config := PollerConfig{
+		Interval:        defaultPollInterval,
+		Workers:         defaultPollWorkers,
+		RequestTimeout:  defaultSteamAPITimeout,
+		RatePerSecond:   defaultSteamAPIRate,
+		Burst:           defaultSteamAPIBurst,
+		MaxCodesPerUser: defaultMaxCodesPerUser,
+	}
+
+	if value := os.Getenv("STEAM_POLL_INTERVAL"); value != "" {
+		if interval, err := time.ParseDuration(value); err == nil && interval > 0 {
+			config.Interval = interval
+		} else {
+			log.Printf("Invalid STEAM_POLL_INTERVAL %q, using %s", value, config.Interval)
+		}
+	}
+	if value := os.Getenv("STEAM_POLL_WORKERS"); value != "" {
+		if workers, err := strconv.Atoi(value); err == nil && workers > 0 {
+			config.Workers = workers
+		} else {
+			log.Printf("Invalid STEAM_POLL_WORKERS %q, using %d", value, config.Workers)
+		}
+	}
+	if value := os.Getenv("STEAM_POLL_MAX_CODES"); value != "" {
+		if maxCodes, err := strconv.Atoi(value); err == nil && maxCodes > 0 {
+			config.MaxCodesPerUser = maxCodes
+		} else {
+			log.Printf("Invalid STEAM_POLL_MAX_CODES %q, using %d", value, config.MaxCodesPerUser)
+		}
+	}
+	if value := os.Getenv("STEAM_API_TIMEOUT"); value != "" {
+		if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+			config.RequestTimeout = timeout
+		} else {
+			log.Printf("Invalid STEAM_API_TIMEOUT %q, using %s", value, config.RequestTimeout)
+		}
+	}
+	if value := os.Getenv("STEAM_API_RATE"); value != "" {
+		if rate, err := strconv.ParseFloat(value, 64); err == nil && rate > 0 {
+			config.RatePerSecond = rate
+		} else {
+			log.Printf("Invalid STEAM_API_RATE %q, using %g", value, config.RatePerSecond)
+		}
+	}
+	if value := os.Getenv("STEAM_API_BURST"); value != "" {
+		if burst, err := strconv.Atoi(value); err == nil && burst > 0 {
+			config.Burst = burst
+		} else {
+			log.Printf("Invalid STEAM_API_BURST %q, using %d", value, config.Burst)
+		}
 	}
 
-	webhookURL := os.Getenv("WEBHOOK_BASE_URL")
-	if webhookURL == "" {
-		webhookURL = "https://cs-bot.simonfalke.com"
+	return config
+}
+
+// newDemoService returns the demo service client configured by the environment: the
+// in-process fake when DEMO_SERVICE_FAKE is set, the service at DEMO_PARSE_BASE_URL otherwise
+func newDemoService() demoservice.Client {
+	if fake, _ := strconv.ParseBool(os.Getenv("DEMO_SERVICE_FAKE")); fake {
+		log.Println("Using the fake demo service, matches are answered from fixtures")
+		fake := demoservice.NewFake(os.Getenv("DEMO_SERVICE_FIXTURES"))
+		fake.SteamIDs = demoservice.SplitSteamIDs(os.Getenv("DEMO_SERVICE_FAKE_STEAM_IDS"))
+		return fake
 	}
 
 	parseURL := os.Getenv("DEMO_PARSE_BASE_URL")
@@ -58,13 +153,48 @@ func NewSteamPoller() *SteamPoller {
 		parseURL = "https://cs-demo-parsing.simonfalke.com"
 	}
 
+	timeout := demoservice.DefaultTimeout
+	if value := os.Getenv("DEMO_SERVICE_TIMEOUT"); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil && parsed > 0 {
+			timeout = parsed
+		} else {
+			log.Printf("Invalid DEMO_SERVICE_TIMEOUT %q, using %s", value, timeout)
+		}
+	}
+
+	return demoservice.NewHTTPClient(parseURL, timeout)
+}
+
+// NewSteamPoller creates a new Steam API poller using the given Steam Web API client.
+// The client should already be rate limited, see steamapi.NewRateLimited. New matches
+// are requested from demos with callback URLs signed by signer.
+func NewSteamPoller(steam steamapi.Client, demos demoservice.Client, config PollerConfig, signer *webhooks.Signer) *SteamPoller {
+	webhookURL := os.Getenv("WEBHOOK_BASE_URL")
+	if webhookURL == "" {
+		webhookURL = defaultWebhookBaseURL(demos)
+	}
+
 	return &SteamPoller{
-		apiKey:         apiKey,
-		webhookURL:     webhookURL,
-		parseURL:       parseURL,
-		stopChan:       make(chan bool),
-		processedCodes: make(map[string]bool),
+		steam:      steam,
+		config:     config,
+		webhookURL: webhookURL,
+		demos:      demos,
+		signer:     signer,
+		stopChan:   make(chan bool),
+	}
+}
+
+// defaultWebhookBaseURL is where callbacks go when WEBHOOK_BASE_URL is unset: the
+// deployed bot, or this bot's webhook server when the demo service is faked
+func defaultWebhookBaseURL(demos demoservice.Client) string {
+	if _, fake := demos.(*demoservice.Fake); !fake {
+		return "https://cs-bot.simonfalke.com"
 	}
+	port := os.Getenv("WEBHOOK_PORT")
+	if port == "" {
+		port = "8080"
+	}
+	return "http://localhost:" + port
 }
 
 // Start begins the polling process
@@ -77,9 +207,13 @@ func (sp *SteamPoller) Start() {
 	sp.isRunning = true
 	sp.mutex.Unlock()
 
-	log.Println("Starting Steam API poller...")
-	
-	ticker := time.NewTicker(10 * time.Second)
+	log.Printf("Starting Steam API poller (every %s, %d workers)...", sp.config.Interval, sp.config.Workers)
+
+	// Cancelled on Stop so in-flight requests don't hold up shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ticker := time.NewTicker(sp.config.Interval)
 	defer ticker.Stop()
 
 	for {
@@ -88,7 +222,7 @@ func (sp *SteamPoller) Start() {
 			log.Println("Steam API poller stopped")
 			return
 		case <-ticker.C:
-			sp.pollAllUsers()
+			sp.startCycle(ctx)
 		}
 	}
 }
@@ -97,17 +231,39 @@ func (sp *SteamPoller) Start() {
 func (sp *SteamPoller) Stop() {
 	sp.mutex.Lock()
 	defer sp.mutex.Unlock()
-	
+
 	if !sp.isRunning {
 		return
 	}
-	
+
 	sp.isRunning = false
 	close(sp.stopChan)
 }
 
-// pollAllUsers polls Steam API for all registered users
-func (sp *SteamPoller) pollAllUsers() {
+// startCycle runs a polling cycle in the background unless the previous one is still running
+func (sp *SteamPoller) startCycle(ctx context.Context) {
+	if !sp.cycleRunning.CompareAndSwap(false, true) {
+		sp.metricsMutex.Lock()
+		sp.metrics.SkippedCycles++
+		sp.metricsMutex.Unlock()
+		log.Println("Previous Steam polling cycle still running, skipping this tick")
+		return
+	}
+
+	go func() {
+		defer sp.cycleRunning.Store(false)
+		sp.pollAllUsers(ctx)
+	}()
+}
+
+// pollAllUsers polls Steam API for all registered users using a bounded pool of workers
+func (sp *SteamPoller) pollAllUsers(ctx context.Context) {
+	started := time.Now()
+	polled, failed, skipped, found := 0, 0, 0, 0
+	defer func() {
+		sp.recordCycle(started, polled, failed, skipped, found)
+	}()
+
 	users, err := getAllUsers()
 	if err != nil {
 		log.Printf("Error getting users for polling: %v", err)
@@ -120,196 +276,335 @@ func (sp *SteamPoller) pollAllUsers() {
 
 	log.Printf("Polling Steam API for %d users...", len(users))
 
-	// Track which share codes we've seen in this polling cycle
-	currentCodes := make(map[string][]*User)
-
+	states, err := getUserPollStates()
+	if err != nil {
+		log.Printf("Error getting user poll states: %v", err)
+		states = make(map[uuid.UUID]*UserPollState)
+	}
+
+	// Every pending code of every user, oldest first
+	var pending []userCodes
+	var resultsMutex sync.Mutex
+
+	jobs := make(chan *User)
+	var wg sync.WaitGroup
+	for range min(sp.config.Workers, len(users)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for user := range jobs {
+				codes, err := sp.pollUserAPI(ctx, user)
+				if ctx.Err() == nil {
+					sp.updatePollState(user, states[user.UUID], err)
+				}
+
+				resultsMutex.Lock()
+				polled++
+				if err != nil {
+					failed++
+					log.Printf("Error polling for user %s: %v", user.SteamID, err)
+				}
+				if len(codes) > 0 {
+					log.Printf("Found %d new match(es) for user %s", len(codes), user.SteamID)
+					pending = append(pending, userCodes{user: user, codes: codes})
+				}
+				resultsMutex.Unlock()
+			}
+		}()
+	}
+
+feed:
 	for _, user := range users {
 		if user.LastShareCode == "" {
 			log.Printf("User %s has no last share code, skipping", user.SteamID)
 			continue
 		}
-
-		nextCode, err := sp.pollUserAPI(user)
-		if err != nil {
-			log.Printf("Error polling for user %s: %v", user.SteamID, err)
+		if !pollDue(states[user.UUID], started) {
+			skipped++
 			continue
 		}
-
-		if nextCode != "" && nextCode != "n/a" && nextCode != user.LastShareCode {
-			log.Printf("New match found for user %s: %s", user.SteamID, nextCode)
-			
-			// Group users by share code to avoid duplicate downloads
-			currentCodes[nextCode] = append(currentCodes[nextCode], user)
+		select {
+		case jobs <- user:
+		case <-ctx.Done():
+			break feed
 		}
 	}
+	close(jobs)
+	wg.Wait()
 
-	// Process each unique share code
-	for shareCode, usersWithCode := range currentCodes {
-		sp.processNewMatch(shareCode, usersWithCode)
+	if ctx.Err() != nil {
+		return
 	}
+
+	found = sp.enqueuePending(ctx, pending)
 }
 
-// pollUserAPI polls Steam API for a specific user
-func (sp *SteamPoller) pollUserAPI(user *User) (string, error) {
-	url := fmt.Sprintf(
-		"https://api.steampowered.com/ICSGOPlayers_730/GetNextMatchSharingCode/v1?key=%s&steamid=%s&steamidkey=%s&knowncode=%s",
-		sp.apiKey,
-		user.SteamID,
-		user.AuthCode,
-		user.LastShareCode,
-	)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", fmt.Errorf("HTTP request failed: %w", err)
+// userCodes holds the share codes found for a user, in the order they were played
+type userCodes struct {
+	user  *User
+	codes []string
+}
+
+// enqueuePending requests every pending match once, in order per user, and advances
+// each user's last share code up to the last match that was enqueued. A code whose job
+// can't be recorded stops the user there so it and everything after it are retried
+// next cycle.
+// It returns the number of distinct matches that were enqueued.
+func (sp *SteamPoller) enqueuePending(ctx context.Context, pending []userCodes) int {
+	// Outcome of each code, so a match shared by several users is only requested once
+	enqueued := make(map[string]bool)
+
+	// Everyone whose polling found each code, recorded on the job
+	players := make(map[string][]string)
+	for _, entry := range pending {
+		for _, shareCode := range entry.codes {
+			players[shareCode] = append(players[shareCode], entry.user.SteamID)
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("Steam API returned status %d", resp.StatusCode)
+	for _, entry := range pending {
+		cursor := ""
+		for _, shareCode := range entry.codes {
+			ok, seen := enqueued[shareCode]
+			if !seen {
+				err := sp.processNewMatch(ctx, shareCode, players[shareCode])
+				if err != nil {
+					log.Printf("Error enqueueing match %s: %v", shareCode, err)
+				}
+				ok = err == nil
+				enqueued[shareCode] = ok
+			}
+			if !ok {
+				break
+			}
+			cursor = shareCode
+		}
+
+		if cursor == "" {
+			continue
+		}
+		err := updateUserLastShareCode(entry.user.SteamID, cursor)
+		if err != nil {
+			log.Printf("Error updating last share code for user %s: %v", entry.user.SteamID, err)
+		}
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
+	count := 0
+	for _, ok := range enqueued {
+		if ok {
+			count++
+		}
 	}
+	return count
+}
 
-	var apiResp SteamAPIResponse
-	err = json.Unmarshal(body, &apiResp)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse JSON response: %w", err)
+// pollUserAPI follows a user's share codes from their last known code until Steam has no
+// newer match or the per-cycle cap is reached, giving up after the configured request
+// timeout per call. The wait for a rate limit token counts towards the timeout. Codes
+// found before an error are returned together with the error.
+func (sp *SteamPoller) pollUserAPI(ctx context.Context, user *User) ([]string, error) {
+	var codes []string
+	knownCode := user.LastShareCode
+	for len(codes) < sp.config.MaxCodesPerUser {
+		nextCode, err := sp.nextShareCode(ctx, user, knownCode)
+		if errors.Is(err, steamapi.ErrMatchNotReady) {
+			// Steam knows about a newer match but can't hand it out yet, ask again next cycle
+			return codes, nil
+		}
+		if err != nil {
+			return codes, err
+		}
+		if nextCode == "" || nextCode == "n/a" || nextCode == knownCode {
+			return codes, nil
+		}
+		codes = append(codes, nextCode)
+		knownCode = nextCode
 	}
 
-	return apiResp.Result.NextCode, nil
+	log.Printf("User %s has more than %d new matches, continuing next cycle", user.SteamID, sp.config.MaxCodesPerUser)
+	return codes, nil
 }
 
-// processNewMatch processes a new match found for users
-func (sp *SteamPoller) processNewMatch(shareCode string, users []*User) {
-	// Check if we've already processed this share code
-	sp.mutex.RLock()
-	if sp.processedCodes[shareCode] {
-		sp.mutex.RUnlock()
-		log.Printf("Share code %s already processed, skipping", shareCode)
-		return
+// pollDue reports whether a user should be polled in the cycle started at now
+func pollDue(state *UserPollState, now time.Time) bool {
+	if state == nil {
+		return true
 	}
-	sp.mutex.RUnlock()
-
-	// Mark as processed
-	sp.mutex.Lock()
-	sp.processedCodes[shareCode] = true
-	sp.mutex.Unlock()
+	if state.Status == PollStatusInvalidAuthCode {
+		return false
+	}
+	return state.NextPollAt == nil || !now.Before(*state.NextPollAt)
+}
 
-	log.Printf("Processing new match %s for %d users", shareCode, len(users))
+// pollBackoff doubles the wait after every consecutive failure, starting at one interval
+func (sp *SteamPoller) pollBackoff(failures int) time.Duration {
+	backoff := sp.config.Interval
+	for n := 1; n < failures && backoff < maxPollBackoff; n++ {
+		backoff *= 2
+	}
+	return min(backoff, maxPollBackoff)
+}
 
-	// Update last share code for all users
-	for _, user := range users {
-		err := updateUserLastShareCode(user.SteamID, shareCode)
-		if err != nil {
-			log.Printf("Error updating last share code for user %s: %v", user.SteamID, err)
+// updatePollState records the outcome of polling a user. Users whose auth code Steam
+// rejects are suspended and told to register again; other errors back off exponentially.
+func (sp *SteamPoller) updatePollState(user *User, previous *UserPollState, pollErr error) {
+	now := time.Now()
+	state := &UserPollState{UserUUID: user.UUID, Status: PollStatusActive}
+	if previous != nil {
+		*state = *previous
+	}
+
+	switch {
+	case pollErr == nil:
+		state.Status = PollStatusActive
+		state.ConsecutiveFailures = 0
+		state.LastSuccessAt = &now
+		state.LastError = ""
+		state.NextPollAt = nil
+	case errors.Is(pollErr, steamapi.ErrInvalidAuthCode):
+		state.Status = PollStatusInvalidAuthCode
+		state.ConsecutiveFailures++
+		state.LastError = pollErr.Error()
+		state.NextPollAt = nil
+		log.Printf("Suspending polling for user %s: %v", user.SteamID, pollErr)
+
+		if state.NotifiedAt == nil {
+			if err := notifyInvalidAuthCode(user); err != nil {
+				log.Printf("Error notifying user %s about their auth code: %v", user.SteamID, err)
+			} else {
+				state.NotifiedAt = &now
+			}
 		}
+	default:
+		state.Status = PollStatusBackoff
+		state.ConsecutiveFailures++
+		state.LastError = pollErr.Error()
+		next := now.Add(sp.pollBackoff(state.ConsecutiveFailures))
+		state.NextPollAt = &next
 	}
 
-	// Request demo download (only once per share code)
-	err := sp.requestDemoDownload(shareCode)
-	if err != nil {
-		log.Printf("Error requesting demo download for %s: %v", shareCode, err)
-		// Remove from processed codes so we can retry later
-		sp.mutex.Lock()
-		delete(sp.processedCodes, shareCode)
-		sp.mutex.Unlock()
-		return
+	if err := upsertUserPollState(state); err != nil {
+		log.Printf("Error storing poll state for user %s: %v", user.SteamID, err)
 	}
+}
 
-	log.Printf("Successfully requested demo download for %s", shareCode)
+// nextShareCode asks Steam for the code following knownCode
+func (sp *SteamPoller) nextShareCode(ctx context.Context, user *User, knownCode string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, sp.config.RequestTimeout)
+	defer cancel()
+	return sp.steam.GetNextMatchSharingCode(ctx, user.SteamID, user.AuthCode, knownCode)
 }
 
-// requestDemoDownload requests demo download from the demo service
-func (sp *SteamPoller) requestDemoDownload(shareCode string) error {
-	url := fmt.Sprintf("%s/getDemo/%s", sp.parseURL, shareCode)
-	
-	requestBody := DemoServiceRequest{
-		WebhookURL: fmt.Sprintf("%s/webhooks/demoReady", sp.webhookURL),
-	}
+// recordCycle updates the metrics after a cycle that started at started
+func (sp *SteamPoller) recordCycle(started time.Time, polled, failed, skipped, found int) {
+	duration := time.Since(started)
 
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request body: %w", err)
-	}
+	sp.metricsMutex.Lock()
+	defer sp.metricsMutex.Unlock()
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return fmt.Errorf("HTTP request failed: %w", err)
+	lag := time.Duration(0)
+	if !sp.metrics.LastCycleStart.IsZero() {
+		lag = max(0, started.Sub(sp.metrics.LastCycleStart)-sp.config.Interval)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("demo service returned status %d: %s", resp.StatusCode, string(body))
+	sp.metrics.Cycles++
+	sp.metrics.LastCycleStart = started
+	sp.metrics.LastCycleSeconds = duration.Seconds()
+	sp.metrics.MaxCycleSeconds = max(sp.metrics.MaxCycleSeconds, duration.Seconds())
+	sp.metrics.LagSeconds = lag.Seconds()
+	sp.metrics.UsersPolled = polled
+	sp.metrics.PollErrors = failed
+	sp.metrics.UsersSkipped = skipped
+	sp.metrics.NewMatches = found
+
+	if duration > sp.config.Interval {
+		log.Printf("Steam polling cycle took %s, longer than the %s interval", duration.Round(time.Millisecond), sp.config.Interval)
 	}
+}
+
+// Metrics returns a snapshot of the polling metrics
+func (sp *SteamPoller) Metrics() PollerMetrics {
+	sp.metricsMutex.Lock()
+	metrics := sp.metrics
+	sp.metricsMutex.Unlock()
+
+	metrics.Running = sp.IsRunning()
+	metrics.CycleInProgress = sp.cycleRunning.Load()
+	metrics.IntervalSeconds = sp.config.Interval.Seconds()
+	metrics.Workers = sp.config.Workers
+	return metrics
+}
 
-	var serviceResp DemoServiceResponse
-	body, err := io.ReadAll(resp.Body)
+// processNewMatch records a new match of the given registered players in match_jobs and
+// requests its demo unless it was already recorded. The insert is atomic, so across
+// restarts and bot replicas only one poller requests each demo. Once the job exists the
+// match is durably enqueued: a failed request, including one cut short by the timeout or
+// by the poller stopping, is retried by the reconciler, so a nil error is returned.
+func (sp *SteamPoller) processNewMatch(ctx context.Context, shareCode string, steamIDs []string) error {
+	claimed, err := claimMatchJob(shareCode, steamIDs, matchJobLease)
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
+		return err
 	}
+	if !claimed {
+		log.Printf("Share code %s already processed, skipping", shareCode)
+		return nil
+	}
+
+	log.Printf("Processing new match %s", shareCode)
 
-	err = json.Unmarshal(body, &serviceResp)
+	// Request demo download (only once per share code)
+	requestCtx, cancel := context.WithTimeout(ctx, matchJobStepTimeout)
+	err = sp.requestDemoDownload(requestCtx, shareCode)
+	cancel()
 	if err != nil {
-		return fmt.Errorf("failed to parse JSON response: %w", err)
+		recordMatchJobError(shareCode, 1, fmt.Errorf("failed to request demo download: %w", err))
+		return nil
 	}
 
-	if !serviceResp.Success {
-		return fmt.Errorf("demo service returned error: %s", serviceResp.Message)
+	_, err = advanceMatchJobTo(shareCode, MatchJobDownloadRequested)
+	if err != nil {
+		// The job stays discovered and the reconciler requests the demo again
+		log.Printf("Error recording demo download request for %s: %v", shareCode, err)
+		return nil
 	}
 
+	log.Printf("Successfully requested demo download for %s", shareCode)
 	return nil
 }
 
-// requestDemoParsing requests demo parsing from the demo service
-func (sp *SteamPoller) requestDemoParsing(shareCode string) error {
-	url := fmt.Sprintf("%s/parseDemo/%s", sp.parseURL, shareCode)
-	
-	requestBody := DemoServiceRequest{
-		WebhookURL: fmt.Sprintf("%s/webhooks/demoParsed", sp.webhookURL),
-	}
-
-	jsonBody, err := json.Marshal(requestBody)
+// callbackURL returns a signed callback URL for the next callback of a job. Every
+// request gets a fresh nonce, so callbacks for earlier requests are rejected.
+func (sp *SteamPoller) callbackURL(stage, shareCode string) (string, error) {
+	nonce, err := newCallbackNonce()
 	if err != nil {
-		return fmt.Errorf("failed to marshal request body: %w", err)
+		return "", err
 	}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return fmt.Errorf("HTTP request failed: %w", err)
+	if err := setMatchJobCallbackNonce(shareCode, nonce); err != nil {
+		return "", err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("demo service returned status %d: %s", resp.StatusCode, string(body))
-	}
+	return sp.signer.CallbackURL(sp.webhookURL, stage, shareCode, nonce, time.Now().Add(callbackTTL)), nil
+}
 
-	var serviceResp DemoServiceResponse
-	body, err := io.ReadAll(resp.Body)
+// requestDemoDownload asks the demo service to download a demo and call back demoReady
+func (sp *SteamPoller) requestDemoDownload(ctx context.Context, shareCode string) error {
+	webhookURL, err := sp.callbackURL(webhooks.StageDemoReady, shareCode)
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
+		return err
 	}
 
-	err = json.Unmarshal(body, &serviceResp)
-	if err != nil {
-		return fmt.Errorf("failed to parse JSON response: %w", err)
-	}
+	return sp.demos.RequestDownload(ctx, shareCode, webhookURL)
+}
 
-	if !serviceResp.Success {
-		return fmt.Errorf("demo service returned error: %s", serviceResp.Message)
+// requestDemoParsing asks the demo service to parse a downloaded demo and call back demoParsed
+func (sp *SteamPoller) requestDemoParsing(ctx context.Context, shareCode string) error {
+	webhookURL, err := sp.callbackURL(webhooks.StageDemoParsed, shareCode)
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
-
-// GetDemoParsingRequest returns the demo parsing request function for webhook use
-func (sp *SteamPoller) GetDemoParsingRequest() func(string) error {
-	return sp.requestDemoParsing
+	return sp.demos.RequestParse(ctx, shareCode, webhookURL)
 }
 
 // IsRunning returns whether the poller is currently running
@@ -319,15 +614,59 @@ func (sp *SteamPoller) IsRunning() bool {
 	return sp.isRunning
 }
 
-// CleanupProcessedCodes removes old processed codes to prevent memory leaks
-func (sp *SteamPoller) CleanupProcessedCodes() {
-	sp.mutex.Lock()
-	defer sp.mutex.Unlock()
-	
-	// Keep only recent codes (last 1000)
-	if len(sp.processedCodes) > 1000 {
-		// Simple cleanup: clear all and start fresh
-		sp.processedCodes = make(map[string]bool)
-		log.Println("Cleaned up processed codes cache")
-	}
-}
\ No newline at end of file
+// notifyInvalidAuthCode asks the user to register again, by DM when their Steam account is
+// linked to a Discord member and otherwise in the match channel of every guild they are in
+func notifyInvalidAuthCode(user *User) error {
+	if webhookCtx == nil || webhookCtx.DiscordSession == nil {
+		return fmt.Errorf("discord session not available")
+	}
+	s := webhookCtx.DiscordSession
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "⚠️ Match tracking paused",
+		Description: fmt.Sprintf("Steam rejected the authentication code of `%s`, so new matches are no longer picked up. This happens when the code is revoked or a new one is created.\n\nCreate a new code on the [Steam help page](%s) and run `/register` again.", user.SteamID, authCodeHelpURL),
+		Color:       0xffa500,
+	}
+
+	if user.DiscordUserID != "" {
+		channel, err := s.UserChannelCreate(user.DiscordUserID)
+		if err == nil {
+			_, err = s.ChannelMessageSendEmbed(channel.ID, embed)
+		}
+		if err == nil {
+			return nil
+		}
+		log.Printf("Error sending DM to %s, falling back to guild channels: %v", user.DiscordUserID, err)
+	}
+
+	guilds, err := getGuildsForUser(user.UUID)
+	if err != nil {
+		return err
+	}
+
+	sent := 0
+	for _, guild := range guilds {
+		if guild.ChannelID == "" {
+			continue
+		}
+		message := &discordgo.MessageSend{
+			Embeds:          []*discordgo.MessageEmbed{embed},
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		}
+		if user.DiscordUserID != "" {
+			message.Content = fmt.Sprintf("<@%s>", user.DiscordUserID)
+			message.AllowedMentions.Users = []string{user.DiscordUserID}
+		}
+		_, err := s.ChannelMessageSendComplex(guild.ChannelID, message)
+		if err != nil {
+			log.Printf("Error notifying guild %s: %v", guild.GuildID, err)
+			continue
+		}
+		sent++
+	}
+
+	if sent == 0 {
+		return fmt.Errorf("no channel could be notified")
+	}
+	return nil
+}
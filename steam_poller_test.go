@@ -0,0 +1,647 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"cs-match-summary-bot/demoservice"
+	"cs-match-summary-bot/steamapi"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+// callbackNonces captures the nonces of the callback URLs the poller hands out, so
+// the webhooks can be expected to check and use them up
+type callbackNonces struct {
+	mu     sync.Mutex
+	nonces []string
+}
+
+// issued matches any nonce stored by setMatchJobCallbackNonce and remembers it
+func (n *callbackNonces) issued() sqlmock.Argument {
+	return nonceArg{n: n, index: -1}
+}
+
+// nth matches the nth nonce handed out
+func (n *callbackNonces) nth(index int) sqlmock.Argument {
+	return nonceArg{n: n, index: index}
+}
+
+type nonceArg struct {
+	n     *callbackNonces
+	index int
+}
+
+// Match implements sqlmock.Argument
+func (a nonceArg) Match(value driver.Value) bool {
+	nonce, ok := value.(string)
+	if !ok || nonce == "" {
+		return false
+	}
+	a.n.mu.Lock()
+	defer a.n.mu.Unlock()
+	if a.index < 0 {
+		// sqlmock may match the same call more than once
+		if last := len(a.n.nonces) - 1; last < 0 || a.n.nonces[last] != nonce {
+			a.n.nonces = append(a.n.nonces, nonce)
+		}
+		return true
+	}
+	return a.index < len(a.n.nonces) && a.n.nonces[a.index] == nonce
+}
+
+// fixtureStats loads the stats of the fake demo service's default fixture
+func fixtureStats(t *testing.T) *MatchStats {
+	t.Helper()
+	data, err := os.ReadFile("demoservice/fixtures/default.json")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	var fixture struct {
+		Stats MatchStats `json:"stats"`
+	}
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		t.Fatalf("failed to parse fixture: %v", err)
+	}
+	return &fixture.Stats
+}
+
+func TestDemoPipelineWithFakeDemoService(t *testing.T) {
+	mock := mockDB(t)
+	discord := newFakeDiscord(t)
+
+	server := httptest.NewServer(newWebhookRouter())
+	defer server.Close()
+	t.Setenv("WEBHOOK_BASE_URL", server.URL)
+
+	// The fake puts the registered player into the fixture's roster
+	registered := &User{UUID: uuid.New(), SteamID: "76561197960287930", AuthCode: "AAAA-AAAAA-AAAA", DiscordUserID: "111"}
+	fake := demoservice.NewFake("")
+	fake.Delay = 0
+	fake.SteamIDs = []string{registered.SteamID}
+
+	previous := steamPoller
+	steamPoller = NewSteamPoller(steamapi.NewFake(), fake, PollerConfig{}, testSigner)
+	t.Cleanup(func() { steamPoller = previous })
+
+	guild := &Guild{UUID: uuid.New(), GuildID: "guild-1", ChannelID: "channel-1", UserIDs: StringSlice{registered.UUID.String()}}
+	game := &Game{UUID: uuid.New(), ShareCode: testShareCode, MatchID: testMatchID, SteamIDs: StringSlice{}}
+	stats := fixtureStats(t)
+	stats.Players[0].SteamID = registered.SteamID
+	nonces := &callbackNonces{}
+
+	// Requesting the download hands out the first callback URL
+	mock.ExpectExec(`UPDATE match_jobs SET callback_nonce = \$2`).
+		WithArgs(testShareCode, nonces.issued()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// demoReady claims its URL, stores the demo and requests parsing with the second URL
+	mock.ExpectExec(`UPDATE match_jobs SET callback_nonce = ''`).
+		WithArgs(testShareCode, nonces.nth(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectGame(t, mock, game)
+	mock.ExpectExec(`UPDATE games\s+SET demo_name = \$2, steam_ids = \$3`).
+		WithArgs(game.UUID, "/demos/fixture_de_mirage.dem", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAdvance(mock, testShareCode, MatchJobDemoReady)
+	mock.ExpectExec(`UPDATE match_jobs SET callback_nonce = \$2`).
+		WithArgs(testShareCode, nonces.issued()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAdvance(mock, testShareCode, MatchJobParseRequested)
+
+	// demoParsed claims its URL, stores the stats, links the registered player and posts the summary
+	mock.ExpectExec(`UPDATE match_jobs SET callback_nonce = ''`).
+		WithArgs(testShareCode, nonces.nth(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectMatchJob(t, mock, testShareCode, MatchJobParseRequested, []string{registered.SteamID})
+	expectGame(t, mock, game)
+	expectSaveMatchStats(mock, stats)
+	mock.ExpectExec(`UPDATE games\s+SET demo_name = \$2, steam_ids = \$3`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE users\s+SET game_ids`).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectGuildsForUser(t, mock, registered, guild)
+	mock.ExpectExec(`UPDATE guilds\s+SET game_ids`).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAdvance(mock, testShareCode, MatchJobParsed)
+	expectNoSteamProfiles(mock)
+	expectMatchNotifications(mock, game)
+	expectRecordNotification(mock, game, guild.GuildID)
+	expectAdvance(mock, testShareCode, MatchJobNotified)
+
+	// Every player is looked up when linking the roster and again for the summary
+	for range 2 {
+		expectUser(t, mock, registered)
+		for _, player := range stats.Players[1:] {
+			expectNoUser(mock, player.SteamID)
+		}
+	}
+
+	if err := steamPoller.requestDemoDownload(context.Background(), testShareCode); err != nil {
+		t.Fatalf("requestDemoDownload: %v", err)
+	}
+	fake.Wait()
+
+	if fake.CallsFor("RequestDownload") != 1 || fake.CallsFor("RequestParse") != 1 {
+		t.Errorf("demo service calls = %d downloads, %d parses", fake.CallsFor("RequestDownload"), fake.CallsFor("RequestParse"))
+	}
+	messages := discord.sent()
+	if len(messages) != 1 || messages[0].ChannelID != guild.ChannelID || messages[0].Content != "<@111>" {
+		t.Fatalf("sent %+v, want the summary in the guild's channel", messages)
+	}
+}
+
+func TestDefaultWebhookBaseURL(t *testing.T) {
+	t.Setenv("WEBHOOK_PORT", "9090")
+
+	if got := defaultWebhookBaseURL(demoservice.NewFake("")); got != "http://localhost:9090" {
+		t.Errorf("with the fake demo service = %s, want the local webhook server", got)
+	}
+	if got := defaultWebhookBaseURL(demoservice.NewHTTPClient("http://localhost:8081", demoservice.DefaultTimeout)); got != "https://cs-bot.simonfalke.com" {
+		t.Errorf("with the demo service = %s, want the deployed bot", got)
+	}
+}
+
+// gatedSteam is a fake Steam Web API whose share code calls block until release is
+// closed, and which records how many of them ran at once
+type gatedSteam struct {
+	*steamapi.Fake
+	started chan string
+	release chan struct{}
+
+	mu        sync.Mutex
+	active    int
+	maxActive int
+}
+
+func newGatedSteam(users int) *gatedSteam {
+	return &gatedSteam{
+		Fake:    steamapi.NewFake(),
+		started: make(chan string, users),
+		release: make(chan struct{}),
+	}
+}
+
+// GetNextMatchSharingCode implements steamapi.Client
+func (g *gatedSteam) GetNextMatchSharingCode(ctx context.Context, steamID, authCode, knownCode string) (string, error) {
+	g.mu.Lock()
+	g.active++
+	g.maxActive = max(g.maxActive, g.active)
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		g.active--
+		g.mu.Unlock()
+	}()
+
+	g.started <- steamID
+	select {
+	case <-g.release:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	return g.Fake.GetNextMatchSharingCode(ctx, steamID, authCode, knownCode)
+}
+
+// pollUsers returns count registered users that are due for polling
+func pollUsers(count int) []*User {
+	users := make([]*User, count)
+	for n := range users {
+		users[n] = &User{
+			UUID:          uuid.New(),
+			SteamID:       fmt.Sprintf("765611980000000%02d", n+1),
+			AuthCode:      "AAAA-AAAAA-AAAA",
+			LastShareCode: testShareCode,
+		}
+	}
+	return users
+}
+
+// expectPollCycleStart makes getAllUsers return users and getUserPollStates find no states once
+func expectPollCycleStart(t *testing.T, mock sqlmock.Sqlmock, users ...*User) {
+	t.Helper()
+	rows := sqlmock.NewRows([]string{"uuid", "steam_id", "auth_code", "last_share_code", "game_ids", "discord_user_id", "created_at", "updated_at"})
+	for _, user := range users {
+		rows.AddRow(user.UUID.String(), user.SteamID, user.AuthCode, user.LastShareCode, jsonb(t, user.GameIDs), user.DiscordUserID, time.Now(), time.Now())
+	}
+	mock.ExpectQuery(`FROM users ORDER BY created_at`).WillReturnRows(rows)
+	mock.ExpectQuery(`FROM user_poll_states`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_uuid", "status", "consecutive_failures", "last_success_at", "last_error", "next_poll_at", "notified_at", "updated_at"}))
+}
+
+// expectPollState expects the poll state of user to be stored with status once
+func expectPollState(mock sqlmock.Sqlmock, user *User, status string) {
+	mock.ExpectExec(`INSERT INTO user_poll_states`).
+		WithArgs(user.UUID.String(), status, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// receive waits for the next value of ch
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case value := <-ch:
+		return value
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting")
+		panic("unreachable")
+	}
+}
+
+// waitForCycle waits until the running polling cycle of sp is done
+func waitForCycle(t *testing.T, sp *SteamPoller) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for sp.cycleRunning.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("polling cycle did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func testPollerConfig(workers int) PollerConfig {
+	return PollerConfig{Interval: time.Minute, Workers: workers, RequestTimeout: time.Minute, MaxCodesPerUser: 1}
+}
+
+func TestStartCycleSkipsWhileRunning(t *testing.T) {
+	mock := mockDB(t)
+	useTestKeyring(t)
+
+	users := pollUsers(1)
+	expectPollCycleStart(t, mock, users...)
+	expectPollState(mock, users[0], PollStatusActive)
+
+	steam := newGatedSteam(len(users))
+	sp := NewSteamPoller(steam, demoservice.NewFake(""), testPollerConfig(1), testSigner)
+	ctx := context.Background()
+
+	sp.startCycle(ctx)
+	receive(t, steam.started)
+
+	// The first cycle is stuck on Steam, so the next tick must not start another one
+	sp.startCycle(ctx)
+	metrics := sp.Metrics()
+	if !metrics.CycleInProgress || metrics.SkippedCycles != 1 {
+		t.Errorf("metrics = %+v, want a cycle in progress and one skipped", metrics)
+	}
+
+	close(steam.release)
+	waitForCycle(t, sp)
+
+	metrics = sp.Metrics()
+	if metrics.Cycles != 1 || metrics.UsersPolled != 1 || metrics.CycleInProgress {
+		t.Errorf("metrics = %+v, want one finished cycle that polled the user", metrics)
+	}
+}
+
+func TestPollAllUsersRespectsWorkerLimit(t *testing.T) {
+	mock := mockDB(t)
+	useTestKeyring(t)
+
+	users := pollUsers(5)
+	expectPollCycleStart(t, mock, users...)
+	for _, user := range users {
+		expectPollState(mock, user, PollStatusActive)
+	}
+
+	steam := newGatedSteam(len(users))
+	sp := NewSteamPoller(steam, demoservice.NewFake(""), testPollerConfig(2), testSigner)
+
+	sp.startCycle(context.Background())
+	receive(t, steam.started)
+	receive(t, steam.started)
+	select {
+	case steamID := <-steam.started:
+		t.Errorf("user %s was polled while both workers were busy", steamID)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(steam.release)
+	waitForCycle(t, sp)
+
+	if steam.maxActive != 2 {
+		t.Errorf("%d users were polled at once, want the 2 workers", steam.maxActive)
+	}
+	if calls := steam.Calls["GetNextMatchSharingCode"]; calls != len(users) {
+		t.Errorf("Steam was asked %d times, want once per user", calls)
+	}
+}
+
+// Share codes of three matches played one after the other after testShareCode
+var nextShareCodes = []string{
+	"CSGO-aaaaa-aaaaa-aaaaa-aaaaa-aaaaa",
+	"CSGO-bbbbb-bbbbb-bbbbb-bbbbb-bbbbb",
+	"CSGO-ccccc-ccccc-ccccc-ccccc-ccccc",
+}
+
+// chainedSteam returns a fake Steam Web API that hands out nextShareCodes to user in order
+func chainedSteam(user *User) *steamapi.Fake {
+	steam := steamapi.NewFake()
+	known := user.LastShareCode
+	for _, shareCode := range nextShareCodes {
+		steam.AddMatch(user.SteamID, known, shareCode)
+		known = shareCode
+	}
+	return steam
+}
+
+// expectClaim makes claimMatchJob report whether it inserted the job of shareCode once
+func expectClaim(mock sqlmock.Sqlmock, shareCode string, inserted bool) {
+	mock.ExpectQuery(`INSERT INTO match_jobs \(share_code, status, attempts, next_attempt_at, steam_ids\)`).
+		WithArgs(shareCode, MatchJobDiscovered, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"inserted"}).AddRow(inserted))
+}
+
+// expectLastShareCode expects the last share code of user to be moved to shareCode once
+func expectLastShareCode(mock sqlmock.Sqlmock, user *User, shareCode string) {
+	mock.ExpectExec(`UPDATE users\s+SET last_share_code = \$2`).
+		WithArgs(user.SteamID, shareCode).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestPollUserAPIFollowsCodesUntilNA(t *testing.T) {
+	user := pollUsers(1)[0]
+	steam := chainedSteam(user)
+	sp := NewSteamPoller(steam, demoservice.NewFake(""), testPollerConfig(1), testSigner)
+	sp.config.MaxCodesPerUser = 10
+
+	codes, err := sp.pollUserAPI(context.Background(), user)
+	if err != nil {
+		t.Fatalf("pollUserAPI() error = %v", err)
+	}
+	if fmt.Sprint(codes) != fmt.Sprint(nextShareCodes) {
+		t.Errorf("pollUserAPI() = %v, want %v", codes, nextShareCodes)
+	}
+	// The fourth call answers n/a
+	if calls := steam.Calls["GetNextMatchSharingCode"]; calls != 4 {
+		t.Errorf("Steam was asked %d times, want 4", calls)
+	}
+}
+
+func TestPollUserAPIStopsAtCap(t *testing.T) {
+	user := pollUsers(1)[0]
+	steam := chainedSteam(user)
+	sp := NewSteamPoller(steam, demoservice.NewFake(""), testPollerConfig(1), testSigner)
+	sp.config.MaxCodesPerUser = 2
+
+	codes, err := sp.pollUserAPI(context.Background(), user)
+	if err != nil {
+		t.Fatalf("pollUserAPI() error = %v", err)
+	}
+	if fmt.Sprint(codes) != fmt.Sprint(nextShareCodes[:2]) {
+		t.Errorf("pollUserAPI() = %v, want %v", codes, nextShareCodes[:2])
+	}
+	if calls := steam.Calls["GetNextMatchSharingCode"]; calls != 2 {
+		t.Errorf("Steam was asked %d times, want the cap of 2", calls)
+	}
+}
+
+func TestEnqueuePendingAdvancesCursor(t *testing.T) {
+	mock := mockDB(t)
+	users := pollUsers(2)
+
+	// Both users played the second match, which is only claimed once
+	for _, shareCode := range nextShareCodes {
+		expectClaim(mock, shareCode, false)
+	}
+	expectLastShareCode(mock, users[0], nextShareCodes[2])
+	expectLastShareCode(mock, users[1], nextShareCodes[1])
+
+	sp := NewSteamPoller(steamapi.NewFake(), demoservice.NewFake(""), testPollerConfig(1), testSigner)
+	found := sp.enqueuePending(context.Background(), []userCodes{
+		{user: users[0], codes: nextShareCodes},
+		{user: users[1], codes: nextShareCodes[1:2]},
+	})
+	if found != len(nextShareCodes) {
+		t.Errorf("enqueuePending() = %d, want %d", found, len(nextShareCodes))
+	}
+}
+
+func TestEnqueuePendingStopsAtFailure(t *testing.T) {
+	mock := mockDB(t)
+	user := pollUsers(1)[0]
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	t.Setenv("WEBHOOK_BASE_URL", server.URL)
+	demos := demoservice.NewFake("")
+	demos.Delay = 0
+
+	// The first match is requested, the second can't be recorded and the third is left for later
+	expectClaim(mock, nextShareCodes[0], true)
+	mock.ExpectExec(`UPDATE match_jobs SET callback_nonce = \$2`).
+		WithArgs(nextShareCodes[0], sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAdvance(mock, nextShareCodes[0], MatchJobDownloadRequested)
+	mock.ExpectQuery(`INSERT INTO match_jobs \(share_code, status, attempts, next_attempt_at, steam_ids\)`).
+		WithArgs(nextShareCodes[1], MatchJobDiscovered, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnError(errors.New("connection reset"))
+	expectLastShareCode(mock, user, nextShareCodes[0])
+
+	sp := NewSteamPoller(steamapi.NewFake(), demos, testPollerConfig(1), testSigner)
+	found := sp.enqueuePending(context.Background(), []userCodes{{user: user, codes: nextShareCodes}})
+	demos.Wait()
+
+	if found != 1 {
+		t.Errorf("enqueuePending() = %d, want only the first match", found)
+	}
+	if calls := demos.CallsFor("RequestDownload"); calls != 1 {
+		t.Errorf("demo service got %d download requests, want 1", calls)
+	}
+}
+
+// hangingDemos is a demo service that never answers before the request's context ends
+type hangingDemos struct{}
+
+// RequestDownload implements demoservice.Client
+func (hangingDemos) RequestDownload(ctx context.Context, shareCode, webhookURL string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// RequestParse implements demoservice.Client
+func (hangingDemos) RequestParse(ctx context.Context, shareCode, webhookURL string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestProcessNewMatchGivesUpWhenPollerStops(t *testing.T) {
+	mock := mockDB(t)
+	t.Setenv("WEBHOOK_BASE_URL", "http://bot.test")
+
+	// The job is recorded, and the cut short request is left to the reconciler
+	expectClaim(mock, testShareCode, true)
+	mock.ExpectExec(`UPDATE match_jobs SET callback_nonce = \$2`).
+		WithArgs(testShareCode, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE match_jobs\s+SET attempts = GREATEST\(attempts, \$2\)`).
+		WithArgs(testShareCode, 1, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	sp := NewSteamPoller(steamapi.NewFake(), hangingDemos{}, testPollerConfig(1), testSigner)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- sp.processNewMatch(ctx, testShareCode, []string{"76561198000000001"}) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("processNewMatch() error = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("processNewMatch() kept waiting after the poller stopped")
+	}
+}
+
+func TestPollBackoff(t *testing.T) {
+	sp := NewSteamPoller(steamapi.NewFake(), demoservice.NewFake(""), PollerConfig{Interval: 10 * time.Second}, testSigner)
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, 80 * time.Second},
+		{9, 2560 * time.Second},
+		// 5120s would be over the ceiling
+		{10, maxPollBackoff},
+		{1000, maxPollBackoff},
+	}
+	for _, tt := range tests {
+		if got := sp.pollBackoff(tt.failures); got != tt.want {
+			t.Errorf("pollBackoff(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+
+	// An interval above the ceiling is capped from the first failure
+	sp.config.Interval = 2 * time.Hour
+	if got := sp.pollBackoff(1); got != maxPollBackoff {
+		t.Errorf("pollBackoff(1) with a 2h interval = %s, want %s", got, maxPollBackoff)
+	}
+}
+
+// nullArg matches a nullable argument that is NULL, or set when set is true
+type nullArg struct {
+	set bool
+}
+
+// Match implements sqlmock.Argument
+func (a nullArg) Match(value driver.Value) bool {
+	return (value != nil) == a.set
+}
+
+func TestUpdatePollState(t *testing.T) {
+	notified := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name     string
+		linked   bool
+		previous *UserPollState
+		err      error
+		// The stored state
+		status       string
+		failures     int
+		nextPoll     bool
+		notifiedAt   bool
+		wantMessages int
+	}{
+		{
+			name:     "success after failures",
+			previous: &UserPollState{Status: PollStatusBackoff, ConsecutiveFailures: 3},
+			status:   PollStatusActive,
+		},
+		{
+			name:     "first failure",
+			err:      &steamapi.StatusError{StatusCode: http.StatusInternalServerError},
+			status:   PollStatusBackoff,
+			failures: 1,
+			nextPoll: true,
+		},
+		{
+			name:     "repeated failure",
+			previous: &UserPollState{Status: PollStatusBackoff, ConsecutiveFailures: 2},
+			err:      context.DeadlineExceeded,
+			status:   PollStatusBackoff,
+			failures: 3,
+			nextPoll: true,
+		},
+		{
+			name:         "rejected auth code of a linked user",
+			linked:       true,
+			err:          steamapi.ErrInvalidAuthCode,
+			status:       PollStatusInvalidAuthCode,
+			failures:     1,
+			notifiedAt:   true,
+			wantMessages: 1,
+		},
+		{
+			name:         "rejected auth code of an unlinked user",
+			err:          steamapi.ErrInvalidAuthCode,
+			status:       PollStatusInvalidAuthCode,
+			failures:     1,
+			notifiedAt:   true,
+			wantMessages: 1,
+		},
+		{
+			name:       "rejected auth code after the user was told",
+			linked:     true,
+			previous:   &UserPollState{Status: PollStatusInvalidAuthCode, ConsecutiveFailures: 1, NotifiedAt: &notified},
+			err:        steamapi.ErrInvalidAuthCode,
+			status:     PollStatusInvalidAuthCode,
+			failures:   2,
+			notifiedAt: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := mockDB(t)
+			discord := newFakeDiscord(t)
+
+			user := pollUsers(1)[0]
+			if tt.linked {
+				user.DiscordUserID = "111"
+			} else if tt.wantMessages > 0 {
+				// Without a Discord member to DM the guild channels are told instead
+				expectGuildsForUser(t, mock, user, &Guild{UUID: uuid.New(), GuildID: "guild-1", ChannelID: "channel-1"})
+			}
+			if tt.previous != nil {
+				tt.previous.UserUUID = user.UUID
+			}
+
+			mock.ExpectExec(`INSERT INTO user_poll_states`).
+				WithArgs(user.UUID.String(), tt.status, tt.failures, sqlmock.AnyArg(), sqlmock.AnyArg(), nullArg{tt.nextPoll}, nullArg{tt.notifiedAt}).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			sp := NewSteamPoller(steamapi.NewFake(), demoservice.NewFake(""), testPollerConfig(1), testSigner)
+			sp.updatePollState(user, tt.previous, tt.err)
+
+			if messages := discord.sent(); len(messages) != tt.wantMessages {
+				t.Errorf("sent %d messages, want %d: %+v", len(messages), tt.wantMessages, messages)
+			}
+		})
+	}
+}
+
+func TestPollUserAPIMatchNotReady(t *testing.T) {
+	user := pollUsers(1)[0]
+	steam := steamapi.NewFake()
+	steam.SetUserError(user.SteamID, steamapi.ErrMatchNotReady)
+	sp := NewSteamPoller(steam, demoservice.NewFake(""), testPollerConfig(1), testSigner)
+
+	// Steam answering 202 is not a failure, the match is picked up next cycle
+	codes, err := sp.pollUserAPI(context.Background(), user)
+	if err != nil || len(codes) != 0 {
+		t.Errorf("pollUserAPI() = %v, %v, want no codes and no error", codes, err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"cs-match-summary-bot/steamapi"
+	"cs-match-summary-bot/steamid"
+)
+
+const (
+	defaultSteamProfileTTL = 24 * time.Hour
+	// steamLookupTimeout keeps Steam Web API calls made while answering an
+	// interaction within Discord's three second response window
+	steamLookupTimeout = 2 * time.Second
+)
+
+// steamClient is the Steam Web API client shared by the poller and profile lookups
+var steamClient steamapi.Client
+
+// steamProfileTTL reads how long cached profiles stay fresh from STEAM_PROFILE_CACHE_TTL
+func steamProfileTTL() time.Duration {
+	if value := os.Getenv("STEAM_PROFILE_CACHE_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err == nil && ttl > 0 {
+			return ttl
+		}
+		log.Printf("Invalid STEAM_PROFILE_CACHE_TTL %q, using %s", value, defaultSteamProfileTTL)
+	}
+	return defaultSteamProfileTTL
+}
+
+// resolveSteamID normalizes any Steam ID format to SteamID64, resolving
+// steamcommunity.com/id/<vanity> URLs through the Steam Web API
+func resolveSteamID(ctx context.Context, input string) (string, error) {
+	normalized, err := steamid.Normalize(input)
+	if !errors.Is(err, steamid.ErrVanityURL) {
+		return normalized, err
+	}
+
+	vanity, ok := steamid.VanityName(input)
+	if !ok || steamClient == nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, steamLookupTimeout)
+	defer cancel()
+	resolved, err := steamClient.ResolveVanityURL(ctx, vanity)
+	if err != nil {
+		if errors.Is(err, steamapi.ErrVanityNotFound) {
+			return "", err
+		}
+		return "", fmt.Errorf("failed to resolve custom URL: %w", err)
+	}
+	return steamid.Normalize(resolved)
+}
+
+// lookupSteamProfiles returns the profiles of the given accounts, refreshing cache
+// entries older than the TTL. When Steam can't be reached stale entries are used,
+// so callers always get whatever is known and never an error.
+func lookupSteamProfiles(ctx context.Context, steamIDs []string) map[string]*SteamProfile {
+	profiles, err := getSteamProfiles(steamIDs)
+	if err != nil {
+		log.Printf("Error getting cached steam profiles: %v", err)
+		profiles = make(map[string]*SteamProfile)
+	}
+	if steamClient == nil {
+		return profiles
+	}
+
+	ttl := steamProfileTTL()
+	var stale []string
+	for _, steamID := range steamIDs {
+		if profile, ok := profiles[steamID]; !ok || time.Since(profile.FetchedAt) > ttl {
+			stale = append(stale, steamID)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, steamLookupTimeout)
+	defer cancel()
+	for start := 0; start < len(stale); start += steamapi.MaxSummaryIDs {
+		batch := stale[start:min(start+steamapi.MaxSummaryIDs, len(stale))]
+		summaries, err := steamClient.GetPlayerSummaries(ctx, batch)
+		if err != nil {
+			log.Printf("Error refreshing %d steam profiles: %v", len(batch), err)
+			break
+		}
+
+		for _, summary := range summaries {
+			profile := &SteamProfile{
+				SteamID:     summary.SteamID,
+				PersonaName: summary.PersonaName,
+				AvatarURL:   summary.AvatarURL,
+				ProfileURL:  summary.ProfileURL,
+				FetchedAt:   time.Now(),
+			}
+			if err := upsertSteamProfile(profile); err != nil {
+				log.Printf("Error caching steam profile %s: %v", profile.SteamID, err)
+			}
+			profiles[profile.SteamID] = profile
+		}
+	}
+
+	return profiles
+}
+
+// personaName returns the cached persona name of an account, or fallback when unknown
+func personaName(profiles map[string]*SteamProfile, steamID, fallback string) string {
+	if profile, ok := profiles[steamID]; ok && profile.PersonaName != "" {
+		return profile.PersonaName
+	}
+	return fallback
+}
+
+// withPersonaNames returns a copy of stats in which players without a name get their persona name
+func withPersonaNames(stats *MatchStats, profiles map[string]*SteamProfile) *MatchStats {
+	if stats == nil {
+		return nil
+	}
+	named := *stats
+	named.Players = make([]PlayerStats, len(stats.Players))
+	for n, player := range stats.Players {
+		if player.Name == "" {
+			player.Name = personaName(profiles, player.SteamID, "")
+		}
+		named.Players[n] = player
+	}
+	return &named
+}
+
+// summaryAvatar picks the avatar of the guild's best registered player by rating,
+// falling back to kills where ratings are missing
+func summaryAvatar(game *Game, stats *MatchStats, registered map[string]bool, profiles map[string]*SteamProfile) string {
+	candidates := game.SteamIDs
+	if stats != nil {
+		var best *PlayerStats
+		for n := range stats.Players {
+			player := &stats.Players[n]
+			if !registered[player.SteamID] {
+				continue
+			}
+			if best == nil || betterPlayer(player, best) {
+				best = player
+			}
+		}
+		candidates = nil
+		if best != nil {
+			candidates = []string{best.SteamID}
+		}
+	}
+
+	for _, steamID := range candidates {
+		if profile, ok := profiles[steamID]; ok && registered[steamID] && profile.AvatarURL != "" {
+			return profile.AvatarURL
+		}
+	}
+	return ""
+}
+
+// betterPlayer compares by rating when both players have one, otherwise by kills
+func betterPlayer(a, b *PlayerStats) bool {
+	if a.Rating != nil && b.Rating != nil {
+		return *a.Rating > *b.Rating
+	}
+	return a.Kills > b.Kills
+}
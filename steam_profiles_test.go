@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cs-match-summary-bot/steamapi"
+	"cs-match-summary-bot/steamid"
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// useFakeSteam replaces the Steam Web API client with a fake for the rest of the test
+func useFakeSteam(t *testing.T) *steamapi.Fake {
+	t.Helper()
+	fake := steamapi.NewFake()
+	previous := steamClient
+	steamClient = fake
+	t.Cleanup(func() { steamClient = previous })
+	return fake
+}
+
+// expectCachedProfiles makes getSteamProfiles return profiles once
+func expectCachedProfiles(mock sqlmock.Sqlmock, profiles ...*SteamProfile) {
+	rows := sqlmock.NewRows([]string{"steam_id", "persona_name", "avatar_url", "profile_url", "fetched_at"})
+	for _, profile := range profiles {
+		rows.AddRow(profile.SteamID, profile.PersonaName, profile.AvatarURL, profile.ProfileURL, profile.FetchedAt)
+	}
+	mock.ExpectQuery(`FROM steam_profiles WHERE steam_id = ANY\(\$1\)`).WillReturnRows(rows)
+}
+
+// expectUpsertProfile expects the profile of steamID to be cached as personaName
+func expectUpsertProfile(mock sqlmock.Sqlmock, steamID, personaName string) {
+	mock.ExpectExec(`INSERT INTO steam_profiles`).
+		WithArgs(steamID, personaName, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestResolveSteamID(t *testing.T) {
+	steam := useFakeSteam(t)
+	steam.AddVanity("gabelogannewell", "76561197960287930")
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		err   error
+	}{
+		{"SteamID64", "76561197960287930", "76561197960287930", nil},
+		{"Steam2", "STEAM_0:0:11101", "76561197960287930", nil},
+		{"vanity URL", "https://steamcommunity.com/id/gabelogannewell/", "76561197960287930", nil},
+		{"unknown vanity URL", "https://steamcommunity.com/id/nobody", "", steamapi.ErrVanityNotFound},
+		{"invalid", "gaben", "", steamid.ErrInvalidFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveSteamID(context.Background(), tt.input)
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("resolveSteamID(%q) = %q, %v, want %q, %v", tt.input, got, err, tt.want, tt.err)
+			}
+		})
+	}
+	if calls := steam.Calls["ResolveVanityURL"]; calls != 2 {
+		t.Errorf("ResolveVanityURL called %d times, want only for the vanity URLs", calls)
+	}
+}
+
+func TestResolveSteamIDWhenSteamFails(t *testing.T) {
+	steam := useFakeSteam(t)
+	steam.Err = errors.New("steam is down")
+
+	_, err := resolveSteamID(context.Background(), "https://steamcommunity.com/id/gabelogannewell")
+	if !errors.Is(err, steam.Err) || errors.Is(err, steamapi.ErrVanityNotFound) {
+		t.Errorf("error = %v, want the wrapped Steam failure", err)
+	}
+}
+
+func TestLookupSteamProfilesRefreshesStaleEntries(t *testing.T) {
+	mock := mockDB(t)
+	steam := useFakeSteam(t)
+	t.Setenv("STEAM_PROFILE_CACHE_TTL", "1h")
+
+	fresh := &SteamProfile{SteamID: "76561198000000001", PersonaName: "fresh", FetchedAt: time.Now().Add(-time.Minute)}
+	stale := &SteamProfile{SteamID: "76561198000000002", PersonaName: "old name", FetchedAt: time.Now().Add(-2 * time.Hour)}
+	steam.AddProfile(steamapi.PlayerSummary{SteamID: fresh.SteamID, PersonaName: "not refetched"})
+	steam.AddProfile(steamapi.PlayerSummary{SteamID: stale.SteamID, PersonaName: "new name"})
+	steam.AddProfile(steamapi.PlayerSummary{SteamID: "76561198000000003", PersonaName: "uncached"})
+
+	expectCachedProfiles(mock, fresh, stale)
+	expectUpsertProfile(mock, stale.SteamID, "new name")
+	expectUpsertProfile(mock, "76561198000000003", "uncached")
+
+	profiles := lookupSteamProfiles(context.Background(), []string{fresh.SteamID, stale.SteamID, "76561198000000003"})
+
+	want := map[string]string{fresh.SteamID: "fresh", stale.SteamID: "new name", "76561198000000003": "uncached"}
+	for steamID, name := range want {
+		if profile := profiles[steamID]; profile == nil || profile.PersonaName != name {
+			t.Errorf("profile of %s = %+v, want %q", steamID, profile, name)
+		}
+	}
+	if calls := steam.Calls["GetPlayerSummaries"]; calls != 1 {
+		t.Errorf("GetPlayerSummaries called %d times, want one batch", calls)
+	}
+}
+
+func TestLookupSteamProfilesFallsBackToStaleCache(t *testing.T) {
+	mock := mockDB(t)
+	steam := useFakeSteam(t)
+	steam.Err = errors.New("steam is down")
+	t.Setenv("STEAM_PROFILE_CACHE_TTL", "1h")
+
+	stale := &SteamProfile{SteamID: "76561198000000002", PersonaName: "old name", FetchedAt: time.Now().Add(-2 * time.Hour)}
+	expectCachedProfiles(mock, stale)
+
+	profiles := lookupSteamProfiles(context.Background(), []string{stale.SteamID, "76561198000000003"})
+
+	if profile := profiles[stale.SteamID]; profile == nil || profile.PersonaName != "old name" {
+		t.Errorf("stale profile = %+v, want the cached one", profile)
+	}
+	if profile, ok := profiles["76561198000000003"]; ok {
+		t.Errorf("unknown profile = %+v, want none", profile)
+	}
+}
@@ -0,0 +1,180 @@
+// Package steamapi is a small client for the Steam Web API calls the bot uses:
+// match sharing codes, vanity URL resolution and player summaries.
+package steamapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultBaseURL = "https://api.steampowered.com"
+	// MaxSummaryIDs is the number of Steam IDs GetPlayerSummaries accepts per request
+	MaxSummaryIDs = 100
+)
+
+var (
+	// ErrVanityNotFound is returned when no account uses the vanity name
+	ErrVanityNotFound = errors.New("no Steam account uses this custom URL")
+	// ErrInvalidAuthCode is returned by GetNextMatchSharingCode when Steam answers 412:
+	// the auth code is wrong or was revoked, or the known code doesn't belong to the account
+	ErrInvalidAuthCode = errors.New("Steam rejected the authentication code")
+	// ErrMatchNotReady is returned by GetNextMatchSharingCode when Steam answers 202:
+	// a newer match exists but is not available yet
+	ErrMatchNotReady = errors.New("next match is not available yet")
+)
+
+// StatusError is returned when the Steam Web API answers with a non-200 status
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Steam API returned status %d", e.StatusCode)
+}
+
+// PlayerSummary is the public profile of a Steam account
+type PlayerSummary struct {
+	SteamID     string `json:"steamid"`
+	PersonaName string `json:"personaname"`
+	ProfileURL  string `json:"profileurl"`
+	AvatarURL   string `json:"avatarfull"`
+}
+
+// Client is the subset of the Steam Web API used by the bot
+type Client interface {
+	// GetNextMatchSharingCode returns the share code of the match after knownCode,
+	// or "n/a" when there is no newer match yet. It fails with ErrInvalidAuthCode
+	// and ErrMatchNotReady for the corresponding Steam responses.
+	GetNextMatchSharingCode(ctx context.Context, steamID, authCode, knownCode string) (string, error)
+	// ResolveVanityURL returns the SteamID64 of a steamcommunity.com/id/<vanity> URL
+	ResolveVanityURL(ctx context.Context, vanity string) (string, error)
+	// GetPlayerSummaries returns the profiles of up to MaxSummaryIDs accounts.
+	// Accounts that do not exist are missing from the result.
+	GetPlayerSummaries(ctx context.Context, steamIDs []string) ([]PlayerSummary, error)
+}
+
+// HTTPClient calls the Steam Web API over HTTP
+type HTTPClient struct {
+	apiKey  string
+	baseURL string
+	http    *http.Client
+}
+
+// NewHTTPClient creates a Steam Web API client authenticated with apiKey
+func NewHTTPClient(apiKey string) *HTTPClient {
+	return &HTTPClient{
+		apiKey:  apiKey,
+		baseURL: defaultBaseURL,
+		http:    &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// GetNextMatchSharingCode implements Client
+func (c *HTTPClient) GetNextMatchSharingCode(ctx context.Context, steamID, authCode, knownCode string) (string, error) {
+	var resp struct {
+		Result struct {
+			NextCode string `json:"nextcode"`
+		} `json:"result"`
+	}
+	err := c.get(ctx, "/ICSGOPlayers_730/GetNextMatchSharingCode/v1", url.Values{
+		"steamid":    {steamID},
+		"steamidkey": {authCode},
+		"knowncode":  {knownCode},
+	}, &resp)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusPreconditionFailed:
+			return "", ErrInvalidAuthCode
+		case http.StatusAccepted:
+			return "", ErrMatchNotReady
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return resp.Result.NextCode, nil
+}
+
+// ResolveVanityURL implements Client
+func (c *HTTPClient) ResolveVanityURL(ctx context.Context, vanity string) (string, error) {
+	var resp struct {
+		Response struct {
+			Success int    `json:"success"`
+			SteamID string `json:"steamid"`
+		} `json:"response"`
+	}
+	err := c.get(ctx, "/ISteamUser/ResolveVanityURL/v1", url.Values{
+		"vanityurl": {vanity},
+		"url_type":  {"1"},
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	// success is 1 on a match and 42 when nothing matched
+	if resp.Response.Success != 1 || resp.Response.SteamID == "" {
+		return "", ErrVanityNotFound
+	}
+	return resp.Response.SteamID, nil
+}
+
+// GetPlayerSummaries implements Client
+func (c *HTTPClient) GetPlayerSummaries(ctx context.Context, steamIDs []string) ([]PlayerSummary, error) {
+	if len(steamIDs) == 0 {
+		return nil, nil
+	}
+	if len(steamIDs) > MaxSummaryIDs {
+		return nil, fmt.Errorf("at most %d Steam IDs per request, got %d", MaxSummaryIDs, len(steamIDs))
+	}
+
+	var resp struct {
+		Response struct {
+			Players []PlayerSummary `json:"players"`
+		} `json:"response"`
+	}
+	err := c.get(ctx, "/ISteamUser/GetPlayerSummaries/v2", url.Values{
+		"steamids": {strings.Join(steamIDs, ",")},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Response.Players, nil
+}
+
+// get calls a Web API method and decodes its JSON response into out
+func (c *HTTPClient) get(ctx context.Context, method string, params url.Values, out interface{}) error {
+	params.Set("key", c.apiKey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+method+"?"+params.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		// Strip the URL, it contains the API key and auth code
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+	return nil
+}
+
+var _ Client = (*HTTPClient)(nil)
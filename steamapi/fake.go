@@ -0,0 +1,114 @@
+package steamapi
+
+import (
+	"context"
+	"sync"
+)
+
+// Fake is an in-process Client for tests and local development. Share code
+// chains, vanity names and profiles are set up with the Add methods.
+type Fake struct {
+	mu        sync.Mutex
+	nextCodes map[string]string
+	vanities  map[string]string
+	profiles  map[string]PlayerSummary
+	userErrs  map[string]error
+	// Err, when set, is returned by every call
+	Err error
+	// Calls counts the calls made per method name
+	Calls map[string]int
+}
+
+// NewFake creates an empty fake Steam Web API
+func NewFake() *Fake {
+	return &Fake{
+		nextCodes: make(map[string]string),
+		vanities:  make(map[string]string),
+		profiles:  make(map[string]PlayerSummary),
+		userErrs:  make(map[string]error),
+		Calls:     make(map[string]int),
+	}
+}
+
+// AddMatch makes nextCode the match that follows knownCode for steamID
+func (f *Fake) AddMatch(steamID, knownCode, nextCode string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextCodes[steamID+"|"+knownCode] = nextCode
+}
+
+// SetUserError makes GetNextMatchSharingCode fail with err for steamID, e.g. with
+// ErrInvalidAuthCode or ErrMatchNotReady. A nil err clears it.
+func (f *Fake) SetUserError(steamID string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.userErrs, steamID)
+		return
+	}
+	f.userErrs[steamID] = err
+}
+
+// AddVanity makes vanity resolve to steamID
+func (f *Fake) AddVanity(vanity, steamID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.vanities[vanity] = steamID
+}
+
+// AddProfile adds a player summary
+func (f *Fake) AddProfile(profile PlayerSummary) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.profiles[profile.SteamID] = profile
+}
+
+// GetNextMatchSharingCode implements Client
+func (f *Fake) GetNextMatchSharingCode(ctx context.Context, steamID, authCode, knownCode string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["GetNextMatchSharingCode"]++
+	if f.Err != nil {
+		return "", f.Err
+	}
+	if err := f.userErrs[steamID]; err != nil {
+		return "", err
+	}
+	if next, ok := f.nextCodes[steamID+"|"+knownCode]; ok {
+		return next, nil
+	}
+	return "n/a", nil
+}
+
+// ResolveVanityURL implements Client
+func (f *Fake) ResolveVanityURL(ctx context.Context, vanity string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["ResolveVanityURL"]++
+	if f.Err != nil {
+		return "", f.Err
+	}
+	if steamID, ok := f.vanities[vanity]; ok {
+		return steamID, nil
+	}
+	return "", ErrVanityNotFound
+}
+
+// GetPlayerSummaries implements Client
+func (f *Fake) GetPlayerSummaries(ctx context.Context, steamIDs []string) ([]PlayerSummary, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["GetPlayerSummaries"]++
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	var summaries []PlayerSummary
+	for _, steamID := range steamIDs {
+		if profile, ok := f.profiles[steamID]; ok {
+			summaries = append(summaries, profile)
+		}
+	}
+	return summaries, nil
+}
+
+var _ Client = (*Fake)(nil)
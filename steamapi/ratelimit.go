@@ -0,0 +1,92 @@
+package steamapi
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimited wraps a Client so that every call, from any goroutine, draws
+// from one token bucket. Steam allows 100,000 Web API calls per key per day,
+// a little over one per second.
+type RateLimited struct {
+	client Client
+	bucket *tokenBucket
+}
+
+// NewRateLimited allows perSecond calls on average with bursts of up to burst calls
+func NewRateLimited(client Client, perSecond float64, burst int) *RateLimited {
+	return &RateLimited{
+		client: client,
+		bucket: newTokenBucket(perSecond, burst),
+	}
+}
+
+// GetNextMatchSharingCode implements Client
+func (r *RateLimited) GetNextMatchSharingCode(ctx context.Context, steamID, authCode, knownCode string) (string, error) {
+	if err := r.bucket.Wait(ctx); err != nil {
+		return "", err
+	}
+	return r.client.GetNextMatchSharingCode(ctx, steamID, authCode, knownCode)
+}
+
+// ResolveVanityURL implements Client
+func (r *RateLimited) ResolveVanityURL(ctx context.Context, vanity string) (string, error) {
+	if err := r.bucket.Wait(ctx); err != nil {
+		return "", err
+	}
+	return r.client.ResolveVanityURL(ctx, vanity)
+}
+
+// GetPlayerSummaries implements Client
+func (r *RateLimited) GetPlayerSummaries(ctx context.Context, steamIDs []string) ([]PlayerSummary, error) {
+	if err := r.bucket.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.client.GetPlayerSummaries(ctx, steamIDs)
+}
+
+var _ Client = (*RateLimited)(nil)
+
+// tokenBucket refills at rate tokens per second up to burst tokens
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(max(burst, 1)),
+		tokens: float64(max(burst, 1)),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a token is available or ctx is done
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package steamapi
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// tryWait takes a token if one is available within a few milliseconds
+func tryWait(b *tokenBucket) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	return b.Wait(ctx)
+}
+
+// rewind pretends the last refill of b happened d ago
+func rewind(b *tokenBucket, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.last = b.last.Add(-d)
+}
+
+func TestTokenBucketBurst(t *testing.T) {
+	b := newTokenBucket(1, 3)
+
+	for n := range 3 {
+		if err := tryWait(b); err != nil {
+			t.Fatalf("Wait() %d of the burst error = %v", n+1, err)
+		}
+	}
+	if err := tryWait(b); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() after the burst error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	b := newTokenBucket(10, 2)
+	for range 2 {
+		if err := tryWait(b); err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+	}
+
+	// A tenth of a second refills one token at ten per second
+	rewind(b, 100*time.Millisecond)
+	if err := tryWait(b); err != nil {
+		t.Fatalf("Wait() after refilling one token error = %v", err)
+	}
+	if err := tryWait(b); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() with the refilled token used error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// A long idle period refills no more than the burst
+	rewind(b, time.Hour)
+	for range 2 {
+		if err := tryWait(b); err != nil {
+			t.Fatalf("Wait() after idling error = %v", err)
+		}
+	}
+	if err := tryWait(b); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() beyond the burst after idling error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestTokenBucketWaitsForToken(t *testing.T) {
+	b := newTokenBucket(20, 1)
+	if err := tryWait(b); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+
+	started := time.Now()
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	// The next token is due 50ms after the first, allow for timer slack
+	if waited := time.Since(started); waited < 40*time.Millisecond {
+		t.Errorf("Wait() returned after %s, want about 50ms", waited)
+	}
+}
+
+func TestTokenBucketWaitCancelled(t *testing.T) {
+	b := newTokenBucket(0.001, 1)
+	if err := tryWait(b); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- b.Wait(ctx) }()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Wait() error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait() kept waiting after its context was cancelled")
+	}
+}
+
+func TestRateLimitedSharesBucket(t *testing.T) {
+	fake := NewFake()
+	fake.AddVanity("someone", "76561198000000001")
+	limited := NewRateLimited(fake, 0.001, 2)
+	ctx := context.Background()
+
+	if _, err := limited.GetNextMatchSharingCode(ctx, "76561198000000001", "AAAA-AAAAA-AAAA", "CSGO-GADqf-jjyJ8-cSP2r-smZRo-TO2xK"); err != nil {
+		t.Fatalf("GetNextMatchSharingCode() error = %v", err)
+	}
+	if _, err := limited.ResolveVanityURL(ctx, "someone"); err != nil {
+		t.Fatalf("ResolveVanityURL() error = %v", err)
+	}
+
+	// Both methods used up the burst, so the next call gives up without reaching Steam
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := limited.GetPlayerSummaries(waitCtx, []string{"76561198000000001"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetPlayerSummaries() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if fake.Calls["GetPlayerSummaries"] != 0 {
+		t.Errorf("GetPlayerSummaries reached the client %d times, want 0", fake.Calls["GetPlayerSummaries"])
+	}
+}
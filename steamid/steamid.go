@@ -0,0 +1,192 @@
+// Package steamid parses the Steam account identifier formats players paste
+// and normalizes them to SteamID64.
+//
+// Supported formats for an individual account:
+//
+//	76561197960287930                                SteamID64
+//	STEAM_0:0:11101 (or STEAM_1:0:11101)             Steam2
+//	[U:1:22202]                                      Steam3
+//	https://steamcommunity.com/profiles/76561197960287930/
+package steamid
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SteamID64 layout: account ID in the low 32 bits, then instance (20 bits),
+// account type (4 bits) and universe (8 bits)
+const (
+	universePublic     = 1
+	typeIndividual     = 1
+	instanceDesktop    = 1
+	accountIDMask      = 0xFFFFFFFF
+	instanceShift      = 32
+	typeShift          = 52
+	typeMask           = 0xF
+	universeShift      = 56
+	steamCommunityHost = "steamcommunity.com"
+)
+
+var (
+	// ErrInvalidFormat is returned when the input is not in any supported format
+	ErrInvalidFormat = errors.New("not a SteamID64, STEAM_0:X:Y, [U:1:N] or steamcommunity.com/profiles/ URL")
+	// ErrInvalidAccountType is returned for valid IDs that do not belong to an individual account in the public universe
+	ErrInvalidAccountType = errors.New("steam ID does not belong to an individual account")
+	// ErrVanityURL is returned for steamcommunity.com/id/<name> URLs, which have to be
+	// resolved through the Steam Web API; see VanityName
+	ErrVanityURL = errors.New("custom profile URLs have to be resolved, use the /profiles/ URL or the SteamID64")
+)
+
+var (
+	steam2Pattern = regexp.MustCompile(`^STEAM_([01]):([01]):(\d{1,10})$`)
+	steam3Pattern = regexp.MustCompile(`^\[([A-Za-z]):([0-5]):(\d{1,10})(?::\d+)?\]$`)
+	digitsPattern = regexp.MustCompile(`^\d{1,20}$`)
+)
+
+// SteamID is a 64-bit Steam account identifier
+type SteamID uint64
+
+// FromAccountID builds the SteamID64 of an individual public account
+func FromAccountID(accountID uint32) SteamID {
+	return SteamID(uint64(universePublic)<<universeShift |
+		uint64(typeIndividual)<<typeShift |
+		uint64(instanceDesktop)<<instanceShift |
+		uint64(accountID))
+}
+
+// Parse accepts any supported format and returns the SteamID of the individual account it names
+func Parse(input string) (SteamID, error) {
+	input = strings.TrimSpace(input)
+
+	if strings.Contains(input, steamCommunityHost) {
+		return parseProfileURL(input)
+	}
+
+	if m := steam2Pattern.FindStringSubmatch(input); m != nil {
+		// STEAM_X:Y:Z encodes the account ID as Z*2+Y; X is the universe,
+		// which older games reported as 0 for public accounts
+		z, err := strconv.ParseUint(m[3], 10, 32)
+		if err != nil || z > accountIDMask/2 {
+			return 0, ErrInvalidFormat
+		}
+		return checked(FromAccountID(uint32(z*2 + uint64(m[2][0]-'0'))))
+	}
+
+	if m := steam3Pattern.FindStringSubmatch(input); m != nil {
+		if m[1] != "U" || m[2] != "1" {
+			return 0, ErrInvalidAccountType
+		}
+		accountID, err := strconv.ParseUint(m[3], 10, 32)
+		if err != nil {
+			return 0, ErrInvalidFormat
+		}
+		return checked(FromAccountID(uint32(accountID)))
+	}
+
+	if digitsPattern.MatchString(input) {
+		id, err := strconv.ParseUint(input, 10, 64)
+		if err != nil {
+			return 0, ErrInvalidFormat
+		}
+		return checked(SteamID(id))
+	}
+
+	return 0, ErrInvalidFormat
+}
+
+// parseProfileURL extracts the SteamID64 from a steamcommunity.com/profiles/ URL
+func parseProfileURL(input string) (SteamID, error) {
+	kind, value, ok := profileURLSegments(input)
+	if !ok {
+		return 0, ErrInvalidFormat
+	}
+	switch kind {
+	case "profiles":
+		if !digitsPattern.MatchString(value) {
+			return 0, ErrInvalidFormat
+		}
+		return Parse(value)
+	case "id":
+		return 0, ErrVanityURL
+	}
+	return 0, ErrInvalidFormat
+}
+
+// VanityName returns the custom name of a steamcommunity.com/id/<name> URL
+func VanityName(input string) (string, bool) {
+	kind, value, ok := profileURLSegments(strings.TrimSpace(input))
+	if !ok || kind != "id" || value == "" {
+		return "", false
+	}
+	return value, true
+}
+
+// profileURLSegments splits a steamcommunity.com URL into "profiles" or "id" and the value after it
+func profileURLSegments(input string) (string, string, bool) {
+	if !strings.Contains(input, "://") {
+		input = "https://" + input
+	}
+	u, err := url.Parse(input)
+	if err != nil || strings.TrimPrefix(u.Hostname(), "www.") != steamCommunityHost {
+		return "", "", false
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) < 2 {
+		return "", "", false
+	}
+	return segments[0], segments[1], true
+}
+
+// checked rejects IDs outside the public universe, of a non-individual type or without an account
+func checked(id SteamID) (SteamID, error) {
+	if id.Universe() != universePublic || id.AccountType() != typeIndividual || id.AccountID() == 0 {
+		return 0, ErrInvalidAccountType
+	}
+	// Individual accounts are always addressed through the desktop instance
+	return FromAccountID(id.AccountID()), nil
+}
+
+// AccountID returns the 32-bit account number
+func (id SteamID) AccountID() uint32 {
+	return uint32(uint64(id) & accountIDMask)
+}
+
+// AccountType returns the account type, 1 for individual accounts
+func (id SteamID) AccountType() int {
+	return int(uint64(id) >> typeShift & typeMask)
+}
+
+// Universe returns the universe, 1 for public accounts
+func (id SteamID) Universe() int {
+	return int(uint64(id) >> universeShift)
+}
+
+// String returns the SteamID64 in decimal
+func (id SteamID) String() string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// Steam2 returns the STEAM_1:Y:Z form
+func (id SteamID) Steam2() string {
+	return fmt.Sprintf("STEAM_1:%d:%d", id.AccountID()&1, id.AccountID()>>1)
+}
+
+// Steam3 returns the [U:1:N] form
+func (id SteamID) Steam3() string {
+	return fmt.Sprintf("[U:1:%d]", id.AccountID())
+}
+
+// Normalize parses any supported format and returns the SteamID64 string
+func Normalize(input string) (string, error) {
+	id, err := Parse(input)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
@@ -0,0 +1,107 @@
+package steamid
+
+import (
+	"errors"
+	"testing"
+)
+
+const gabeN = "76561197960287930"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"SteamID64", gabeN},
+		{"SteamID64 with spaces", "  " + gabeN + "\n"},
+		{"Steam2 universe 0", "STEAM_0:0:11101"},
+		{"Steam2 universe 1", "STEAM_1:0:11101"},
+		{"Steam3", "[U:1:22202]"},
+		{"Steam3 with instance", "[U:1:22202:1]"},
+		{"profile URL", "https://steamcommunity.com/profiles/" + gabeN + "/"},
+		{"profile URL without scheme", "www.steamcommunity.com/profiles/" + gabeN},
+		{"SteamID64 of another instance", "76561202255255226"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Normalize(tt.input)
+			if err != nil {
+				t.Fatalf("Normalize(%q) error: %v", tt.input, err)
+			}
+			if got != gabeN {
+				t.Errorf("Normalize(%q) = %s, want %s", tt.input, got, gabeN)
+			}
+		})
+	}
+}
+
+func TestNormalizeInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  error
+	}{
+		{"empty", "", ErrInvalidFormat},
+		{"garbage", "gaben", ErrInvalidFormat},
+		{"Steam2 universe 2", "STEAM_2:0:11101", ErrInvalidFormat},
+		{"Steam2 universe 5", "STEAM_5:0:11101", ErrInvalidFormat},
+		{"Steam2 bad parity", "STEAM_1:2:11101", ErrInvalidFormat},
+		{"Steam2 account out of range", "STEAM_1:0:2147483648", ErrInvalidFormat},
+		{"Steam3 group", "[g:1:4]", ErrInvalidAccountType},
+		{"Steam3 beta universe", "[U:2:22202]", ErrInvalidAccountType},
+		{"Steam3 account out of range", "[U:1:4294967296]", ErrInvalidFormat},
+		{"SteamID64 overflow", "99999999999999999999", ErrInvalidFormat},
+		{"SteamID64 of a group", "103582791429521412", ErrInvalidAccountType},
+		{"SteamID64 without account", "76561197960265728", ErrInvalidAccountType},
+		{"account ID alone", "22202", ErrInvalidAccountType},
+		{"vanity URL", "https://steamcommunity.com/id/gabelogannewell", ErrVanityURL},
+		{"profile URL with name", "https://steamcommunity.com/profiles/gaben", ErrInvalidFormat},
+		{"other host", "https://example.com/profiles/" + gabeN, ErrInvalidFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Normalize(tt.input); !errors.Is(err, tt.want) {
+				t.Errorf("Normalize(%q) = %q, %v, want %v", tt.input, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVanityName(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		ok    bool
+	}{
+		{"https://steamcommunity.com/id/gabelogannewell/", "gabelogannewell", true},
+		{"steamcommunity.com/id/gabelogannewell", "gabelogannewell", true},
+		{"https://steamcommunity.com/profiles/" + gabeN, "", false},
+		{"https://example.com/id/gabelogannewell", "", false},
+		{"gabelogannewell", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := VanityName(tt.input)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("VanityName(%q) = %q, %v, want %q, %v", tt.input, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFormats(t *testing.T) {
+	id, err := Parse(gabeN)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if id.AccountID() != 22202 || id.Universe() != 1 || id.AccountType() != 1 {
+		t.Errorf("fields = account %d, universe %d, type %d", id.AccountID(), id.Universe(), id.AccountType())
+	}
+	if got := id.Steam2(); got != "STEAM_1:0:11101" {
+		t.Errorf("Steam2() = %s", got)
+	}
+	if got := id.Steam3(); got != "[U:1:22202]" {
+		t.Errorf("Steam3() = %s", got)
+	}
+}
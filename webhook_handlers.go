@@ -1,21 +1,29 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"strings"
 
+	"cs-match-summary-bot/steamid"
+	"cs-match-summary-bot/webhooks"
 	"github.com/bwmarrin/discordgo"
 	"github.com/gin-gonic/gin"
 )
 
 // DemoReadyPayload represents the webhook payload when a demo is ready
 type DemoReadyPayload struct {
-	Success bool `json:"success"`
+	Success bool   `json:"success"`
 	Message string `json:"message"`
-	Data struct {
+	Reason  string `json:"reason,omitempty"` // Classifies failures, e.g. "expired" or "transient"
+	Data    struct {
 		ShareCode string `json:"share_code"`
 		DemoPath  string `json:"demo_path"`
 	} `json:"data"`
@@ -23,12 +31,13 @@ type DemoReadyPayload struct {
 
 // DemoParsedPayload represents the webhook payload when a demo is parsed
 type DemoParsedPayload struct {
-	Success bool `json:"success"`
+	Success bool   `json:"success"`
 	Message string `json:"message"`
-	Data struct {
-		ShareCode string      `json:"share_code"`
-		DemoPath  string      `json:"demo_path"`
-		Stats     interface{} `json:"stats"` // Placeholder for future stats implementation
+	Reason  string `json:"reason,omitempty"` // Classifies failures, e.g. "parse_failed" or "transient"
+	Data    struct {
+		ShareCode string          `json:"share_code"`
+		DemoPath  string          `json:"demo_path"`
+		Stats     json.RawMessage `json:"stats"` // Decoded strictly into MatchStats
 	} `json:"data"`
 }
 
@@ -49,27 +58,33 @@ func SetWebhookContext(session *discordgo.Session) {
 // HandleDemoReady processes the demo ready webhook
 func HandleDemoReady(c *gin.Context) {
 	var payload DemoReadyPayload
-	
+
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		log.Printf("Invalid JSON payload: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
 		return
 	}
-	
+
+	callback, ok := verifyCallback(c, &payload.Data.ShareCode)
+	if !ok {
+		return
+	}
+	defer releaseCallback(c, callback)
+
 	// Validate payload structure
 	if !payload.Success {
-		log.Printf("Demo ready webhook reported failure: %s", payload.Message)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Demo processing failed"})
+		log.Printf("Demo ready webhook reported failure for %s: %s", payload.Data.ShareCode, payload.Message)
+		handleDemoFailure(c, callback.Stage, payload.Data.ShareCode, payload.Reason, payload.Message)
 		return
 	}
-	
+
 	if payload.Data.ShareCode == "" || payload.Data.DemoPath == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields: share_code, demo_path"})
 		return
 	}
-	
+
 	log.Printf("Demo ready received: %s at %s", payload.Data.ShareCode, payload.Data.DemoPath)
-	
+
 	// Create or update game record
 	_, err := createOrUpdateGame(payload.Data.ShareCode, payload.Data.DemoPath)
 	if err != nil {
@@ -77,18 +92,36 @@ func HandleDemoReady(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process game"})
 		return
 	}
-	
+
+	// Record the stage; a duplicate callback for a job that already moved on is acknowledged and ignored
+	advanced, err := advanceMatchJobTo(payload.Data.ShareCode, MatchJobDemoReady)
+	if err != nil {
+		log.Printf("Error updating match job for %s: %v", payload.Data.ShareCode, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update match job"})
+		return
+	}
+	if !advanced {
+		log.Printf("Ignoring duplicate demo ready webhook for %s", payload.Data.ShareCode)
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"message": "Demo ready already processed",
+		})
+		return
+	}
+
 	// Request demo parsing
 	if steamPoller != nil {
-		err = steamPoller.GetDemoParsingRequest()(payload.Data.ShareCode)
+		err = steamPoller.requestDemoParsing(c.Request.Context(), payload.Data.ShareCode)
 		if err != nil {
-			log.Printf("Error requesting demo parsing for %s: %v", payload.Data.ShareCode, err)
-			// Don't fail the webhook, just log the error
+			// Don't fail the webhook, the reconciler retries the request
+			recordMatchJobError(payload.Data.ShareCode, 1, fmt.Errorf("failed to request demo parsing: %w", err))
+		} else if _, err := advanceMatchJobTo(payload.Data.ShareCode, MatchJobParseRequested); err != nil {
+			log.Printf("Error updating match job for %s: %v", payload.Data.ShareCode, err)
 		} else {
 			log.Printf("Successfully requested demo parsing for %s", payload.Data.ShareCode)
 		}
 	}
-	
+
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "success",
 		"message": "Demo ready processed successfully",
@@ -98,53 +131,212 @@ func HandleDemoReady(c *gin.Context) {
 // HandleDemoParsed processes the demo parsed webhook
 func HandleDemoParsed(c *gin.Context) {
 	var payload DemoParsedPayload
-	
+
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		log.Printf("Invalid JSON payload: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
 		return
 	}
-	
+
+	callback, ok := verifyCallback(c, &payload.Data.ShareCode)
+	if !ok {
+		return
+	}
+	defer releaseCallback(c, callback)
+
 	// Validate payload structure
 	if !payload.Success {
-		log.Printf("Demo parsing webhook reported failure: %s", payload.Message)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Demo parsing failed"})
+		log.Printf("Demo parsing webhook reported failure for %s: %s", payload.Data.ShareCode, payload.Message)
+		handleDemoFailure(c, callback.Stage, payload.Data.ShareCode, payload.Reason, payload.Message)
 		return
 	}
-	
+
 	if payload.Data.ShareCode == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required field: share_code"})
 		return
 	}
-	
+
+	stats, err := decodeMatchStats(payload.Data.Stats)
+	if err != nil {
+		log.Printf("Invalid stats for %s: %v", payload.Data.ShareCode, err)
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stats payload", "details": verr.Problems})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stats payload"})
+		}
+		return
+	}
+
 	log.Printf("Demo parsing completed for: %s", payload.Data.ShareCode)
-	
+
+	// A duplicate callback for a match whose summaries were already sent is acknowledged and ignored
+	job, err := getMatchJob(payload.Data.ShareCode)
+	if err == nil && (job.Status == MatchJobParsed || job.Status == MatchJobNotified) {
+		log.Printf("Ignoring duplicate demo parsed webhook for %s", payload.Data.ShareCode)
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"message": "Demo parsing already processed",
+		})
+		return
+	}
+
 	// Get the game from database
-	game, err := getGameByShareCode(payload.Data.ShareCode)
+	game, err := findGameByShareCode(payload.Data.ShareCode)
 	if err != nil {
 		log.Printf("Error getting game %s: %v", payload.Data.ShareCode, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get game"})
 		return
 	}
-	
+
+	// Persist the parsed stats
+	err = saveMatchStats(game.UUID, stats)
+	if err != nil {
+		log.Printf("Error saving stats for %s: %v", payload.Data.ShareCode, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save stats"})
+		return
+	}
+
+	// Record who played and link the game to their users and guilds
+	guilds, err := linkMatchParticipants(game, stats.SteamIDs())
+	if err != nil {
+		log.Printf("Error linking participants for %s: %v", payload.Data.ShareCode, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link match participants"})
+		return
+	}
+
+	// Stats are stored, from here on the reconciler can finish the job
+	advanced, err := advanceMatchJobTo(payload.Data.ShareCode, MatchJobParsed)
+	if err != nil {
+		log.Printf("Error updating match job for %s: %v", payload.Data.ShareCode, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update match job"})
+		return
+	}
+	if !advanced {
+		log.Printf("Match job %s was already parsed, not sending summaries again", payload.Data.ShareCode)
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"message": "Demo parsing already processed",
+		})
+		return
+	}
+
 	// Send match summary to all guilds that have this game
-	err = sendMatchSummaryToGuilds(game, payload.Data.Stats)
+	err = sendMatchSummaryToGuilds(guilds, game, stats)
 	if err != nil {
-		log.Printf("Error sending match summaries: %v", err)
-		// Don't fail the webhook, just log the error
+		// Don't fail the webhook, the reconciler sends the summaries later
+		recordMatchJobError(payload.Data.ShareCode, 1, fmt.Errorf("failed to send match summaries: %w", err))
+	} else if _, err := advanceMatchJobTo(payload.Data.ShareCode, MatchJobNotified); err != nil {
+		log.Printf("Error updating match job for %s: %v", payload.Data.ShareCode, err)
 	}
-	
+
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "success",
 		"message": "Demo parsing completed successfully",
 	})
 }
 
+// verifyCallback checks that a signed callback is for the share code in its payload and
+// claims its URL, so it can't be replayed or handled twice concurrently. A payload
+// without a share code takes the one of the URL. Rejected callbacks are answered here.
+func verifyCallback(c *gin.Context, shareCode *string) (webhooks.Callback, bool) {
+	callback, ok := webhooks.CallbackFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": webhooks.ErrUnsigned.Error()})
+		return callback, false
+	}
+
+	if *shareCode == "" {
+		*shareCode = callback.ShareCode
+	}
+	if *shareCode != callback.ShareCode {
+		log.Printf("Rejecting %s webhook for %s signed for %s", callback.Stage, *shareCode, callback.ShareCode)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Share code does not match the callback URL"})
+		return callback, false
+	}
+
+	claimed, err := claimMatchJobCallbackNonce(callback.ShareCode, callback.Nonce)
+	if err != nil {
+		log.Printf("Error claiming callback for %s: %v", callback.ShareCode, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify callback"})
+		return callback, false
+	}
+	if !claimed {
+		log.Printf("Rejecting replayed or superseded %s webhook for %s", callback.Stage, callback.ShareCode)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Callback URL was already used or replaced"})
+		return callback, false
+	}
+
+	return callback, true
+}
+
+// releaseCallback gives the claimed callback URL back when the payload was rejected or
+// the webhook failed on the bot's side, so the demo service can retry it. Handled
+// webhooks keep it used up.
+func releaseCallback(c *gin.Context, callback webhooks.Callback) {
+	if c.Writer.Status() < http.StatusMultipleChoices {
+		return
+	}
+	if err := restoreMatchJobCallbackNonce(callback.ShareCode, callback.Nonce); err != nil {
+		log.Printf("Error restoring callback for %s: %v", callback.ShareCode, err)
+	}
+}
+
+// handleDemoFailure records a success:false callback on the match job and acknowledges it.
+// Transient failures are retried by the reconciler, anything else fails the job and
+// tells the guilds the demo is unavailable. code is the payload's reason field.
+func handleDemoFailure(c *gin.Context, stage, shareCode, code, message string) {
+	job, err := getMatchJob(shareCode)
+	if err != nil {
+		log.Printf("Error getting match job %s: %v", shareCode, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get match job"})
+		return
+	}
+
+	if job.Status == MatchJobParsed || job.Status == MatchJobNotified || job.Status == MatchJobFailed {
+		log.Printf("Ignoring demo service failure for %s in %s", shareCode, job.Status)
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"message": "Match already processed",
+		})
+		return
+	}
+
+	reason, transient := classifyDemoFailure(stage, code, message)
+	cause := fmt.Errorf("demo service reported %s: %s", reason, message)
+
+	// The callback answers the request the job was waiting on, which counts as an attempt
+	attempts := job.Attempts + 1
+	if transient && attempts < matchJobMaxAttempts {
+		recordMatchJobError(shareCode, attempts, cause)
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"message": "Failure recorded, retry scheduled",
+		})
+		return
+	}
+
+	log.Printf("Match job %s failed: %v", shareCode, cause)
+	if err := failMatchJob(shareCode, cause.Error()); err != nil {
+		log.Printf("Error failing match job %s: %v", shareCode, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update match job"})
+		return
+	}
+	if err := notifyDemoUnavailable(job, reason); err != nil {
+		log.Printf("Error notifying guilds about unavailable demo %s: %v", shareCode, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Failure recorded",
+	})
+}
+
 // createOrUpdateGame creates a new game or updates existing game with demo path
 func createOrUpdateGame(shareCode, demoPath string) (*Game, error) {
 	// Try to get existing game
-	game, err := getGameByShareCode(shareCode)
-	if err == sql.ErrNoRows {
+	game, err := findGameByShareCode(shareCode)
+	if errors.Is(err, sql.ErrNoRows) {
 		// Create new game - we'll get steam IDs when we have the stats
 		game, err = createGame(shareCode, demoPath, []string{})
 		if err != nil {
@@ -160,144 +352,113 @@ func createOrUpdateGame(shareCode, demoPath string) (*Game, error) {
 			return nil, fmt.Errorf("failed to update game: %w", err)
 		}
 	}
-	
+
 	return game, nil
 }
 
-// sendMatchSummaryToGuilds sends match summary to all guilds that have registered users for this match
-func sendMatchSummaryToGuilds(game *Game, stats interface{}) error {
+// sendMatchSummaryToGuilds sends match summary to all guilds that have registered users for this match.
+// Guilds that already got the summary are skipped and every successful send is recorded, so a
+// retry only reaches the guilds that missed it. It returns the errors of every guild the
+// summary could not be sent to, so the job stays parsed and the reconciler retries it.
+func sendMatchSummaryToGuilds(guilds map[string]*Guild, game *Game, stats *MatchStats) error {
 	if webhookCtx == nil || webhookCtx.DiscordSession == nil {
 		return fmt.Errorf("Discord session not available")
 	}
-	
-	// Find all guilds that have users who played in this match
-	guildsToNotify := make(map[string]*Guild)
-	
-	for _, steamID := range game.SteamIDs {
-		user, err := getUserBySteamID(steamID)
-		if err != nil {
-			continue // User not registered, skip
-		}
-		
-		// Find guilds that have this user
-		allGuilds, err := getAllGuilds()
-		if err != nil {
-			continue
-		}
-		
-		for _, guild := range allGuilds {
-			for _, userIDStr := range guild.UserIDs {
-				if userIDStr == user.UUID.String() {
-					guildsToNotify[guild.GuildID] = guild
-				}
-			}
-		}
+	if len(guilds) == 0 {
+		return nil
+	}
+
+	sent, err := getMatchNotifications(game.UUID)
+	if err != nil {
+		return err
 	}
-	
+
 	// Send notification to each guild
-	for _, guild := range guildsToNotify {
+	var errs []error
+	for _, guild := range guilds {
+		if sent[guild.GuildID] {
+			continue
+		}
 		err := sendMatchSummary(guild, game, stats)
 		if err != nil {
 			log.Printf("Error sending match summary to guild %s: %v", guild.GuildID, err)
+			errs = append(errs, fmt.Errorf("guild %s: %w", guild.GuildID, err))
+			continue
+		}
+		// The summary is out, failing to record it only risks a duplicate on the next retry
+		if err := recordMatchNotification(game.UUID, guild.GuildID); err != nil {
+			log.Printf("Error recording match summary for guild %s: %v", guild.GuildID, err)
 		}
 	}
-	
-	return nil
+
+	return errors.Join(errs...)
 }
 
 // sendMatchSummary sends a match summary embed to a specific guild
-func sendMatchSummary(guild *Guild, game *Game, stats interface{}) error {
-	embed := &discordgo.MessageEmbed{
-		Title: "🎯 CS Match Summary",
-		Color: 0x00ff00,
-		Fields: []*discordgo.MessageEmbedField{
-			{
-				Name:   "Share Code",
-				Value:  fmt.Sprintf("`%s`", game.ShareCode),
-				Inline: true,
-			},
-			{
-				Name:   "Demo File",
-				Value:  fmt.Sprintf("`%s`", game.DemoName),
-				Inline: true,
-			},
-			{
-				Name:   "Players",
-				Value:  fmt.Sprintf("%d players", len(game.SteamIDs)),
-				Inline: true,
-			},
-		},
-		Footer: &discordgo.MessageEmbedFooter{
-			Text: "Match analysis completed",
-		},
-	}
-	
-	// Add registered players for this guild
-	var registeredPlayers []string
+func sendMatchSummary(guild *Guild, game *Game, stats *MatchStats) error {
+	// Find the players registered in this guild so they can be highlighted and pinged
+	registered := make(map[string]bool)
+	var mentions []string
 	for _, steamID := range game.SteamIDs {
 		user, err := getUserBySteamID(steamID)
 		if err == nil {
 			// Check if this user is in the current guild
 			for _, userIDStr := range guild.UserIDs {
 				if userIDStr == user.UUID.String() {
-					registeredPlayers = append(registeredPlayers, steamID)
+					registered[steamID] = true
+					if user.DiscordUserID != "" && !slices.Contains(mentions, user.DiscordUserID) {
+						mentions = append(mentions, user.DiscordUserID)
+					}
 					break
 				}
 			}
 		}
 	}
-	
-	if len(registeredPlayers) > 0 {
-		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
-			Name:   "Registered Players",
-			Value:  fmt.Sprintf("```\n%s\n```", strings.Join(registeredPlayers, "\n")),
-			Inline: false,
-		})
-	}
-	
-	// TODO: Add stats fields when stats schema is implemented
-	if stats != nil {
-		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
-			Name:   "Stats",
-			Value:  "📊 Match statistics available (schema TBD)",
-			Inline: false,
-		})
-	}
-	
-	_, err := webhookCtx.DiscordSession.ChannelMessageSendEmbed(guild.ChannelID, embed)
-	return err
-}
 
-// getAllGuilds retrieves all guilds from the database
-func getAllGuilds() ([]*Guild, error) {
-	query := `
-		SELECT uuid, guild_id, channel_id, user_ids, game_ids, created_at, updated_at
-		FROM guilds ORDER BY created_at`
+	// Show persona names for players the demo had no name for, and the best registered player's avatar
+	profiles := lookupSteamProfiles(context.Background(), game.SteamIDs)
+	stats = withPersonaNames(stats, profiles)
 
-	rows, err := db.Query(query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get all guilds: %w", err)
+	embed := buildMatchSummaryEmbed(game, stats, registered)
+	if avatar := summaryAvatar(game, stats, registered, profiles); avatar != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: avatar}
 	}
-	defer rows.Close()
-
-	var guilds []*Guild
-	for rows.Next() {
-		guild := &Guild{}
-		err := rows.Scan(
-			&guild.UUID, &guild.GuildID, &guild.ChannelID, &guild.UserIDs, &guild.GameIDs,
-			&guild.CreatedAt, &guild.UpdatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan guild: %w", err)
+	message := &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{embed},
+		// Only ping the linked members who played, never roles or everyone
+		AllowedMentions: &discordgo.MessageAllowedMentions{
+			Users: mentions,
+		},
+	}
+	if len(mentions) > 0 {
+		content := make([]string, len(mentions))
+		for n, id := range mentions {
+			content[n] = "<@" + id + ">"
 		}
-		guilds = append(guilds, guild)
+		message.Content = strings.Join(content, " ")
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating over guilds: %w", err)
+	// Attach the rendered scoreboard, falling back to the text embed if rendering fails
+	if stats != nil {
+		image, err := renderScoreboardPNG(stats, registered)
+		if err != nil {
+			log.Printf("Error rendering scoreboard for %s: %v", game.ShareCode, err)
+		} else {
+			message.Files = []*discordgo.File{
+				{
+					Name:        scoreboardFileName,
+					ContentType: "image/png",
+					Reader:      bytes.NewReader(image),
+				},
+			}
+			embed.Image = &discordgo.MessageEmbedImage{
+				URL: "attachment://" + scoreboardFileName,
+			}
+		}
 	}
 
-	return guilds, nil
+	_, err := webhookCtx.DiscordSession.ChannelMessageSendComplex(guild.ChannelID, message)
+	return err
 }
 
 // HandleMatchQuery handles queries for match information
@@ -307,10 +468,10 @@ func HandleMatchQuery(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Share code is required"})
 		return
 	}
-	
-	game, err := getGameByShareCode(shareCode)
+
+	game, err := findGameByShareCode(shareCode)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, sql.ErrNoRows) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Match not found"})
 		} else {
 			log.Printf("Error querying match: %v", err)
@@ -318,10 +479,13 @@ func HandleMatchQuery(c *gin.Context) {
 		}
 		return
 	}
-	
+
 	c.JSON(http.StatusOK, gin.H{
 		"uuid":       game.UUID.String(),
 		"share_code": game.ShareCode,
+		"match_id":   game.MatchID,
+		"outcome_id": game.OutcomeID,
+		"token":      game.Token,
 		"demo_name":  game.DemoName,
 		"steam_ids":  game.SteamIDs,
 		"created_at": game.CreatedAt,
@@ -331,15 +495,20 @@ func HandleMatchQuery(c *gin.Context) {
 
 // HandleUserQuery handles queries for user information
 func HandleUserQuery(c *gin.Context) {
-	steamID := c.Param("steamID")
-	if steamID == "" {
+	if c.Param("steamID") == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Steam ID is required"})
 		return
 	}
-	
+
+	steamID, err := steamid.Normalize(c.Param("steamID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Steam ID", "details": err.Error()})
+		return
+	}
+
 	user, err := getUserBySteamID(steamID)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, sql.ErrNoRows) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		} else {
 			log.Printf("Error querying user: %v", err)
@@ -347,20 +516,20 @@ func HandleUserQuery(c *gin.Context) {
 		}
 		return
 	}
-	
+
 	// Get user's games
 	games, err := getGamesBySteamID(steamID)
 	if err != nil {
 		log.Printf("Error getting user games: %v", err)
 		games = []*Game{} // Empty slice on error
 	}
-	
+
 	c.JSON(http.StatusOK, gin.H{
-		"uuid":        user.UUID.String(),
-		"steam_id":    user.SteamID,
-		"game_count":  len(games),
-		"created_at":  user.CreatedAt,
-		"updated_at":  user.UpdatedAt,
+		"uuid":       user.UUID.String(),
+		"steam_id":   user.SteamID,
+		"game_count": len(games),
+		"created_at": user.CreatedAt,
+		"updated_at": user.UpdatedAt,
 	})
 }
 
@@ -371,10 +540,10 @@ func HandleGuildQuery(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Guild ID is required"})
 		return
 	}
-	
+
 	guild, err := getGuildByGuildID(guildID)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, sql.ErrNoRows) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Guild not found"})
 		} else {
 			log.Printf("Error querying guild: %v", err)
@@ -382,14 +551,14 @@ func HandleGuildQuery(c *gin.Context) {
 		}
 		return
 	}
-	
+
 	// Get guild's games
 	games, err := getGamesForGuild(guildID)
 	if err != nil {
 		log.Printf("Error getting guild games: %v", err)
 		games = []*Game{} // Empty slice on error
 	}
-	
+
 	c.JSON(http.StatusOK, gin.H{
 		"uuid":       guild.UUID.String(),
 		"guild_id":   guild.GuildID,
@@ -399,4 +568,14 @@ func HandleGuildQuery(c *gin.Context) {
 		"created_at": guild.CreatedAt,
 		"updated_at": guild.UpdatedAt,
 	})
-}
\ No newline at end of file
+}
+
+// HandlePollerMetrics reports the Steam poller's cycle duration, lag and error counts
+func HandlePollerMetrics(c *gin.Context) {
+	if steamPoller == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Steam poller is not running"})
+		return
+	}
+
+	c.JSON(http.StatusOK, steamPoller.Metrics())
+}
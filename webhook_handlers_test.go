@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"cs-match-summary-bot/webhooks"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	testShareCode = "CSGO-GADqf-jjyJ8-cSP2r-smZRo-TO2xK"
+	testMatchID   = 3230642215713767580
+	testNonce     = "0123456789abcdef0123456789abcdef"
+)
+
+var testSigner = webhooks.NewSigner([]byte("test secret"))
+
+// newWebhookRouter serves the demo callbacks the way webhooks.StartServer does
+func newWebhookRouter() *gin.Engine {
+	r := gin.New()
+	r.POST("/webhooks/"+webhooks.StageDemoReady, webhooks.RequireSignature(testSigner, webhooks.StageDemoReady), HandleDemoReady)
+	r.POST("/webhooks/"+webhooks.StageDemoParsed, webhooks.RequireSignature(testSigner, webhooks.StageDemoParsed), HandleDemoParsed)
+	return r
+}
+
+// postCallback posts payload to a callback URL signed for stage and nonce
+func postCallback(t *testing.T, r *gin.Engine, stage, shareCode, nonce string, payload interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	callbackURL, err := url.Parse(testSigner.CallbackURL("http://bot.test", stage, shareCode, nonce, time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatalf("failed to parse callback URL: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, callbackURL.RequestURI(), bytes.NewReader(jsonb(t, payload)))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// expectValidNonce lets the callback nonce of shareCode be claimed once
+func expectValidNonce(mock sqlmock.Sqlmock, shareCode, nonce string) {
+	expectClaimNonce(mock, shareCode, nonce, true)
+}
+
+// expectClaimNonce makes claiming the callback nonce of shareCode succeed or find it used once
+func expectClaimNonce(mock sqlmock.Sqlmock, shareCode, nonce string, valid bool) {
+	var rows int64
+	if valid {
+		rows = 1
+	}
+	mock.ExpectExec(`UPDATE match_jobs SET callback_nonce = ''\s+WHERE share_code = \$1 AND callback_nonce = \$2`).
+		WithArgs(shareCode, nonce).
+		WillReturnResult(sqlmock.NewResult(0, rows))
+}
+
+// expectRestoreNonce expects the claimed callback nonce of shareCode to be given back once
+func expectRestoreNonce(mock sqlmock.Sqlmock, shareCode, nonce string) {
+	mock.ExpectExec(`UPDATE match_jobs SET callback_nonce = \$2 WHERE share_code = \$1 AND callback_nonce = ''`).
+		WithArgs(shareCode, nonce).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// testStats is a one round match between a registered and an unregistered player
+func testStats(registered, unregistered string) *MatchStats {
+	return &MatchStats{
+		Map:   "de_inferno",
+		TeamA: TeamStats{Name: "Team A", Score: 1, StartingSide: SideCT},
+		TeamB: TeamStats{Name: "Team B", Score: 0, StartingSide: SideT},
+		Players: []PlayerStats{
+			{SteamID: registered, Name: "registered", Team: TeamA, Kills: 5, Deaths: 1, Assists: 0},
+			{SteamID: unregistered, Name: "stranger", Team: TeamB, Kills: 1, Deaths: 5, Assists: 0},
+		},
+		Rounds: []RoundResult{
+			{Number: 1, WinnerTeam: TeamA, WinnerSide: SideCT, Reason: RoundWinElimination},
+		},
+	}
+}
+
+// demoParsedPayload builds the callback the demo service sends for parsed stats
+func demoParsedPayload(t *testing.T, shareCode string, stats *MatchStats) DemoParsedPayload {
+	t.Helper()
+	var payload DemoParsedPayload
+	payload.Success = true
+	payload.Message = "Demo parsed."
+	payload.Data.ShareCode = shareCode
+	payload.Data.DemoPath = "/demos/match.dem"
+	payload.Data.Stats = jsonb(t, stats)
+	return payload
+}
+
+func TestHandleDemoParsedLinksRosterAndNotifiesGuilds(t *testing.T) {
+	mock := mockDB(t)
+	discord := newFakeDiscord(t)
+
+	registered := &User{UUID: uuid.New(), SteamID: "76561198000000001", AuthCode: "AAAA-AAAAA-AAAA", DiscordUserID: "111"}
+	stranger := "76561198000000002"
+	guild := &Guild{UUID: uuid.New(), GuildID: "guild-1", ChannelID: "channel-1", UserIDs: StringSlice{registered.UUID.String()}}
+	game := &Game{UUID: uuid.New(), ShareCode: testShareCode, MatchID: testMatchID, DemoName: "/demos/match.dem", SteamIDs: StringSlice{}}
+	stats := testStats(registered.SteamID, stranger)
+	gameJSON := `["` + game.UUID.String() + `"]`
+
+	expectValidNonce(mock, testShareCode, testNonce)
+	expectMatchJob(t, mock, testShareCode, MatchJobParseRequested, []string{registered.SteamID})
+	expectGame(t, mock, game)
+	expectSaveMatchStats(mock, stats)
+
+	// The roster of the parsed demo is stored and linked to the registered player and their guild
+	mock.ExpectExec(`UPDATE games\s+SET demo_name = \$2, steam_ids = \$3`).
+		WithArgs(game.UUID, game.DemoName, jsonArg{`["76561198000000001","76561198000000002"]`}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUser(t, mock, registered)
+	mock.ExpectExec(`UPDATE users\s+SET game_ids`).
+		WithArgs(registered.SteamID, gameJSON).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectGuildsForUser(t, mock, registered, guild)
+	mock.ExpectExec(`UPDATE guilds\s+SET game_ids`).
+		WithArgs(guild.GuildID, gameJSON).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectNoUser(mock, stranger)
+	expectAdvance(mock, testShareCode, MatchJobParsed)
+
+	// The summary looks the players up again to highlight and ping the guild's members
+	expectUser(t, mock, registered)
+	expectNoUser(mock, stranger)
+	expectNoSteamProfiles(mock)
+	expectMatchNotifications(mock, game)
+	expectRecordNotification(mock, game, guild.GuildID)
+	expectAdvance(mock, testShareCode, MatchJobNotified)
+
+	w := postCallback(t, newWebhookRouter(), webhooks.StageDemoParsed, testShareCode, testNonce, demoParsedPayload(t, testShareCode, stats))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+
+	messages := discord.sent()
+	if len(messages) != 1 {
+		t.Fatalf("sent %d messages, want 1: %+v", len(messages), messages)
+	}
+	message := messages[0]
+	if message.ChannelID != guild.ChannelID {
+		t.Errorf("summary sent to %s, want %s", message.ChannelID, guild.ChannelID)
+	}
+	if message.Content != "<@111>" {
+		t.Errorf("content = %q, want the registered player's mention", message.Content)
+	}
+	if len(message.Embeds) != 1 || len(message.Files) != 1 || message.Files[0] != scoreboardFileName {
+		t.Errorf("summary = %d embeds, files %v, want one embed and the scoreboard", len(message.Embeds), message.Files)
+	}
+}
+
+func TestHandleDemoParsedWithoutRegisteredPlayers(t *testing.T) {
+	mock := mockDB(t)
+	discord := newFakeDiscord(t)
+
+	game := &Game{UUID: uuid.New(), ShareCode: testShareCode, MatchID: testMatchID, DemoName: "/demos/match.dem", SteamIDs: StringSlice{}}
+	stats := testStats("76561198000000003", "76561198000000004")
+
+	expectValidNonce(mock, testShareCode, testNonce)
+	expectMatchJob(t, mock, testShareCode, MatchJobParseRequested, nil)
+	expectGame(t, mock, game)
+	expectSaveMatchStats(mock, stats)
+	mock.ExpectExec(`UPDATE games\s+SET demo_name = \$2, steam_ids = \$3`).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectNoUser(mock, "76561198000000003")
+	expectNoUser(mock, "76561198000000004")
+	expectAdvance(mock, testShareCode, MatchJobParsed)
+	expectAdvance(mock, testShareCode, MatchJobNotified)
+
+	w := postCallback(t, newWebhookRouter(), webhooks.StageDemoParsed, testShareCode, testNonce, demoParsedPayload(t, testShareCode, stats))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if messages := discord.sent(); len(messages) != 0 {
+		t.Errorf("sent %d messages, want none", len(messages))
+	}
+}
+
+func TestHandleDemoParsedIgnoresDuplicates(t *testing.T) {
+	mock := mockDB(t)
+	discord := newFakeDiscord(t)
+
+	expectValidNonce(mock, testShareCode, testNonce)
+	expectMatchJob(t, mock, testShareCode, MatchJobNotified, nil)
+
+	stats := testStats("76561198000000001", "76561198000000002")
+	w := postCallback(t, newWebhookRouter(), webhooks.StageDemoParsed, testShareCode, testNonce, demoParsedPayload(t, testShareCode, stats))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+
+	var body map[string]string
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if body["message"] != "Demo parsing already processed" {
+		t.Errorf("message = %q", body["message"])
+	}
+	if messages := discord.sent(); len(messages) != 0 {
+		t.Errorf("sent %d messages, want none", len(messages))
+	}
+}
+
+func TestHandleDemoParsedKeepsCallbackForInvalidStats(t *testing.T) {
+	mock := mockDB(t)
+	newFakeDiscord(t)
+	r := newWebhookRouter()
+
+	// A payload the bot rejects gives the callback URL back
+	expectValidNonce(mock, testShareCode, testNonce)
+	expectRestoreNonce(mock, testShareCode, testNonce)
+
+	invalid := testStats("76561198000000003", "76561198000000004")
+	invalid.TeamA.Score = 7
+	w := postCallback(t, r, webhooks.StageDemoParsed, testShareCode, testNonce, demoParsedPayload(t, testShareCode, invalid))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400, body %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("the rejected callback did not give its URL back: %v", err)
+	}
+
+	// so the corrected retry is accepted and uses it up
+	game := &Game{UUID: uuid.New(), ShareCode: testShareCode, MatchID: testMatchID, DemoName: "/demos/match.dem", SteamIDs: StringSlice{}}
+	stats := testStats("76561198000000003", "76561198000000004")
+	expectValidNonce(mock, testShareCode, testNonce)
+	expectMatchJob(t, mock, testShareCode, MatchJobParseRequested, nil)
+	expectGame(t, mock, game)
+	expectSaveMatchStats(mock, stats)
+	mock.ExpectExec(`UPDATE games\s+SET demo_name = \$2, steam_ids = \$3`).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectNoUser(mock, "76561198000000003")
+	expectNoUser(mock, "76561198000000004")
+	expectAdvance(mock, testShareCode, MatchJobParsed)
+	expectAdvance(mock, testShareCode, MatchJobNotified)
+
+	w = postCallback(t, r, webhooks.StageDemoParsed, testShareCode, testNonce, demoParsedPayload(t, testShareCode, stats))
+	if w.Code != http.StatusOK {
+		t.Fatalf("retry status = %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestDemoCallbackRejectsUnsigned(t *testing.T) {
+	mockDB(t)
+
+	stats := testStats("76561198000000001", "76561198000000002")
+	for _, target := range []string{
+		"/webhooks/" + webhooks.StageDemoParsed,
+		"/webhooks/" + webhooks.StageDemoParsed + "?share_code=" + url.QueryEscape(testShareCode) + "&nonce=" + testNonce,
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(jsonb(t, demoParsedPayload(t, testShareCode, stats))))
+		req.Header.Set("Content-Type", "application/json")
+		newWebhookRouter().ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("POST %s status = %d, want 401", target, w.Code)
+		}
+	}
+}
+
+func TestDemoCallbackRejectsShareCodeMismatch(t *testing.T) {
+	mockDB(t)
+
+	// Signed for another match, so the payload's share code is refused before the URL is claimed
+	other := "CSGO-ABCDE-FGHIJ-KLMNO-PQRST-UVWXY"
+	stats := testStats("76561198000000001", "76561198000000002")
+	w := postCallback(t, newWebhookRouter(), webhooks.StageDemoParsed, other, testNonce, demoParsedPayload(t, testShareCode, stats))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403, body %s", w.Code, w.Body)
+	}
+}
+
+func TestDemoCallbackRejectsReplayedOrSupersededURL(t *testing.T) {
+	mock := mockDB(t)
+	discord := newFakeDiscord(t)
+
+	// The nonce was used up by an earlier delivery, or replaced by a newer request
+	expectClaimNonce(mock, testShareCode, testNonce, false)
+
+	stats := testStats("76561198000000001", "76561198000000002")
+	w := postCallback(t, newWebhookRouter(), webhooks.StageDemoParsed, testShareCode, testNonce, demoParsedPayload(t, testShareCode, stats))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401, body %s", w.Code, w.Body)
+	}
+	if messages := discord.sent(); len(messages) != 0 {
+		t.Errorf("sent %d messages, want none", len(messages))
+	}
+}
+
+func TestHandleDemoReadyFailureCountsTheAttempt(t *testing.T) {
+	mock := mockDB(t)
+	discord := newFakeDiscord(t)
+
+	expectValidNonce(mock, testShareCode, testNonce)
+	expectMatchJob(t, mock, testShareCode, MatchJobDownloadRequested, nil)
+	// The job was never claimed, so the failed download request is its first attempt
+	mock.ExpectExec(`UPDATE match_jobs\s+SET attempts = GREATEST\(attempts, \$2\)`).
+		WithArgs(testShareCode, 1, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	var payload DemoReadyPayload
+	payload.Message = "Steam timed out"
+	payload.Data.ShareCode = testShareCode
+	w := postCallback(t, newWebhookRouter(), webhooks.StageDemoReady, testShareCode, testNonce, payload)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if messages := discord.sent(); len(messages) != 0 {
+		t.Errorf("sent %d messages for a transient failure, want none", len(messages))
+	}
+}
+
+func TestHandleMatchQueryNotFound(t *testing.T) {
+	mock := mockDB(t)
+
+	// Neither the decoded match ID nor the raw share code is known
+	mock.ExpectQuery(`FROM games WHERE match_id = \$1`).
+		WithArgs(uint64(testMatchID)).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid"}))
+	mock.ExpectQuery(`FROM games WHERE share_code = \$1`).
+		WithArgs(testShareCode).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid"}))
+
+	r := gin.New()
+	r.GET("/api/match/:shareCode", HandleMatchQuery)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/match/"+testShareCode, nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404, body %s", w.Code, w.Body)
+	}
+}
+
+func TestHandleDemoParsedKeepsJobParsedWhenSendFails(t *testing.T) {
+	mock := mockDB(t)
+	discord := newFakeDiscord(t)
+	discord.failSends = true
+
+	registered := &User{UUID: uuid.New(), SteamID: "76561198000000001", AuthCode: "AAAA-AAAAA-AAAA", DiscordUserID: "111"}
+	stranger := "76561198000000002"
+	guild := &Guild{UUID: uuid.New(), GuildID: "guild-1", ChannelID: "channel-1", UserIDs: StringSlice{registered.UUID.String()}}
+	game := &Game{UUID: uuid.New(), ShareCode: testShareCode, MatchID: testMatchID, DemoName: "/demos/match.dem", SteamIDs: StringSlice{}}
+	stats := testStats(registered.SteamID, stranger)
+
+	expectValidNonce(mock, testShareCode, testNonce)
+	expectMatchJob(t, mock, testShareCode, MatchJobParseRequested, []string{registered.SteamID})
+	expectGame(t, mock, game)
+	expectSaveMatchStats(mock, stats)
+	mock.ExpectExec(`UPDATE games\s+SET demo_name = \$2, steam_ids = \$3`).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUser(t, mock, registered)
+	mock.ExpectExec(`UPDATE users\s+SET game_ids`).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectGuildsForUser(t, mock, registered, guild)
+	mock.ExpectExec(`UPDATE guilds\s+SET game_ids`).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectNoUser(mock, stranger)
+	expectAdvance(mock, testShareCode, MatchJobParsed)
+	expectUser(t, mock, registered)
+	expectNoUser(mock, stranger)
+	expectNoSteamProfiles(mock)
+	expectMatchNotifications(mock, game)
+
+	// The failed send is recorded as an attempt instead of moving the job to notified
+	mock.ExpectExec(`UPDATE match_jobs\s+SET attempts = GREATEST\(attempts, \$2\)`).
+		WithArgs(testShareCode, 1, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := postCallback(t, newWebhookRouter(), webhooks.StageDemoParsed, testShareCode, testNonce, demoParsedPayload(t, testShareCode, stats))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if messages := discord.sent(); len(messages) != 0 {
+		t.Errorf("sent %d messages, want none", len(messages))
+	}
+}
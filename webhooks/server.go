@@ -8,16 +8,19 @@ import (
 
 // HandlerFunctions holds all the handler functions that can be injected from main package
 type HandlerFunctions struct {
-	DemoReady   gin.HandlerFunc
-	DemoParsed  gin.HandlerFunc
-	MatchQuery  gin.HandlerFunc
-	UserQuery   gin.HandlerFunc
-	GuildQuery  gin.HandlerFunc
+	DemoReady     gin.HandlerFunc
+	DemoParsed    gin.HandlerFunc
+	MatchQuery    gin.HandlerFunc
+	UserQuery     gin.HandlerFunc
+	GuildQuery    gin.HandlerFunc
+	PollerMetrics gin.HandlerFunc
+	// Signer verifies demo service callbacks; without it every callback is rejected
+	Signer *Signer
 }
 
 func StartServer(host, port string, handlers *HandlerFunctions) error {
 	r := gin.Default()
-	
+
 	// Default handler for demoReady if none provided
 	demoReadyHandler := func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "received"})
@@ -25,7 +28,7 @@ func StartServer(host, port string, handlers *HandlerFunctions) error {
 	if handlers != nil && handlers.DemoReady != nil {
 		demoReadyHandler = handlers.DemoReady
 	}
-	
+
 	// Default handler for demoParsed if none provided
 	demoParsedHandler := func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "received"})
@@ -33,14 +36,19 @@ func StartServer(host, port string, handlers *HandlerFunctions) error {
 	if handlers != nil && handlers.DemoParsed != nil {
 		demoParsedHandler = handlers.DemoParsed
 	}
-	
-	// Webhook endpoints
+
+	var signer *Signer
+	if handlers != nil {
+		signer = handlers.Signer
+	}
+
+	// Webhook endpoints, only reachable through signed callback URLs
 	webhooks := r.Group("/webhooks")
 	{
-		webhooks.POST("/demoReady", demoReadyHandler)
-		webhooks.POST("/demoParsed", demoParsedHandler)
+		webhooks.POST("/"+StageDemoReady, RequireSignature(signer, StageDemoReady), demoReadyHandler)
+		webhooks.POST("/"+StageDemoParsed, RequireSignature(signer, StageDemoParsed), demoParsedHandler)
 	}
-	
+
 	// API endpoints for querying data
 	if handlers != nil {
 		api := r.Group("/api/v1")
@@ -54,8 +62,11 @@ func StartServer(host, port string, handlers *HandlerFunctions) error {
 			if handlers.GuildQuery != nil {
 				api.GET("/guild/:guildID", handlers.GuildQuery)
 			}
+			if handlers.PollerMetrics != nil {
+				api.GET("/metrics/poller", handlers.PollerMetrics)
+			}
 		}
 	}
-	
+
 	return r.Run(fmt.Sprintf("%s:%s", host, port))
-}
\ No newline at end of file
+}
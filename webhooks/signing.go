@@ -0,0 +1,131 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Callback stages, also the paths under /webhooks the demo service calls
+const (
+	StageDemoReady  = "demoReady"
+	StageDemoParsed = "demoParsed"
+)
+
+// callbackContextKey is where RequireSignature stores the verified Callback
+const callbackContextKey = "webhooks.callback"
+
+var (
+	// ErrUnsigned is returned for callbacks without the signature parameters
+	ErrUnsigned = errors.New("callback is not signed")
+	// ErrBadSignature is returned when the signature doesn't match the parameters
+	ErrBadSignature = errors.New("callback signature is invalid")
+	// ErrExpired is returned for callbacks whose URL is past its expiry
+	ErrExpired = errors.New("callback URL has expired")
+)
+
+// Callback holds the verified parameters of a signed callback URL
+type Callback struct {
+	Stage     string
+	ShareCode string
+	// Nonce is unique per request to the demo service, so a callback can only be used once
+	Nonce   string
+	Expires time.Time
+}
+
+// Signer signs and verifies the callback URLs handed to the demo service. A URL
+// authenticates one callback for one stage of one share code until it expires:
+//
+//	/webhooks/<stage>?share_code=<code>&nonce=<nonce>&expires=<unix>&signature=<hex HMAC-SHA256>
+type Signer struct {
+	key []byte
+}
+
+// NewSigner creates a signer keyed with the shared webhook secret
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// CallbackURL returns the signed URL for the callback of stage under baseURL
+func (s *Signer) CallbackURL(baseURL, stage, shareCode, nonce string, expires time.Time) string {
+	query := url.Values{
+		"share_code": {shareCode},
+		"nonce":      {nonce},
+		"expires":    {strconv.FormatInt(expires.Unix(), 10)},
+		"signature":  {s.sign(stage, shareCode, nonce, expires.Unix())},
+	}
+	return strings.TrimRight(baseURL, "/") + "/webhooks/" + stage + "?" + query.Encode()
+}
+
+// Verify checks the signature and expiry of a callback for stage
+func (s *Signer) Verify(query url.Values, stage string, now time.Time) (Callback, error) {
+	shareCode, nonce := query.Get("share_code"), query.Get("nonce")
+	signature, expiresParam := query.Get("signature"), query.Get("expires")
+	if shareCode == "" || nonce == "" || signature == "" || expiresParam == "" {
+		return Callback{}, ErrUnsigned
+	}
+
+	expires, err := strconv.ParseInt(expiresParam, 10, 64)
+	if err != nil {
+		return Callback{}, ErrBadSignature
+	}
+	expected := s.sign(stage, shareCode, nonce, expires)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return Callback{}, ErrBadSignature
+	}
+	if !now.Before(time.Unix(expires, 0)) {
+		return Callback{}, ErrExpired
+	}
+
+	return Callback{
+		Stage:     stage,
+		ShareCode: shareCode,
+		Nonce:     nonce,
+		Expires:   time.Unix(expires, 0),
+	}, nil
+}
+
+// sign computes the hex HMAC-SHA256 of the signed fields
+func (s *Signer) sign(stage, shareCode, nonce string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(strings.Join([]string{stage, shareCode, nonce, strconv.FormatInt(expires, 10)}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RequireSignature rejects callbacks for stage that aren't signed by signer with 401.
+// Verified callbacks are available to the handler through CallbackFromContext.
+func RequireSignature(signer *Signer, stage string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if signer == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Webhook signing is not configured"})
+			return
+		}
+
+		callback, err := signer.Verify(c.Request.URL.Query(), stage, time.Now())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Set(callbackContextKey, callback)
+		c.Next()
+	}
+}
+
+// CallbackFromContext returns the callback verified by RequireSignature
+func CallbackFromContext(c *gin.Context) (Callback, bool) {
+	value, ok := c.Get(callbackContextKey)
+	if !ok {
+		return Callback{}, false
+	}
+	callback, ok := value.(Callback)
+	return callback, ok
+}
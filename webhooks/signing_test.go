@@ -0,0 +1,198 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testShareCode = "CSGO-GADqf-jjyJ8-cSP2r-smZRo-TO2xK"
+	testNonce     = "0123456789abcdef0123456789abcdef"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// signedQuery returns the query of a callback URL signed by signer for stage
+func signedQuery(t *testing.T, signer *Signer, stage string, expires time.Time) url.Values {
+	t.Helper()
+	callbackURL, err := url.Parse(signer.CallbackURL("http://bot.test/", stage, testShareCode, testNonce, expires))
+	if err != nil {
+		t.Fatalf("failed to parse callback URL: %v", err)
+	}
+	if callbackURL.Path != "/webhooks/"+stage {
+		t.Fatalf("callback path = %q, want /webhooks/%s", callbackURL.Path, stage)
+	}
+	return callbackURL.Query()
+}
+
+func TestSignerRoundTrip(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	now := time.Unix(1_700_000_000, 0)
+	expires := now.Add(time.Hour)
+
+	callback, err := signer.Verify(signedQuery(t, signer, StageDemoParsed, expires), StageDemoParsed, now)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	want := Callback{Stage: StageDemoParsed, ShareCode: testShareCode, Nonce: testNonce, Expires: expires}
+	if callback != want {
+		t.Errorf("Verify() = %+v, want %+v", callback, want)
+	}
+}
+
+func TestSignerRejects(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	now := time.Unix(1_700_000_000, 0)
+
+	type rejectTest struct {
+		name  string
+		query func() url.Values
+		want  error
+	}
+	tests := []rejectTest{
+		{
+			name: "tampered signature",
+			query: func() url.Values {
+				q := signedQuery(t, signer, StageDemoParsed, now.Add(time.Hour))
+				signature := []byte(q.Get("signature"))
+				signature[0] ^= 1
+				q.Set("signature", string(signature))
+				return q
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name: "tampered share code",
+			query: func() url.Values {
+				q := signedQuery(t, signer, StageDemoParsed, now.Add(time.Hour))
+				q.Set("share_code", "CSGO-ABCDE-FGHIJ-KLMNO-PQRST-UVWXY")
+				return q
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name: "extended expiry",
+			query: func() url.Values {
+				q := signedQuery(t, signer, StageDemoParsed, now.Add(time.Hour))
+				q.Set("expires", strconv.FormatInt(now.Add(48*time.Hour).Unix(), 10))
+				return q
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name: "non-numeric expiry",
+			query: func() url.Values {
+				q := signedQuery(t, signer, StageDemoParsed, now.Add(time.Hour))
+				q.Set("expires", "tomorrow")
+				return q
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name: "expired",
+			query: func() url.Values {
+				return signedQuery(t, signer, StageDemoParsed, now.Add(-time.Second))
+			},
+			want: ErrExpired,
+		},
+		{
+			name: "expires now",
+			query: func() url.Values {
+				return signedQuery(t, signer, StageDemoParsed, now)
+			},
+			want: ErrExpired,
+		},
+		{
+			name: "wrong stage",
+			query: func() url.Values {
+				return signedQuery(t, signer, StageDemoReady, now.Add(time.Hour))
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name: "other secret",
+			query: func() url.Values {
+				return signedQuery(t, NewSigner([]byte("other secret")), StageDemoParsed, now.Add(time.Hour))
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name:  "no parameters",
+			query: func() url.Values { return url.Values{} },
+			want:  ErrUnsigned,
+		},
+	}
+	for _, param := range []string{"share_code", "nonce", "expires", "signature"} {
+		tests = append(tests, rejectTest{
+			name: "missing " + param,
+			query: func() url.Values {
+				q := signedQuery(t, signer, StageDemoParsed, now.Add(time.Hour))
+				q.Del(param)
+				return q
+			},
+			want: ErrUnsigned,
+		})
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := signer.Verify(tt.query(), StageDemoParsed, now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireSignature(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+
+	var got Callback
+	handler := func(c *gin.Context) {
+		callback, ok := CallbackFromContext(c)
+		if !ok {
+			t.Error("CallbackFromContext() found no callback")
+		}
+		got = callback
+		c.Status(http.StatusOK)
+	}
+
+	serve := func(signer *Signer, query url.Values) int {
+		r := gin.New()
+		r.POST("/webhooks/"+StageDemoReady, RequireSignature(signer, StageDemoReady), handler)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhooks/"+StageDemoReady+"?"+query.Encode(), nil))
+		return w.Code
+	}
+
+	valid := signedQuery(t, signer, StageDemoReady, time.Now().Add(time.Hour))
+	if code := serve(signer, valid); code != http.StatusOK {
+		t.Errorf("signed callback status = %d, want 200", code)
+	}
+	if got.ShareCode != testShareCode || got.Nonce != testNonce || got.Stage != StageDemoReady {
+		t.Errorf("handler got %+v", got)
+	}
+
+	if code := serve(signer, url.Values{}); code != http.StatusUnauthorized {
+		t.Errorf("unsigned callback status = %d, want 401", code)
+	}
+	if code := serve(signer, signedQuery(t, signer, StageDemoParsed, time.Now().Add(time.Hour))); code != http.StatusUnauthorized {
+		t.Errorf("callback signed for another stage status = %d, want 401", code)
+	}
+	if code := serve(signer, signedQuery(t, signer, StageDemoReady, time.Now().Add(-time.Minute))); code != http.StatusUnauthorized {
+		t.Errorf("expired callback status = %d, want 401", code)
+	}
+	if code := serve(nil, valid); code != http.StatusUnauthorized {
+		t.Errorf("callback without a configured signer status = %d, want 401", code)
+	}
+}